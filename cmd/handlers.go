@@ -2,10 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/mr-karan/calert/internal/alertmanager"
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/providers/google_chat"
+	"github.com/mr-karan/calert/internal/state"
+	"github.com/prometheus/alertmanager/pkg/labels"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 )
 
@@ -114,3 +126,480 @@ func handleDispatchNotif(w http.ResponseWriter, r *http.Request) {
 
 	sendResponse(w, "dispatched")
 }
+
+// Handle interaction events (like card button clicks) from Google Chat.
+// The reply is posted by Google Chat in the thread of the message.
+func handleGoogleChatInteraction(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="interaction"}`)
+
+	// Verify the shared token configured in the Google Chat app's URL. Interactions
+	// create silences, so they're rejected if no token is configured.
+	if app.interactionToken == "" ||
+		subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(app.interactionToken)) != 1 {
+		app.metrics.Increment(`http_request_errors_total{handler="interaction"}`)
+		sendErrorResponse(w, "Invalid token.", http.StatusUnauthorized, nil)
+		return
+	}
+
+	ev, err := google_chat.ParseInteraction(r.Body)
+	if err != nil {
+		app.lo.Error("error decoding interaction event", "error", err)
+		app.metrics.Increment(`http_request_errors_total{handler="interaction"}`)
+		sendErrorResponse(w, "Error decoding payload.", http.StatusBadRequest, nil)
+		return
+	}
+
+	var text string
+	switch ev.Function {
+	case google_chat.FunctionSilence:
+		text, err = silenceFromInteraction(r.Context(), app, ev)
+	case google_chat.FunctionAck:
+		text, err = ackFromInteraction(app, ev)
+	default:
+		err = fmt.Errorf("unknown action: %s", ev.Function)
+	}
+	if err != nil {
+		app.lo.Error("error handling interaction", "function", ev.Function, "user", ev.User, "error", err)
+		app.metrics.Increment(`http_request_errors_total{handler="interaction"}`)
+		text = fmt.Sprintf("Error: %s", err)
+	}
+
+	out, err := google_chat.Reply(text, ev.Thread)
+	if err != nil {
+		sendErrorResponse(w, "Internal Server Error.", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(out)
+}
+
+// silenceFromInteraction creates an Alertmanager silence for the alert
+// whose silence button was clicked and returns the reply text.
+func silenceFromInteraction(ctx context.Context, app *App, ev google_chat.Interaction) (string, error) {
+	if app.alertmanager == nil {
+		return "", fmt.Errorf("alertmanager is not configured")
+	}
+
+	dur, err := time.ParseDuration(ev.Parameters["duration"])
+	if err != nil || dur <= 0 {
+		return "", fmt.Errorf("invalid silence duration: %q", ev.Parameters["duration"])
+	}
+
+	// The matchers are built from the labels of the active alert, rather than
+	// from the event, so that only alerts calert notified can be silenced.
+	labels, err := activeAlertLabels(app, ev.Parameters["fingerprint"])
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	id, err := app.alertmanager.CreateSilence(ctx, alertmanager.Silence{
+		Matchers:  alertmanager.MatchersFromLabels(labels),
+		StartsAt:  now,
+		EndsAt:    now.Add(dur),
+		CreatedBy: ev.User,
+		Comment:   fmt.Sprintf("Silenced from Google Chat by %s", ev.User),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating silence: %w", err)
+	}
+
+	app.lo.Info("created silence", "id", id, "user", ev.User, "duration", dur, "labels", labels)
+	return fmt.Sprintf("Silenced by %s for %s (silence ID: %s)", ev.User, ev.Parameters["duration"], id), nil
+}
+
+// activeAlertLabels returns the labels of the active alert with the fingerprint in any room.
+func activeAlertLabels(app *App, fingerprint string) (map[string]string, error) {
+	if fingerprint == "" {
+		return nil, fmt.Errorf("no fingerprint in interaction")
+	}
+
+	for _, room := range app.notifier.Rooms() {
+		aa, ok := app.notifier.ActiveAlerts(room)
+		if !ok {
+			continue
+		}
+		if a, ok := aa.Get(fingerprint); ok && len(a.Labels) > 0 {
+			return a.Labels, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no active alert with fingerprint %s", fingerprint)
+}
+
+// ackFromInteraction acknowledges the alert whose ack button was clicked, or the
+// alert of the thread in which `/ack` was sent, and returns the reply text.
+func ackFromInteraction(app *App, ev google_chat.Interaction) (string, error) {
+	fingerprint := ev.Parameters["fingerprint"]
+	if fingerprint == "" {
+		fingerprint = ev.Argument
+	}
+
+	rooms := app.notifier.Rooms()
+	if room := ev.Parameters["room"]; room != "" {
+		rooms = []string{room}
+	}
+
+	for _, room := range rooms {
+		aa, ok := app.notifier.ActiveAlerts(room)
+		if !ok {
+			continue
+		}
+
+		fp := fingerprint
+		if fp == "" {
+			// `/ack` without arguments acks the alert of the thread.
+			if fp, ok = aa.FindByThreadKey(ev.ThreadKey); !ok {
+				continue
+			}
+		}
+
+		err := aa.Ack(fp, ev.User, time.Now())
+		if errors.Is(err, state.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		app.lo.Info("acknowledged alert", "fingerprint", fp, "room", room, "user", ev.User)
+		app.metrics.Increment(fmt.Sprintf(`alerts_acked_total{room="%s"}`, room))
+		return fmt.Sprintf("Acknowledged by %s", ev.User), nil
+	}
+
+	if fingerprint == "" {
+		return "", fmt.Errorf("no active alert in this thread, use `/ack <fingerprint>`")
+	}
+	return "", fmt.Errorf("no active alert with fingerprint %s", fingerprint)
+}
+
+// Handle listing the firing alerts which haven't been acknowledged,
+// optionally filtered by the `room` query param.
+func handleUnackedAlerts(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		out = make(map[string][]state.Alert)
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="unacked_alerts"}`)
+
+	rooms, ok := roomsFromQuery(app, r)
+	if !ok {
+		app.metrics.Increment(`http_request_errors_total{handler="unacked_alerts"}`)
+		sendErrorResponse(w, "Unknown room.", http.StatusNotFound, nil)
+		return
+	}
+
+	for _, room := range rooms {
+		if aa, ok := app.notifier.ActiveAlerts(room); ok {
+			out[room] = aa.Unacked()
+		}
+	}
+
+	sendResponse(w, out)
+}
+
+// Handle listing the active alerts of rooms, along with their thread keys and expiry,
+// optionally filtered by the `room` query param.
+func handleActiveAlerts(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		out = make(map[string][]state.Alert)
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="active_alerts"}`)
+
+	rooms, ok := roomsFromQuery(app, r)
+	if !ok {
+		app.metrics.Increment(`http_request_errors_total{handler="active_alerts"}`)
+		sendErrorResponse(w, "Unknown room.", http.StatusNotFound, nil)
+		return
+	}
+
+	for _, room := range rooms {
+		if aa, ok := app.notifier.ActiveAlerts(room); ok {
+			out[room] = aa.All()
+		}
+	}
+
+	sendResponse(w, out)
+}
+
+// Handle looking up an active alert by its fingerprint, in all the rooms
+// it was sent to. The rooms can be filtered by the `room` query param.
+func handleActiveAlert(w http.ResponseWriter, r *http.Request) {
+	var (
+		app         = r.Context().Value("app").(*App)
+		fingerprint = chi.URLParam(r, "fingerprint")
+		out         = make(map[string]state.Alert)
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="active_alert"}`)
+
+	rooms, ok := roomsFromQuery(app, r)
+	if !ok {
+		app.metrics.Increment(`http_request_errors_total{handler="active_alert"}`)
+		sendErrorResponse(w, "Unknown room.", http.StatusNotFound, nil)
+		return
+	}
+
+	for _, room := range rooms {
+		if aa, ok := app.notifier.ActiveAlerts(room); ok {
+			if a, ok := aa.Alert(fingerprint); ok {
+				out[room] = a
+			}
+		}
+	}
+	if len(out) == 0 {
+		app.metrics.Increment(`http_request_errors_total{handler="active_alert"}`)
+		sendErrorResponse(w, "Alert not found.", http.StatusNotFound, nil)
+		return
+	}
+
+	sendResponse(w, out)
+}
+
+// Handle deleting an active alert, so that its next notification starts a new thread.
+// It's deleted from all the rooms it was sent to, unless the `room` query param is set.
+func handleDeleteActiveAlert(w http.ResponseWriter, r *http.Request) {
+	var (
+		app         = r.Context().Value("app").(*App)
+		fingerprint = chi.URLParam(r, "fingerprint")
+		deleted     = make([]string, 0)
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="delete_active_alert"}`)
+
+	rooms, ok := roomsFromQuery(app, r)
+	if !ok {
+		app.metrics.Increment(`http_request_errors_total{handler="delete_active_alert"}`)
+		sendErrorResponse(w, "Unknown room.", http.StatusNotFound, nil)
+		return
+	}
+
+	for _, room := range rooms {
+		if aa, ok := app.notifier.ActiveAlerts(room); ok {
+			if err := aa.Delete(fingerprint); err == nil {
+				deleted = append(deleted, room)
+			}
+		}
+	}
+	if len(deleted) == 0 {
+		app.metrics.Increment(`http_request_errors_total{handler="delete_active_alert"}`)
+		sendErrorResponse(w, "Alert not found.", http.StatusNotFound, nil)
+		return
+	}
+
+	app.lo.Info("deleted active alert", "fingerprint", fingerprint, "rooms", deleted)
+	sendResponse(w, map[string]interface{}{"rooms": deleted})
+}
+
+// Handle removing all the active alerts of a room.
+func handleFlushActiveAlerts(w http.ResponseWriter, r *http.Request) {
+	var (
+		app  = r.Context().Value("app").(*App)
+		room = chi.URLParam(r, "room")
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="flush_active_alerts"}`)
+
+	aa, ok := app.notifier.ActiveAlerts(room)
+	if !ok {
+		app.metrics.Increment(`http_request_errors_total{handler="flush_active_alerts"}`)
+		sendErrorResponse(w, "Unknown room.", http.StatusNotFound, nil)
+		return
+	}
+
+	n := aa.Flush()
+	app.lo.Info("flushed active alerts", "room", room, "count", n)
+	sendResponse(w, map[string]interface{}{"deleted": n})
+}
+
+// Handle exporting the active alerts of all rooms, to be imported in another instance.
+func handleExportState(w http.ResponseWriter, r *http.Request) {
+	var (
+		app  = r.Context().Value("app").(*App)
+		snap = state.Snapshot{
+			Version:    state.SnapshotVersion,
+			ExportedAt: time.Now(),
+			Rooms:      make(map[string][]state.Alert),
+		}
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="export_state"}`)
+
+	for _, room := range app.notifier.Rooms() {
+		if aa, ok := app.notifier.ActiveAlerts(room); ok {
+			snap.Rooms[room] = aa.All()
+		}
+	}
+
+	sendResponse(w, snap)
+}
+
+// maxSnapshotSize is the maximum size of snapshots accepted by handleImportState.
+const maxSnapshotSize = 64 << 20
+
+// Handle importing the active alerts exported by handleExportState. With the `mode=replace`
+// query param, the active alerts of all rooms are replaced. Otherwise, they're merged
+// and existing alerts are kept. Alerts past their TTL and rooms which aren't configured are skipped.
+func handleImportState(w http.ResponseWriter, r *http.Request) {
+	var (
+		app     = r.Context().Value("app").(*App)
+		snap    state.Snapshot
+		replace bool
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="import_state"}`)
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "merge":
+	case "replace":
+		replace = true
+	default:
+		app.metrics.Increment(`http_request_errors_total{handler="import_state"}`)
+		sendErrorResponse(w, "Invalid mode, expected merge or replace.", http.StatusBadRequest, nil)
+		return
+	}
+
+	// The snapshot may be wrapped in the response envelope of the export API.
+	var envelope struct {
+		Data *state.Snapshot `json:"data"`
+	}
+	envelope.Data = &snap
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err == nil {
+		if err = json.Unmarshal(body, &envelope); err == nil && snap.Version == 0 {
+			err = json.Unmarshal(body, &snap)
+		}
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		app.metrics.Increment(`http_request_errors_total{handler="import_state"}`)
+		sendErrorResponse(w, fmt.Sprintf("Payload is larger than %d bytes.", maxSnapshotSize), http.StatusRequestEntityTooLarge, nil)
+		return
+	}
+	if err != nil {
+		app.metrics.Increment(`http_request_errors_total{handler="import_state"}`)
+		sendErrorResponse(w, "Error decoding payload.", http.StatusBadRequest, nil)
+		return
+	}
+	if err := snap.Validate(); err != nil {
+		app.metrics.Increment(`http_request_errors_total{handler="import_state"}`)
+		sendErrorResponse(w, fmt.Sprintf("Invalid snapshot: %s.", err), http.StatusBadRequest, nil)
+		return
+	}
+
+	out := struct {
+		Imported map[string]int `json:"imported"`
+		Dropped  int            `json:"dropped"`
+		Skipped  []string       `json:"skipped_rooms"`
+	}{Imported: make(map[string]int), Skipped: make([]string, 0)}
+
+	for room := range snap.Rooms {
+		if _, ok := app.notifier.ActiveAlerts(room); !ok {
+			app.lo.Warn("skipping active alerts of unknown room", "room", room)
+			out.Skipped = append(out.Skipped, room)
+		}
+	}
+	sort.Strings(out.Skipped)
+
+	for _, room := range app.notifier.Rooms() {
+		aa, ok := app.notifier.ActiveAlerts(room)
+		alerts, exported := snap.Rooms[room]
+		if !ok || (!exported && !replace) {
+			continue
+		}
+
+		imported, dropped, err := aa.Import(alerts, replace)
+		if err != nil {
+			app.lo.Error("error importing active alerts", "room", room, "error", err)
+			app.metrics.Increment(`http_request_errors_total{handler="import_state"}`)
+			sendErrorResponse(w, "Error importing active alerts.", http.StatusInternalServerError, nil)
+			return
+		}
+		out.Imported[room] = imported
+		out.Dropped += dropped
+	}
+
+	app.lo.Info("imported active alerts", "imported", out.Imported, "dropped", out.Dropped, "replace", replace)
+	sendResponse(w, out)
+}
+
+// roomsFromQuery returns the room in the `room` query param, or all the rooms
+// which track active alerts if it's empty. It returns false if the room is unknown.
+func roomsFromQuery(app *App, r *http.Request) ([]string, bool) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		return app.notifier.Rooms(), true
+	}
+	if _, ok := app.notifier.ActiveAlerts(room); !ok {
+		return nil, false
+	}
+	return []string{room}, true
+}
+
+// Handle querying the history of dispatched notifications. It's filtered by the
+// `room`, `status`, `from` and `to` (RFC3339) query params, and `filter` params
+// with label matchers, eg `filter=severity="critical"`.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		qp  = r.URL.Query()
+		q   = history.Query{
+			Room:   qp.Get("room"),
+			Status: qp.Get("status"),
+		}
+	)
+
+	app.metrics.Increment(`http_requests_total{handler="history"}`)
+
+	badRequest := func(msg string) {
+		app.metrics.Increment(`http_request_errors_total{handler="history"}`)
+		sendErrorResponse(w, msg, http.StatusBadRequest, nil)
+	}
+
+	for _, f := range qp["filter"] {
+		m, err := labels.ParseMatchers(f)
+		if err != nil {
+			badRequest(fmt.Sprintf("Invalid filter: %s", err))
+			return
+		}
+		q.Matchers = append(q.Matchers, m...)
+	}
+	for param, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		v := qp.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			badRequest(fmt.Sprintf("Invalid %s time, expected RFC3339.", param))
+			return
+		}
+		*t = parsed
+	}
+	if v := qp.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			badRequest("Invalid limit.")
+			return
+		}
+		q.Limit = limit
+	}
+
+	out, err := app.history.Query(q)
+	if err != nil {
+		app.lo.Error("error querying history", "error", err)
+		app.metrics.Increment(`http_request_errors_total{handler="history"}`)
+		sendErrorResponse(w, "Error querying history.", http.StatusInternalServerError, nil)
+		return
+	}
+
+	sendResponse(w, out)
+}
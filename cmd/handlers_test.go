@@ -7,13 +7,19 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/mr-karan/calert/internal/alertmanager"
+	"github.com/mr-karan/calert/internal/history"
 	"github.com/mr-karan/calert/internal/metrics"
 	"github.com/mr-karan/calert/internal/notifier"
 	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,6 +38,14 @@ func (m *mockProvider) Push(alerts []alertmgrtmpl.Alert) error {
 	return m.pushErr
 }
 
+// statefulProvider is a mockProvider which tracks active alerts.
+type statefulProvider struct {
+	mockProvider
+	active *state.ActiveAlerts
+}
+
+func (m *statefulProvider) ActiveAlerts() *state.ActiveAlerts { return m.active }
+
 func newTestApp(t *testing.T, provs ...providers.Provider) *App {
 	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	m := metrics.New("calert")
@@ -39,6 +53,7 @@ func newTestApp(t *testing.T, provs ...providers.Provider) *App {
 	n, err := notifier.Init(notifier.Opts{
 		Providers: provs,
 		Log:       lo,
+		Metrics:   m,
 	})
 	require.NoError(t, err)
 
@@ -204,3 +219,381 @@ func TestSendErrorResponse(t *testing.T) {
 	assert.Equal(t, "error", response.Status)
 	assert.Equal(t, "something went wrong", response.Message)
 }
+
+func TestHandleGoogleChatInteraction(t *testing.T) {
+	var silence alertmanager.Silence
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&silence))
+		w.Write([]byte(`{"silenceID":"silence-1"}`))
+	}))
+	defer am.Close()
+
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	prov := &statefulProvider{
+		mockProvider: mockProvider{room: "prod_alerts"},
+		active:       state.New(state.Opts{Log: lo, Metrics: metrics.New("calert")}),
+	}
+	require.NoError(t, prov.active.Add(alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLoad", "instance": "db-1"},
+	}))
+
+	app := newTestApp(t, prov)
+	app.interactionToken = "secret"
+	client, err := alertmanager.New(alertmanager.Opts{URL: am.URL, Timeout: time.Second})
+	require.NoError(t, err)
+	app.alertmanager = client
+
+	event := `{
+		"type": "CARD_CLICKED",
+		"user": {"name": "users/123", "displayName": "Jane Doe"},
+		"message": {"thread": {"name": "spaces/abc/threads/xyz"}},
+		"common": {
+			"invokedFunction": "silence",
+			"parameters": {"duration": "4h", "fingerprint": "abc", "labels": "{\"alertname\":\".*\"}"}
+		}
+	}`
+
+	send := func(url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req = withAppContext(app, req)
+		w := httptest.NewRecorder()
+		handleGoogleChatInteraction(w, req)
+		return w
+	}
+
+	t.Run("rejects invalid token", func(t *testing.T) {
+		w := send("/interactions/google_chat?token=wrong", event)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("creates silence and replies in thread", func(t *testing.T) {
+		w := send("/interactions/google_chat?token=secret", event)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var reply struct {
+			Text           string `json:"text"`
+			ActionResponse struct {
+				Type string `json:"type"`
+			} `json:"actionResponse"`
+			Thread struct {
+				Name string `json:"name"`
+			} `json:"thread"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		assert.Equal(t, "Silenced by Jane Doe for 4h (silence ID: silence-1)", reply.Text)
+		assert.Equal(t, "NEW_MESSAGE", reply.ActionResponse.Type)
+		assert.Equal(t, "spaces/abc/threads/xyz", reply.Thread.Name)
+
+		assert.Equal(t, "Jane Doe", silence.CreatedBy)
+		assert.Equal(t, 4*time.Hour, silence.EndsAt.Sub(silence.StartsAt))
+		// The matchers are the labels of the active alert, not the labels in the event.
+		require.Len(t, silence.Matchers, 2)
+		assert.Equal(t, "alertname", silence.Matchers[0].Name)
+		assert.Equal(t, "HighLoad", silence.Matchers[0].Value)
+	})
+
+	t.Run("replies with error for unknown alert", func(t *testing.T) {
+		silence = alertmanager.Silence{}
+		w := send("/interactions/google_chat?token=secret", strings.Replace(event, `"abc"`, `"missing"`, 1))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "no active alert with fingerprint missing")
+		assert.Empty(t, silence.Matchers)
+	})
+
+	t.Run("rejects all requests without token", func(t *testing.T) {
+		app.interactionToken = ""
+		defer func() { app.interactionToken = "secret" }()
+		w := send("/interactions/google_chat?token=", event)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("replies with error for invalid duration", func(t *testing.T) {
+		w := send("/interactions/google_chat?token=secret", strings.Replace(event, `"4h"`, `"forever"`, 1))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid silence duration")
+	})
+
+	t.Run("rejects invalid payload", func(t *testing.T) {
+		w := send("/interactions/google_chat?token=secret", "invalid json")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestAckInteraction(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	prov := &statefulProvider{
+		mockProvider: mockProvider{room: "prod_alerts"},
+		active:       state.New(state.Opts{Log: lo, Metrics: metrics.New("calert")}),
+	}
+	app := newTestApp(t, &mockProvider{room: "dev_alerts"}, prov)
+	app.interactionToken = "secret"
+
+	now := time.Now()
+	require.NoError(t, prov.active.Add(alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: now}))
+	require.NoError(t, prov.active.Add(alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", StartsAt: now}))
+	require.NoError(t, prov.active.Add(alertmgrtmpl.Alert{Fingerprint: "ghi", Status: "firing", StartsAt: now.Add(-time.Hour)}))
+
+	send := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/interactions/google_chat?token=secret", strings.NewReader(body))
+		req = withAppContext(app, req)
+		w := httptest.NewRecorder()
+		handleGoogleChatInteraction(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var reply struct {
+			Text string `json:"text"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		return reply.Text
+	}
+
+	t.Run("acks from button", func(t *testing.T) {
+		text := send(`{
+			"type": "CARD_CLICKED",
+			"user": {"displayName": "Jane Doe"},
+			"common": {"invokedFunction": "ack", "parameters": {"fingerprint": "abc", "room": "prod_alerts"}}
+		}`)
+		assert.Equal(t, "Acknowledged by Jane Doe", text)
+
+		a, _ := prov.active.Get("abc")
+		assert.Equal(t, "Jane Doe", a.AckedBy)
+	})
+
+	t.Run("acks alert of the thread from slash command", func(t *testing.T) {
+		text := send(`{
+			"type": "MESSAGE",
+			"user": {"displayName": "John Doe"},
+			"message": {
+				"thread": {"threadKey": "` + prov.active.Lookup("def") + `"},
+				"annotations": [{"slashCommand": {"commandName": "/ack"}}]
+			}
+		}`)
+		assert.Equal(t, "Acknowledged by John Doe", text)
+
+		a, _ := prov.active.Get("def")
+		assert.Equal(t, "John Doe", a.AckedBy)
+	})
+
+	t.Run("replies with error for unknown alert", func(t *testing.T) {
+		text := send(`{
+			"type": "MESSAGE",
+			"user": {"displayName": "John Doe"},
+			"message": {"argumentText": "missing", "annotations": [{"slashCommand": {"commandName": "/ack"}}]}
+		}`)
+		assert.Equal(t, "Error: no active alert with fingerprint missing", text)
+	})
+
+	t.Run("lists unacked alerts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/alerts/unacked", nil)
+		req = withAppContext(app, req)
+		w := httptest.NewRecorder()
+		handleUnackedAlerts(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Data map[string][]state.Alert `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Data, 1)
+		require.Len(t, response.Data["prod_alerts"], 1)
+		assert.Equal(t, "ghi", response.Data["prod_alerts"][0].Fingerprint)
+
+		req = httptest.NewRequest(http.MethodGet, "/api/alerts/unacked?room=dev_alerts", nil)
+		req = withAppContext(app, req)
+		w = httptest.NewRecorder()
+		handleUnackedAlerts(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandleHistory(t *testing.T) {
+	app := newTestApp(t)
+
+	hist, err := history.New(history.Opts{
+		Log:       app.lo,
+		Metrics:   app.metrics,
+		Dir:       t.TempDir(),
+		Retention: time.Hour,
+	})
+	require.NoError(t, err)
+	app.history = hist
+
+	now := time.Now()
+	hist.Add(history.Entry{Time: now.Add(-time.Minute), Room: "prod_alerts", Fingerprint: "abc", Status: "firing", Labels: map[string]string{"severity": "critical"}, Result: history.ResultSent})
+	hist.Add(history.Entry{Time: now, Room: "prod_alerts", Fingerprint: "def", Status: "firing", Labels: map[string]string{"severity": "warning"}, Result: history.ResultSent})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = withAppContext(app, req)
+		w := httptest.NewRecorder()
+		handleHistory(w, req)
+		return w
+	}
+
+	w := get(`/history?room=prod_alerts&filter=severity%3D"critical"&to=` + url.QueryEscape(now.Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Data []history.Entry `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 1)
+	assert.Equal(t, "abc", response.Data[0].Fingerprint)
+
+	for _, target := range []string{"/history?filter=severity%3D~(", "/history?from=yesterday", "/history?limit=-1"} {
+		assert.Equal(t, http.StatusBadRequest, get(target).Code, target)
+	}
+}
+
+func TestActiveAlertsAPI(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	newProvider := func(room string) *statefulProvider {
+		return &statefulProvider{
+			mockProvider: mockProvider{room: room},
+			active:       state.New(state.Opts{Log: lo, Metrics: metrics.New("calert"), TTL: time.Hour}),
+		}
+	}
+	var (
+		prod = newProvider("prod_alerts")
+		dev  = newProvider("dev_alerts")
+		app  = newTestApp(t, prod, dev)
+		now  = time.Now()
+	)
+	require.NoError(t, prod.active.Add(alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: now}))
+	require.NoError(t, prod.active.Add(alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", StartsAt: now}))
+	require.NoError(t, dev.active.Add(alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: now}))
+
+	r := chi.NewRouter()
+	r.Get("/api/alerts", wrap(app, handleActiveAlerts))
+	r.Get("/api/alerts/{fingerprint}", wrap(app, handleActiveAlert))
+	r.Delete("/api/alerts/{fingerprint}", wrap(app, handleDeleteActiveAlert))
+	r.Delete("/api/rooms/{room}/alerts", wrap(app, handleFlushActiveAlerts))
+
+	do := func(method, target string, out interface{}) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		if out != nil {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp{Data: out}))
+		}
+		return w.Code
+	}
+
+	t.Run("lists active alerts", func(t *testing.T) {
+		var out map[string][]state.Alert
+		require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/alerts", &out))
+		require.Len(t, out["prod_alerts"], 2)
+		require.Len(t, out["dev_alerts"], 1)
+		assert.Equal(t, prod.active.Lookup("abc"), out["prod_alerts"][0].ThreadKey)
+		assert.WithinDuration(t, now.Add(time.Hour), out["prod_alerts"][0].ExpiresAt, time.Second)
+
+		out = nil
+		require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/alerts?room=dev_alerts", &out))
+		assert.Len(t, out, 1)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/alerts?room=unknown", nil))
+	})
+
+	t.Run("looks up an alert", func(t *testing.T) {
+		var out map[string]state.Alert
+		require.Equal(t, http.StatusOK, do(http.MethodGet, "/api/alerts/abc", &out))
+		assert.Len(t, out, 2)
+		assert.Equal(t, dev.active.Lookup("abc"), out["dev_alerts"].ThreadKey)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/alerts/missing", nil))
+	})
+
+	t.Run("deletes an alert", func(t *testing.T) {
+		var out struct {
+			Rooms []string `json:"rooms"`
+		}
+		require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/alerts/abc?room=prod_alerts", &out))
+		assert.Equal(t, []string{"prod_alerts"}, out.Rooms)
+		assert.Empty(t, prod.active.Lookup("abc"))
+		assert.NotEmpty(t, dev.active.Lookup("abc"))
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/alerts/abc?room=prod_alerts", nil))
+	})
+
+	t.Run("flushes a room", func(t *testing.T) {
+		var out struct {
+			Deleted int `json:"deleted"`
+		}
+		require.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/rooms/prod_alerts/alerts", &out))
+		assert.Equal(t, 1, out.Deleted)
+		assert.Empty(t, prod.active.All())
+		assert.Len(t, dev.active.All(), 1)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/rooms/unknown/alerts", nil))
+	})
+}
+
+func TestStateAPI(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	newProvider := func(room string) *statefulProvider {
+		return &statefulProvider{
+			mockProvider: mockProvider{room: room},
+			active:       state.New(state.Opts{Log: lo, Metrics: metrics.New("calert"), TTL: time.Hour}),
+		}
+	}
+	var (
+		src = newProvider("prod_alerts")
+		now = time.Now()
+	)
+	require.NoError(t, src.active.Add(alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: now}))
+	require.NoError(t, src.active.Add(alertmgrtmpl.Alert{Fingerprint: "expired", Status: "firing", StartsAt: now.Add(-2 * time.Hour)}))
+
+	// Export from one instance.
+	req := withAppContext(newTestApp(t, src, newProvider("old_alerts")), httptest.NewRequest(http.MethodGet, "/api/state", nil))
+	w := httptest.NewRecorder()
+	handleExportState(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Age an alert past its TTL.
+	var snap state.Snapshot
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp{Data: &snap}))
+	require.Len(t, snap.Rooms["prod_alerts"], 2)
+	for i, a := range snap.Rooms["prod_alerts"] {
+		if a.Fingerprint == "expired" {
+			snap.Rooms["prod_alerts"][i].LastSeen = now.Add(-2 * time.Hour)
+		}
+	}
+	exported, err := json.Marshal(resp{Status: "success", Data: snap})
+	require.NoError(t, err)
+
+	// And import in another one.
+	var (
+		dst = newProvider("prod_alerts")
+		app = newTestApp(t, dst, &mockProvider{room: "dev_alerts"})
+	)
+	require.NoError(t, dst.active.Add(alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", StartsAt: now}))
+
+	importState := func(query string, body []byte) *httptest.ResponseRecorder {
+		req := withAppContext(app, httptest.NewRequest(http.MethodPost, "/api/state"+query, bytes.NewReader(body)))
+		w := httptest.NewRecorder()
+		handleImportState(w, req)
+		return w
+	}
+
+	w = importState("", exported)
+	require.Equal(t, http.StatusOK, w.Code)
+	var out struct {
+		Imported map[string]int `json:"imported"`
+		Dropped  int            `json:"dropped"`
+		Skipped  []string       `json:"skipped_rooms"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp{Data: &out}))
+	assert.Equal(t, map[string]int{"prod_alerts": 1}, out.Imported)
+	assert.Equal(t, 1, out.Dropped)
+	assert.Equal(t, []string{"old_alerts"}, out.Skipped)
+	assert.Equal(t, src.active.Lookup("abc"), dst.active.Lookup("abc"))
+	assert.NotEmpty(t, dst.active.Lookup("def"))
+
+	// A bare snapshot can be imported too, replacing the existing alerts.
+	bare, err := json.Marshal(state.Snapshot{Version: state.SnapshotVersion, Rooms: map[string][]state.Alert{}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, importState("?mode=replace", bare).Code)
+	assert.Empty(t, dst.active.All())
+
+	assert.Equal(t, http.StatusBadRequest, importState("?mode=overwrite", bare).Code)
+	assert.Equal(t, http.StatusBadRequest, importState("", []byte(`{"version": 2, "rooms": {}}`)).Code)
+	assert.Equal(t, http.StatusBadRequest, importState("", []byte(`{`)).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, importState("", bytes.Repeat([]byte(" "), maxSnapshotSize+1)).Code)
+}
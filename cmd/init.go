@@ -4,17 +4,40 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
+	"github.com/mr-karan/calert/internal/alertmanager"
+	"github.com/mr-karan/calert/internal/digest"
+	"github.com/mr-karan/calert/internal/escalation"
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/mentions"
 	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/mute"
 	"github.com/mr-karan/calert/internal/notifier"
+	"github.com/mr-karan/calert/internal/oncall"
 	prvs "github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/providers/broker"
+	"github.com/mr-karan/calert/internal/providers/discord"
+	"github.com/mr-karan/calert/internal/providers/email"
+	"github.com/mr-karan/calert/internal/providers/exec"
+	filesink "github.com/mr-karan/calert/internal/providers/file"
 	"github.com/mr-karan/calert/internal/providers/google_chat"
+	"github.com/mr-karan/calert/internal/providers/gotify"
+	"github.com/mr-karan/calert/internal/providers/matrix"
+	"github.com/mr-karan/calert/internal/providers/mattermost"
+	"github.com/mr-karan/calert/internal/providers/ntfy"
+	"github.com/mr-karan/calert/internal/providers/opsgenie"
+	"github.com/mr-karan/calert/internal/providers/pagerduty"
+	"github.com/mr-karan/calert/internal/providers/pushover"
+	"github.com/mr-karan/calert/internal/providers/telegram"
 	flag "github.com/spf13/pflag"
 )
 
@@ -83,33 +106,117 @@ func initConfig(cfgDefault string, envPrefix string) (*koanf.Koanf, error) {
 }
 
 // initProviders loads all the providers specified in the config.
-func initProviders(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) ([]prvs.Provider, error) {
+func initProviders(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager, mentions *mentions.Mapper, resolver *oncall.Resolver, hist *history.Store) ([]prvs.Provider, error) {
 	provs := make([]prvs.Provider, 0)
+	shared := prvs.Shared{Mentions: mentions, OnCall: resolver, History: hist}
 	provDefOpts := map[string]interface{}{
-		"type":             "google_chat",
-		"max_idle_conns":   50,
-		"timeout":          "30s",
-		"template":         "static/message.tmpl",
-		"thread_ttl":       "12h",
-		"threaded_replies": false,
-		"dry_run":          false,
-		"retry_max":        3,
-		"retry_wait_min":   "1s",
-		"retry_wait_max":   "5s",
+		"type":              "google_chat",
+		"max_idle_conns":    50,
+		"timeout":           "30s",
+		"template":          "static/message.tmpl",
+		"thread_ttl":        "12h",
+		"prune_interval":    "1h",
+		"max_active_alerts": 10000,
+		"threaded_replies":  false,
+		"dry_run":           false,
+		"retry_max":         3,
+		"retry_wait_min":    "1s",
+		"retry_wait_max":    "5s",
+
+		"circuit_breaker_threshold":        0,
+		"circuit_breaker_open_interval":    "1m",
+		"circuit_breaker_half_open_probes": 1,
+	}
+	// Default values which differ by the type of provider, over provDefOpts.
+	provTypeDefOpts := map[string]map[string]interface{}{
+		"discord": {
+			"template": "static/discord.tmpl",
+		},
+		"mattermost": {
+			"template": "static/mattermost.tmpl",
+		},
+		"email": {
+			"template": "static/email.tmpl",
+			"port":     587,
+			"tls":      email.TLSModeStartTLS,
+		},
+		"matrix": {
+			"template": "static/matrix.tmpl",
+		},
+		"pagerduty": {
+			"endpoint": "https://events.pagerduty.com/v2/enqueue",
+			"template": "static/pagerduty.tmpl",
+		},
+		"opsgenie": {
+			"endpoint": "https://api.opsgenie.com",
+			"template": "static/opsgenie.tmpl",
+		},
+		"exec": {
+			"template":    "static/exec.tmpl",
+			"mode":        exec.ModeAlert,
+			"concurrency": 1,
+		},
+		"file": {
+			"template": "static/file.tmpl",
+			"format":   filesink.FormatAlert,
+		},
+		"telegram": {
+			"endpoint":   "https://api.telegram.org",
+			"template":   "static/telegram.tmpl",
+			"parse_mode": telegram.ParseModeHTML,
+		},
+		"kafka": {
+			"template":       "static/broker.tmpl",
+			"format":         broker.FormatAlert,
+			"sasl_mechanism": broker.SASLPlain,
+		},
+		"nats": {
+			"template": "static/broker.tmpl",
+			"format":   broker.FormatAlert,
+		},
+		"redis": {
+			"template": "static/broker.tmpl",
+			"format":   broker.FormatAlert,
+		},
+		"ntfy": {
+			"endpoint":          "https://ntfy.sh",
+			"template":          "static/ntfy.tmpl",
+			"default_priority":  ntfy.PriorityDefault,
+			"resolved_priority": ntfy.PriorityLow,
+		},
+		"gotify": {
+			"template":          "static/gotify.tmpl",
+			"default_priority":  5,
+			"resolved_priority": 2,
+		},
+		"pushover": {
+			"endpoint":          "https://api.pushover.net",
+			"template":          "static/pushover.tmpl",
+			"default_priority":  pushover.PriorityNormal,
+			"resolved_priority": pushover.PriorityLow,
+			"emergency_retry":   "1m",
+			"emergency_expire":  "1h",
+		},
 	}
 
 	// Loop over all providers listed in config.
 	for _, name := range ko.MapKeys("providers") {
 		cfgKey := fmt.Sprintf("providers.%s", name)
 
+		if !ko.Exists(fmt.Sprintf("%s.type", cfgKey)) {
+			ko.Set(fmt.Sprintf("%s.type", cfgKey), provDefOpts["type"])
+		}
+		provType := ko.String(fmt.Sprintf("%s.type", cfgKey))
+
 		// Set default values for the provider if not set in config.
-		for valKey, defaultVal := range provDefOpts {
-			if !ko.Exists(fmt.Sprintf("%s.%s", cfgKey, valKey)) {
-				ko.Set(fmt.Sprintf("%s.%s", cfgKey, valKey), defaultVal)
+		for _, defOpts := range []map[string]interface{}{provTypeDefOpts[provType], provDefOpts} {
+			for valKey, defaultVal := range defOpts {
+				if !ko.Exists(fmt.Sprintf("%s.%s", cfgKey, valKey)) {
+					ko.Set(fmt.Sprintf("%s.%s", cfgKey, valKey), defaultVal)
+				}
 			}
 		}
 
-		provType := ko.String(fmt.Sprintf("%s.type", cfgKey))
 		switch provType {
 		case "google_chat":
 			opts := google_chat.GoogleChatOpts{
@@ -121,12 +228,18 @@ func initProviders(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (
 				Room:            name,
 				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
 				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				DedupWindow:     ko.Duration(fmt.Sprintf("%s.dedup_window", cfgKey)),
+				DedupReminder:   ko.Duration(fmt.Sprintf("%s.dedup_reminder_interval", cfgKey)),
 				ThreadedReplies: ko.Bool(fmt.Sprintf("%s.threaded_replies", cfgKey)),
 				Metrics:         metrics,
 				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
 				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
 				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
 				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
 			}
 			lo.Debug("provider options", "type", provType, "options", opts)
 
@@ -137,6 +250,462 @@ func initProviders(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (
 
 			lo.Info("initialised provider", "room", gchat.Room())
 			provs = append(provs, gchat)
+
+		case "discord":
+			opts := discord.DiscordOpts{
+				Log:          lo,
+				Metrics:      metrics,
+				DryRun:       ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:  ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:      ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:     ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:     ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:         name,
+				Template:     ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				RetryMax:     ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin: ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax: ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Username:     ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				AvatarURL:    ko.String(fmt.Sprintf("%s.avatar_url", cfgKey)),
+				Shared:       shared,
+				BreakerOpts:  initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "options", opts)
+
+			dc, err := discord.NewDiscord(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising discord provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", dc.Room(), "type", provType)
+			provs = append(provs, dc)
+
+		case "mattermost":
+			opts := mattermost.MattermostOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:     ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:        ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:        ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Channel:         ko.String(fmt.Sprintf("%s.channel", cfgKey)),
+				Username:        ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				IconURL:         ko.String(fmt.Sprintf("%s.icon_url", cfgKey)),
+				APIToken:        ko.String(fmt.Sprintf("%s.api_token", cfgKey)),
+				ChannelID:       ko.String(fmt.Sprintf("%s.channel_id", cfgKey)),
+				ServerURL:       ko.String(fmt.Sprintf("%s.server_url", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			mm, err := mattermost.NewMattermost(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising mattermost provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", mm.Room(), "type", provType)
+			provs = append(provs, mm)
+
+		case "telegram":
+			opts := telegram.TelegramOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:     ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:        ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:        ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				BotToken:        ko.String(fmt.Sprintf("%s.bot_token", cfgKey)),
+				ChatID:          ko.String(fmt.Sprintf("%s.chat_id", cfgKey)),
+				ParseMode:       ko.String(fmt.Sprintf("%s.parse_mode", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			tg, err := telegram.NewTelegram(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising telegram provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", tg.Room(), "type", provType)
+			provs = append(provs, tg)
+
+		case "matrix":
+			opts := matrix.MatrixOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:     ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:        ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:        ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				RoomID:          ko.String(fmt.Sprintf("%s.room_id", cfgKey)),
+				AccessToken:     ko.String(fmt.Sprintf("%s.access_token", cfgKey)),
+				MsgType:         ko.String(fmt.Sprintf("%s.msgtype", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			mx, err := matrix.NewMatrix(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising matrix provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", mx.Room(), "type", provType)
+			provs = append(provs, mx)
+
+		case "email":
+			opts := email.EmailOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Host:            ko.String(fmt.Sprintf("%s.host", cfgKey)),
+				Port:            ko.Int(fmt.Sprintf("%s.port", cfgKey)),
+				Username:        ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				Password:        ko.String(fmt.Sprintf("%s.password", cfgKey)),
+				TLSMode:         ko.String(fmt.Sprintf("%s.tls", cfgKey)),
+				TLSSkipVerify:   ko.Bool(fmt.Sprintf("%s.tls_skip_verify", cfgKey)),
+				From:            ko.String(fmt.Sprintf("%s.from", cfgKey)),
+				To:              ko.Strings(fmt.Sprintf("%s.to", cfgKey)),
+				CC:              ko.Strings(fmt.Sprintf("%s.cc", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			em, err := email.NewEmail(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising email provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", em.Room(), "type", provType)
+			provs = append(provs, em)
+
+		case "pagerduty":
+			opts := pagerduty.PagerDutyOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:     ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:        ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:        ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				RoutingKey:      ko.String(fmt.Sprintf("%s.routing_key", cfgKey)),
+				SeverityLabel:   ko.String(fmt.Sprintf("%s.severity_label", cfgKey)),
+				Severities:      ko.StringMap(fmt.Sprintf("%s.severities", cfgKey)),
+				DefaultSeverity: ko.String(fmt.Sprintf("%s.default_severity", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			pd, err := pagerduty.NewPagerDuty(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising pagerduty provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", pd.Room(), "type", provType)
+			provs = append(provs, pd)
+
+		case "opsgenie":
+			opts := opsgenie.OpsgenieOpts{
+				Log:             lo,
+				Metrics:         metrics,
+				DryRun:          ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:     ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:         ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:        ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:        ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:            name,
+				Template:        ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:       ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:   ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts: ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:        ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:    ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:    ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				APIKey:          ko.String(fmt.Sprintf("%s.api_key", cfgKey)),
+				SeverityLabel:   ko.String(fmt.Sprintf("%s.severity_label", cfgKey)),
+				Priorities:      ko.StringMap(fmt.Sprintf("%s.priorities", cfgKey)),
+				DefaultPriority: ko.String(fmt.Sprintf("%s.default_priority", cfgKey)),
+				Shared:          shared,
+				BreakerOpts:     initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			og, err := opsgenie.NewOpsgenie(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising opsgenie provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", og.Room(), "type", provType)
+			provs = append(provs, og)
+
+		case "exec":
+			opts := exec.ExecOpts{
+				Log:         lo,
+				Metrics:     metrics,
+				DryRun:      ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				Room:        name,
+				Template:    ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				Command:     ko.String(fmt.Sprintf("%s.command", cfgKey)),
+				Args:        ko.Strings(fmt.Sprintf("%s.args", cfgKey)),
+				Mode:        ko.String(fmt.Sprintf("%s.mode", cfgKey)),
+				Timeout:     ko.Duration(fmt.Sprintf("%s.timeout", cfgKey)),
+				Concurrency: ko.Int(fmt.Sprintf("%s.concurrency", cfgKey)),
+				Shared:      shared,
+				BreakerOpts: initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			ex, err := exec.NewExec(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising exec provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", ex.Room(), "type", provType)
+			provs = append(provs, ex)
+
+		case "file":
+			opts := filesink.FileOpts{
+				Log:            lo,
+				Metrics:        metrics,
+				DryRun:         ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				Room:           name,
+				Template:       ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				Path:           ko.String(fmt.Sprintf("%s.path", cfgKey)),
+				Format:         ko.String(fmt.Sprintf("%s.format", cfgKey)),
+				MaxSize:        ko.Int64(fmt.Sprintf("%s.max_size_mb", cfgKey)) * 1024 * 1024,
+				RotateInterval: ko.Duration(fmt.Sprintf("%s.rotate_interval", cfgKey)),
+				MaxBackups:     ko.Int(fmt.Sprintf("%s.max_backups", cfgKey)),
+				Shared:         shared,
+				BreakerOpts:    initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "options", opts)
+
+			fl, err := filesink.NewFile(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising file provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", fl.Room(), "type", provType)
+			provs = append(provs, fl)
+
+		case "kafka":
+			opts := broker.KafkaOpts{
+				Brokers:       ko.Strings(fmt.Sprintf("%s.brokers", cfgKey)),
+				Topic:         ko.String(fmt.Sprintf("%s.topic", cfgKey)),
+				SASLMechanism: ko.String(fmt.Sprintf("%s.sasl_mechanism", cfgKey)),
+				Username:      ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				Password:      ko.String(fmt.Sprintf("%s.password", cfgKey)),
+				TLS:           ko.Bool(fmt.Sprintf("%s.tls", cfgKey)),
+				TLSSkipVerify: ko.Bool(fmt.Sprintf("%s.tls_skip_verify", cfgKey)),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			kf, err := broker.NewKafka(initBrokerOpts(ko, cfgKey, name, lo, metrics, shared), opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising kafka provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", kf.Room(), "type", provType)
+			provs = append(provs, kf)
+
+		case "nats":
+			opts := broker.NATSOpts{
+				URL:      ko.String(fmt.Sprintf("%s.url", cfgKey)),
+				Subject:  ko.String(fmt.Sprintf("%s.subject", cfgKey)),
+				Username: ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				Password: ko.String(fmt.Sprintf("%s.password", cfgKey)),
+				Token:    ko.String(fmt.Sprintf("%s.token", cfgKey)),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			nt, err := broker.NewNATS(initBrokerOpts(ko, cfgKey, name, lo, metrics, shared), opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising nats provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", nt.Room(), "type", provType)
+			provs = append(provs, nt)
+
+		case "redis":
+			opts := broker.RedisOpts{
+				Address:       ko.String(fmt.Sprintf("%s.address", cfgKey)),
+				Username:      ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				Password:      ko.String(fmt.Sprintf("%s.password", cfgKey)),
+				DB:            ko.Int(fmt.Sprintf("%s.db", cfgKey)),
+				Stream:        ko.String(fmt.Sprintf("%s.stream", cfgKey)),
+				MaxLen:        ko.Int64(fmt.Sprintf("%s.max_len", cfgKey)),
+				TLS:           ko.Bool(fmt.Sprintf("%s.tls", cfgKey)),
+				TLSSkipVerify: ko.Bool(fmt.Sprintf("%s.tls_skip_verify", cfgKey)),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			rd, err := broker.NewRedis(initBrokerOpts(ko, cfgKey, name, lo, metrics, shared), opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising redis provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", rd.Room(), "type", provType)
+			provs = append(provs, rd)
+
+		case "ntfy":
+			opts := ntfy.NtfyOpts{
+				Log:              lo,
+				Metrics:          metrics,
+				DryRun:           ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:      ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:          ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:         ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				RetryMax:         ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:     ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:     ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Endpoint:         ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Topic:            ko.String(fmt.Sprintf("%s.topic", cfgKey)),
+				Room:             name,
+				Template:         ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				Token:            ko.String(fmt.Sprintf("%s.token", cfgKey)),
+				Username:         ko.String(fmt.Sprintf("%s.username", cfgKey)),
+				Password:         ko.String(fmt.Sprintf("%s.password", cfgKey)),
+				SeverityLabel:    ko.String(fmt.Sprintf("%s.severity_label", cfgKey)),
+				Priorities:       ko.IntMap(fmt.Sprintf("%s.priorities", cfgKey)),
+				DefaultPriority:  ko.Int(fmt.Sprintf("%s.default_priority", cfgKey)),
+				ResolvedPriority: ko.Int(fmt.Sprintf("%s.resolved_priority", cfgKey)),
+				ClearResolved:    ko.Bool(fmt.Sprintf("%s.clear_resolved", cfgKey)),
+				Shared:           shared,
+				BreakerOpts:      initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			nf, err := ntfy.NewNtfy(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising ntfy provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", nf.Room(), "type", provType)
+			provs = append(provs, nf)
+
+		case "gotify":
+			opts := gotify.GotifyOpts{
+				Log:              lo,
+				Metrics:          metrics,
+				DryRun:           ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:      ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:          ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:         ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				Endpoint:         ko.String(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:             name,
+				Template:         ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				ThreadTTL:        ko.MustDuration(fmt.Sprintf("%s.thread_ttl", cfgKey)),
+				PruneInterval:    ko.Duration(fmt.Sprintf("%s.prune_interval", cfgKey)),
+				MaxActiveAlerts:  ko.Int(fmt.Sprintf("%s.max_active_alerts", cfgKey)),
+				RetryMax:         ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:     ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:     ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				AppToken:         ko.String(fmt.Sprintf("%s.app_token", cfgKey)),
+				ClientToken:      ko.String(fmt.Sprintf("%s.client_token", cfgKey)),
+				SeverityLabel:    ko.String(fmt.Sprintf("%s.severity_label", cfgKey)),
+				Priorities:       ko.IntMap(fmt.Sprintf("%s.priorities", cfgKey)),
+				DefaultPriority:  ko.Int(fmt.Sprintf("%s.default_priority", cfgKey)),
+				ResolvedPriority: ko.Int(fmt.Sprintf("%s.resolved_priority", cfgKey)),
+				Shared:           shared,
+				BreakerOpts:      initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			gt, err := gotify.NewGotify(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising gotify provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", gt.Room(), "type", provType)
+			provs = append(provs, gt)
+
+		case "pushover":
+			opts := pushover.PushoverOpts{
+				Log:              lo,
+				Metrics:          metrics,
+				DryRun:           ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+				MaxIdleConn:      ko.MustInt(fmt.Sprintf("%s.max_idle_conns", cfgKey)),
+				Timeout:          ko.MustDuration(fmt.Sprintf("%s.timeout", cfgKey)),
+				ProxyURL:         ko.String(fmt.Sprintf("%s.proxy_url", cfgKey)),
+				RetryMax:         ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+				RetryWaitMin:     ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+				RetryWaitMax:     ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+				Endpoint:         ko.MustString(fmt.Sprintf("%s.endpoint", cfgKey)),
+				Room:             name,
+				Template:         ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+				Token:            ko.String(fmt.Sprintf("%s.token", cfgKey)),
+				UserKey:          ko.String(fmt.Sprintf("%s.user_key", cfgKey)),
+				Device:           ko.String(fmt.Sprintf("%s.device", cfgKey)),
+				Sound:            ko.String(fmt.Sprintf("%s.sound", cfgKey)),
+				Retry:            ko.Duration(fmt.Sprintf("%s.emergency_retry", cfgKey)),
+				Expire:           ko.Duration(fmt.Sprintf("%s.emergency_expire", cfgKey)),
+				SeverityLabel:    ko.String(fmt.Sprintf("%s.severity_label", cfgKey)),
+				Priorities:       ko.IntMap(fmt.Sprintf("%s.priorities", cfgKey)),
+				DefaultPriority:  ko.Int(fmt.Sprintf("%s.default_priority", cfgKey)),
+				ResolvedPriority: ko.Int(fmt.Sprintf("%s.resolved_priority", cfgKey)),
+				Shared:           shared,
+				BreakerOpts:      initBreakerOpts(ko, cfgKey),
+			}
+			lo.Debug("provider options", "type", provType, "room", name)
+
+			po, err := pushover.NewPushover(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error initialising pushover provider: %s", err)
+			}
+
+			lo.Info("initialised provider", "room", po.Room(), "type", provType)
+			provs = append(provs, po)
+
+		default:
+			return nil, fmt.Errorf("unknown provider type %s for %s", provType, name)
 		}
 	}
 
@@ -147,11 +716,199 @@ func initProviders(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (
 	return provs, nil
 }
 
+// initBrokerOpts returns the options common to the kafka, nats and redis providers.
+func initBrokerOpts(ko *koanf.Koanf, cfgKey, room string, lo *slog.Logger, metrics *metrics.Manager, shared prvs.Shared) broker.BrokerOpts {
+	return broker.BrokerOpts{
+		Log:          lo,
+		Metrics:      metrics,
+		DryRun:       ko.Bool(fmt.Sprintf("%s.dry_run", cfgKey)),
+		Room:         room,
+		Template:     ko.MustString(fmt.Sprintf("%s.template", cfgKey)),
+		Format:       ko.String(fmt.Sprintf("%s.format", cfgKey)),
+		Timeout:      ko.Duration(fmt.Sprintf("%s.timeout", cfgKey)),
+		RetryMax:     ko.Int(fmt.Sprintf("%s.retry_max", cfgKey)),
+		RetryWaitMin: ko.Duration(fmt.Sprintf("%s.retry_wait_min", cfgKey)),
+		RetryWaitMax: ko.Duration(fmt.Sprintf("%s.retry_wait_max", cfgKey)),
+		Shared:       shared,
+		BreakerOpts:  initBreakerOpts(ko, cfgKey),
+	}
+}
+
+// initBreakerOpts returns the options of the circuit breaker of a provider.
+func initBreakerOpts(ko *koanf.Koanf, cfgKey string) prvs.BreakerOpts {
+	return prvs.BreakerOpts{
+		BreakerThreshold:      ko.Int(fmt.Sprintf("%s.circuit_breaker_threshold", cfgKey)),
+		BreakerOpenInterval:   ko.Duration(fmt.Sprintf("%s.circuit_breaker_open_interval", cfgKey)),
+		BreakerHalfOpenProbes: ko.Int(fmt.Sprintf("%s.circuit_breaker_half_open_probes", cfgKey)),
+	}
+}
+
+// initMentions loads the mapping of label values to @-mentions.
+// It returns nil if no mapping file is configured.
+func initMentions(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (*mentions.Mapper, error) {
+	path := ko.String("mentions.file")
+	if path == "" {
+		return nil, nil
+	}
+
+	m, err := mentions.New(mentions.Opts{
+		Log:     lo,
+		Metrics: metrics,
+		File:    path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload the mapping whenever the file changes.
+	interval := ko.Duration("mentions.reload_interval")
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	go m.StartReloadWorker(interval)
+
+	return m, nil
+}
+
+// initOnCall loads the on-call schedules of teams.
+// It returns nil if there are no schedules.
+func initOnCall(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (*oncall.Resolver, error) {
+	teams := ko.MapKeys("oncall.teams")
+	if len(teams) == 0 {
+		return nil, nil
+	}
+
+	sources := make(map[string]oncall.SourceOpts, len(teams))
+	for _, team := range teams {
+		cfgKey := fmt.Sprintf("oncall.teams.%s", team)
+		sources[team] = oncall.SourceOpts{
+			File: ko.String(fmt.Sprintf("%s.file", cfgKey)),
+			URL:  ko.String(fmt.Sprintf("%s.url", cfgKey)),
+		}
+	}
+
+	timeout := ko.Duration("oncall.timeout")
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	r, err := oncall.New(oncall.Opts{
+		Log:     lo,
+		Metrics: metrics,
+		Sources: sources,
+		Client:  &http.Client{Timeout: timeout},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading on-call schedules: %w", err)
+	}
+	lo.Info("loaded on-call schedules", "teams", r.Teams())
+
+	// Reload the schedules periodically to pick up changes.
+	interval := ko.Duration("oncall.reload_interval")
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	go r.StartReloadWorker(interval)
+
+	return r, nil
+}
+
+// initHistory initialises the store of dispatched notifications.
+// It returns nil if history isn't enabled.
+func initHistory(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (*history.Store, error) {
+	if !ko.Bool("history.enabled") {
+		return nil, nil
+	}
+
+	dir := ko.String("app.data_dir")
+	if dir == "" {
+		return nil, fmt.Errorf("app.data_dir is required for history")
+	}
+
+	retention := ko.Duration("history.retention")
+	if retention == 0 {
+		retention = 7 * 24 * time.Hour
+	}
+
+	s, err := history.New(history.Opts{
+		Log:       lo,
+		Metrics:   metrics,
+		Dir:       filepath.Join(dir, "history"),
+		Retention: retention,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the history past the retention.
+	if err := s.Prune(time.Now()); err != nil {
+		lo.Error("error pruning history", "error", err)
+	}
+	go s.StartPruneWorker(time.Hour)
+
+	return s, nil
+}
+
 // initNotifier initializes a Notifier instance.
-func initNotifier(ko *koanf.Koanf, lo *slog.Logger, provs []prvs.Provider) (notifier.Notifier, error) {
+func initNotifier(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager, provs []prvs.Provider, resolver *oncall.Resolver) (notifier.Notifier, error) {
+	// Collect the fallback rooms for all the providers.
+	fallbacks := make(map[string]string)
+	for _, name := range ko.MapKeys("providers") {
+		if fallback := ko.String(fmt.Sprintf("providers.%s.fallback_room", name)); fallback != "" {
+			fallbacks[name] = fallback
+		}
+	}
+
+	// Collect the room groups which fan out alerts to multiple rooms.
+	groups := make(map[string][]string)
+	for _, name := range ko.MapKeys("rooms") {
+		groups[name] = ko.Strings(fmt.Sprintf("rooms.%s.targets", name))
+	}
+
+	muter, err := initMuter(ko, lo, metrics)
+	if err != nil {
+		return notifier.Notifier{}, err
+	}
+
+	// Collect the digest schedules of rooms.
+	digests := make(map[string]*digest.Schedule)
+	for _, name := range ko.MapKeys("digests") {
+		cfgKey := fmt.Sprintf("digests.%s", name)
+		sched, err := digest.NewSchedule(digest.ScheduleOpts{
+			Interval: ko.Duration(fmt.Sprintf("%s.interval", cfgKey)),
+			At:       ko.Strings(fmt.Sprintf("%s.at", cfgKey)),
+			Timezone: ko.String(fmt.Sprintf("%s.timezone", cfgKey)),
+		})
+		if err != nil {
+			return notifier.Notifier{}, fmt.Errorf("error initialising digest for %s: %s", name, err)
+		}
+		digests[name] = sched
+	}
+
+	escalations, err := initEscalations(ko, lo)
+	if err != nil {
+		return notifier.Notifier{}, err
+	}
+
+	digestStateFile := ""
+	if dir := ko.String("app.data_dir"); dir != "" {
+		digestStateFile = filepath.Join(dir, "digest.json")
+	} else if len(digests) > 0 {
+		lo.Warn("app.data_dir not configured, buffered digest alerts will be lost on restart")
+	}
+
 	n, err := notifier.Init(notifier.Opts{
 		Providers: provs,
-		Log:       lo,
+		Fallbacks: fallbacks,
+		Groups:    groups,
+		Muter:     muter,
+
+		Digests:         digests,
+		DigestStateFile: digestStateFile,
+		Escalations:     escalations,
+		OnCall:          resolver,
+		Log:             lo,
+		Metrics:         metrics,
 	})
 	if err != nil {
 		return notifier.Notifier{}, fmt.Errorf("error initialising notifier: %s", err)
@@ -160,6 +917,97 @@ func initNotifier(ko *koanf.Koanf, lo *slog.Logger, provs []prvs.Provider) (noti
 	return n, err
 }
 
+// initMuter loads all the mute rules specified in the config.
+// It returns nil if there are no mute rules.
+func initMuter(ko *koanf.Koanf, lo *slog.Logger, metrics *metrics.Manager) (*mute.Muter, error) {
+	names := ko.MapKeys("mutes")
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*mute.Rule, 0, len(names))
+	for _, name := range names {
+		cfgKey := fmt.Sprintf("mutes.%s", name)
+
+		r, err := mute.NewRule(mute.RuleOpts{
+			Name:      name,
+			Rooms:     ko.Strings(fmt.Sprintf("%s.rooms", cfgKey)),
+			Matchers:  ko.Strings(fmt.Sprintf("%s.matchers", cfgKey)),
+			Timezone:  ko.String(fmt.Sprintf("%s.timezone", cfgKey)),
+			Start:     ko.String(fmt.Sprintf("%s.start", cfgKey)),
+			End:       ko.String(fmt.Sprintf("%s.end", cfgKey)),
+			Weekdays:  ko.Strings(fmt.Sprintf("%s.weekdays", cfgKey)),
+			StartTime: ko.String(fmt.Sprintf("%s.start_time", cfgKey)),
+			EndTime:   ko.String(fmt.Sprintf("%s.end_time", cfgKey)),
+			Action:    mute.Action(ko.String(fmt.Sprintf("%s.action", cfgKey))),
+			Severity:  ko.String(fmt.Sprintf("%s.severity", cfgKey)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error initialising mute rule %s: %s", name, err)
+		}
+
+		lo.Info("initialised mute rule", "name", name)
+		rules = append(rules, r)
+	}
+
+	return mute.New(rules, lo, metrics), nil
+}
+
+// initEscalations loads all the escalation policies specified in the config.
+// Policies are evaluated in alphabetical order of their names.
+func initEscalations(ko *koanf.Koanf, lo *slog.Logger) ([]*escalation.Policy, error) {
+	names := ko.MapKeys("escalations")
+	policies := make([]*escalation.Policy, 0, len(names))
+
+	for _, name := range names {
+		cfgKey := fmt.Sprintf("escalations.%s", name)
+
+		var steps []escalation.StepOpts
+		for _, s := range ko.Slices(fmt.Sprintf("%s.steps", cfgKey)) {
+			steps = append(steps, escalation.StepOpts{
+				After:   s.Duration("after"),
+				Room:    s.String("room"),
+				Mention: s.String("mention"),
+				Webhook: s.String("webhook"),
+				OnCall:  s.String("oncall"),
+			})
+		}
+
+		p, err := escalation.NewPolicy(escalation.PolicyOpts{
+			Name:     name,
+			Rooms:    ko.Strings(fmt.Sprintf("%s.rooms", cfgKey)),
+			Matchers: ko.Strings(fmt.Sprintf("%s.matchers", cfgKey)),
+			Steps:    steps,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error initialising escalation policy %s: %s", name, err)
+		}
+
+		lo.Info("initialised escalation policy", "name", name, "steps", len(steps))
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// initAlertmanager initializes an Alertmanager API client.
+// It returns nil if Alertmanager isn't configured.
+func initAlertmanager(ko *koanf.Koanf) (*alertmanager.Client, error) {
+	if ko.String("alertmanager.url") == "" {
+		return nil, nil
+	}
+
+	timeout := 10 * time.Second
+	if ko.Exists("alertmanager.timeout") {
+		timeout = ko.MustDuration("alertmanager.timeout")
+	}
+
+	return alertmanager.New(alertmanager.Opts{
+		URL:     ko.String("alertmanager.url"),
+		Timeout: timeout,
+	})
+}
+
 // initMetrics initializes a Metrics manager.
 func initMetrics() *metrics.Manager {
 	return metrics.New("calert")
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mr-karan/calert/internal/alertmanager"
+	"github.com/mr-karan/calert/internal/history"
 	"github.com/mr-karan/calert/internal/metrics"
 	"github.com/mr-karan/calert/internal/notifier"
+	prvs "github.com/mr-karan/calert/internal/providers"
 )
 
 var (
@@ -19,9 +26,15 @@ var (
 // App is the global contains
 // instances of various objects used in the lifecyle of program.
 type App struct {
-	lo       *slog.Logger
-	metrics  *metrics.Manager
-	notifier notifier.Notifier
+	lo           *slog.Logger
+	metrics      *metrics.Manager
+	notifier     notifier.Notifier
+	alertmanager *alertmanager.Client
+	history      *history.Store
+
+	// interactionToken is the shared token expected
+	// on interaction events from Google Chat.
+	interactionToken string
 }
 
 func main() {
@@ -42,24 +55,55 @@ func main() {
 	}
 	lo := initLogger(verbose)
 
+	// Initialise mentions.
+	mentions, err := initMentions(ko, lo, metrics)
+	if err != nil {
+		lo.Error("error initialising mentions", "error", err)
+		exit()
+	}
+
+	// Initialise on-call schedules.
+	resolver, err := initOnCall(ko, lo, metrics)
+	if err != nil {
+		lo.Error("error initialising on-call schedules", "error", err)
+		exit()
+	}
+
+	// Initialise history.
+	hist, err := initHistory(ko, lo, metrics)
+	if err != nil {
+		lo.Error("error initialising history", "error", err)
+		exit()
+	}
+
 	// Initialise providers.
-	provs, err := initProviders(ko, lo, metrics)
+	provs, err := initProviders(ko, lo, metrics, mentions, resolver, hist)
 	if err != nil {
 		lo.Error("error initialising providers", "error", err)
 		exit()
 	}
 
 	// Initialise notifier.
-	notifier, err := initNotifier(ko, lo, provs)
+	notifier, err := initNotifier(ko, lo, metrics, provs, resolver)
 	if err != nil {
 		lo.Error("error initialising notifier", "error", err)
 		exit()
 	}
 
+	// Initialise Alertmanager client.
+	am, err := initAlertmanager(ko)
+	if err != nil {
+		lo.Error("error initialising alertmanager client", "error", err)
+		exit()
+	}
+
 	app := &App{
-		lo:       lo,
-		notifier: notifier,
-		metrics:  metrics,
+		lo:               lo,
+		notifier:         notifier,
+		metrics:          metrics,
+		alertmanager:     am,
+		history:          hist,
+		interactionToken: ko.String("interactions.token"),
 	}
 
 	app.lo.Info("starting calert", "version", buildString, "verbose", verbose)
@@ -78,6 +122,32 @@ func main() {
 	r.Get("/ping", wrap(app, handleHealthCheck))
 	r.Get("/metrics", wrap(app, handleMetrics))
 	r.Post("/dispatch", wrap(app, handleDispatchNotif))
+	// Interactions create silences, so they're only enabled if a token is configured.
+	if app.interactionToken != "" {
+		r.Post("/interactions/google_chat", wrap(app, handleGoogleChatInteraction))
+	} else {
+		app.lo.Info("interactions token not configured, disabling google chat interactions")
+	}
+
+	// Admin APIs are only enabled if credentials are configured.
+	if user, pass := ko.String("app.admin_username"), ko.String("app.admin_password"); user != "" && pass != "" {
+		auth := middleware.BasicAuth("calert", map[string]string{user: pass})
+		r.Route("/api", func(r chi.Router) {
+			r.Use(auth)
+			r.Get("/alerts/unacked", wrap(app, handleUnackedAlerts))
+			r.Get("/alerts", wrap(app, handleActiveAlerts))
+			r.Get("/alerts/{fingerprint}", wrap(app, handleActiveAlert))
+			r.Delete("/alerts/{fingerprint}", wrap(app, handleDeleteActiveAlert))
+			r.Delete("/rooms/{room}/alerts", wrap(app, handleFlushActiveAlerts))
+			r.Get("/state", wrap(app, handleExportState))
+			r.Post("/state", wrap(app, handleImportState))
+		})
+		if hist != nil {
+			r.With(auth).Get("/history", wrap(app, handleHistory))
+		}
+	} else {
+		app.lo.Info("admin credentials not configured, disabling admin APIs and history")
+	}
 
 	// Start HTTP Server.
 	app.lo.Info("starting http server", "address", ko.MustString("app.address"))
@@ -92,9 +162,32 @@ func main() {
 		WriteTimeout: ko.MustDuration("app.server_timeout"),
 		Handler:      r,
 	}
-	if err := srv.ListenAndServe(); err != nil {
-		app.lo.Error("couldn't start server", "error", err)
-		exit()
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.lo.Error("couldn't start server", "error", err)
+			exit()
+		}
+	}()
+
+	// Wait for a signal to shut down.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	app.lo.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ko.MustDuration("app.server_timeout"))
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		app.lo.Error("error shutting down http server", "error", err)
+	}
+
+	// Close the connections of the providers, once the requests in flight are done.
+	for _, p := range provs {
+		if c, ok := p.(prvs.Closer); ok {
+			if err := c.Close(); err != nil {
+				app.lo.Error("error closing provider", "room", p.Room(), "provider", p.ID(), "error", err)
+			}
+		}
 	}
 }
 
@@ -0,0 +1,114 @@
+// Package alertmanager is a minimal client for the Alertmanager v2 API.
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Client talks to the Alertmanager v2 API.
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// Opts represents the options for a Client.
+type Opts struct {
+	// URL is the base URL of Alertmanager, eg `http://alertmanager:9093`.
+	URL     string
+	Timeout time.Duration
+}
+
+// Matcher is a silence matcher.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// Silence is a silence to be created.
+type Silence struct {
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+// New returns a new Client.
+func New(opts Opts) (*Client, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing alertmanager URL: %s", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid alertmanager URL: %s", opts.URL)
+	}
+
+	return &Client{
+		url:    strings.TrimRight(opts.URL, "/"),
+		client: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// CreateSilence creates a silence and returns its ID.
+func (c *Client) CreateSilence(ctx context.Context, s Silence) (string, error) {
+	if len(s.Matchers) == 0 {
+		return "", fmt.Errorf("silence requires at least one matcher")
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/api/v2/silences", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("non ok response from alertmanager: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", fmt.Errorf("error decoding alertmanager response: %s", err)
+	}
+
+	return out.SilenceID, nil
+}
+
+// MatchersFromLabels returns equality matchers for all the labels,
+// which only match the alert with exactly these labels.
+func MatchersFromLabels(labels map[string]string) []Matcher {
+	out := make([]Matcher, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, Matcher{Name: k, Value: v, IsEqual: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
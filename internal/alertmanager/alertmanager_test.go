@@ -0,0 +1,73 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(Opts{URL: "alertmanager:9093"})
+	assert.Error(t, err)
+
+	_, err = New(Opts{URL: "http://alertmanager:9093/"})
+	assert.NoError(t, err)
+}
+
+func TestCreateSilence(t *testing.T) {
+	var got Silence
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v2/silences", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+
+		if got.CreatedBy == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid silence"))
+			return
+		}
+		w.Write([]byte(`{"silenceID":"abc-123"}`))
+	}))
+	defer server.Close()
+
+	c, err := New(Opts{URL: server.URL + "/", Timeout: time.Second})
+	require.NoError(t, err)
+
+	now := time.Now()
+	t.Run("creates silence", func(t *testing.T) {
+		id, err := c.CreateSilence(context.Background(), Silence{
+			Matchers:  MatchersFromLabels(map[string]string{"instance": "db-1", "alertname": "HighLoad"}),
+			StartsAt:  now,
+			EndsAt:    now.Add(time.Hour),
+			CreatedBy: "Jane",
+			Comment:   "silenced from chat",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "abc-123", id)
+
+		require.Len(t, got.Matchers, 2)
+		assert.Equal(t, Matcher{Name: "alertname", Value: "HighLoad", IsEqual: true}, got.Matchers[0])
+		assert.Equal(t, Matcher{Name: "instance", Value: "db-1", IsEqual: true}, got.Matchers[1])
+		assert.Equal(t, "Jane", got.CreatedBy)
+	})
+
+	t.Run("returns error on non ok response", func(t *testing.T) {
+		_, err := c.CreateSilence(context.Background(), Silence{
+			Matchers:  MatchersFromLabels(map[string]string{"alertname": "HighLoad"}),
+			CreatedBy: "bad",
+		})
+		assert.ErrorContains(t, err, "invalid silence")
+	})
+
+	t.Run("requires matchers", func(t *testing.T) {
+		_, err := c.CreateSilence(context.Background(), Silence{})
+		assert.Error(t, err)
+	})
+}
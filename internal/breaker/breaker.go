@@ -0,0 +1,186 @@
+// Package breaker implements a simple circuit breaker which is used to
+// stop hammering an upstream provider endpoint which is consistently failing.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow when the circuit is open and
+// requests should not be sent upstream.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State represents the state of the circuit.
+type State int
+
+const (
+	// Closed lets all requests through.
+	Closed State = iota
+	// Open rejects all requests until the open interval elapses.
+	Open
+	// HalfOpen lets a limited number of probe requests through.
+	HalfOpen
+)
+
+// String returns the human readable name of the state.
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// Opts represents the options for a Breaker.
+type Opts struct {
+	// FailureThreshold is the number of consecutive failures after which
+	// the circuit opens. A value <= 0 disables the breaker.
+	FailureThreshold int
+	// OpenInterval is the time the circuit stays open before
+	// allowing probe requests.
+	OpenInterval time.Duration
+	// HalfOpenProbes is the number of successful probes required
+	// in half-open state to close the circuit again.
+	HalfOpenProbes int
+	// OnStateChange is called whenever the circuit changes its state.
+	OnStateChange func(State)
+}
+
+// Breaker is a consecutive-failure based circuit breaker.
+type Breaker struct {
+	sync.Mutex
+	opts      Opts
+	state     State
+	failures  int
+	successes int
+	inflight  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+// New returns a new Breaker in closed state.
+func New(opts Opts) *Breaker {
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+
+	b := &Breaker{
+		opts: opts,
+		now:  time.Now,
+	}
+	if b.Enabled() && opts.OnStateChange != nil {
+		opts.OnStateChange(Closed)
+	}
+
+	return b
+}
+
+// Enabled returns whether the breaker is configured to trip at all.
+func (b *Breaker) Enabled() bool {
+	return b.opts.FailureThreshold > 0
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() State {
+	b.Lock()
+	defer b.Unlock()
+
+	b.refresh()
+	return b.state
+}
+
+// Allow returns ErrOpen if a request should not be sent upstream.
+// Every nil return must be followed by a call to either Success or Failure.
+func (b *Breaker) Allow() error {
+	if !b.Enabled() {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.refresh()
+	switch b.state {
+	case Open:
+		return ErrOpen
+	case HalfOpen:
+		// Only allow as many concurrent probes as are required to close the circuit.
+		if b.inflight >= b.opts.HalfOpenProbes-b.successes {
+			return ErrOpen
+		}
+		b.inflight++
+	}
+
+	return nil
+}
+
+// Success records a successful request.
+func (b *Breaker) Success() {
+	if !b.Enabled() {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case HalfOpen:
+		b.inflight--
+		b.successes++
+		if b.successes >= b.opts.HalfOpenProbes {
+			b.setState(Closed)
+		}
+	default:
+		b.failures = 0
+	}
+}
+
+// Failure records a failed request.
+func (b *Breaker) Failure() {
+	if !b.Enabled() {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case HalfOpen:
+		// Any failed probe trips the circuit again.
+		b.setState(Open)
+	case Closed:
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.setState(Open)
+		}
+	}
+}
+
+// refresh moves an open circuit to half-open once the open interval has elapsed.
+// The caller must hold the lock.
+func (b *Breaker) refresh() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.opts.OpenInterval {
+		b.setState(HalfOpen)
+	}
+}
+
+// setState transitions the circuit to the given state and resets the counters.
+// The caller must hold the lock.
+func (b *Breaker) setState(s State) {
+	b.state = s
+	b.failures = 0
+	b.successes = 0
+	b.inflight = 0
+	if s == Open {
+		b.openedAt = b.now()
+	}
+
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(s)
+	}
+}
@@ -0,0 +1,101 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBreaker(opts Opts) (*Breaker, *time.Time) {
+	now := time.Now()
+	b := New(opts)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestDisabledBreaker(t *testing.T) {
+	b := New(Opts{})
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, Closed, b.State())
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(Opts{FailureThreshold: 3, OpenInterval: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, Closed, b.State())
+
+	// A success resets the consecutive failure count.
+	require.NoError(t, b.Allow())
+	b.Success()
+	for i := 0; i < 2; i++ {
+		require.NoError(t, b.Allow())
+		b.Failure()
+	}
+	assert.Equal(t, Closed, b.State())
+
+	require.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	var states []State
+	b, now := newTestBreaker(Opts{
+		FailureThreshold: 1,
+		OpenInterval:     time.Minute,
+		HalfOpenProbes:   2,
+		OnStateChange:    func(s State) { states = append(states, s) },
+	})
+
+	require.NoError(t, b.Allow())
+	b.Failure()
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+
+	t.Run("moves to half-open after interval", func(t *testing.T) {
+		*now = now.Add(time.Minute)
+		assert.Equal(t, HalfOpen, b.State())
+	})
+
+	t.Run("limits concurrent probes", func(t *testing.T) {
+		require.NoError(t, b.Allow())
+		require.NoError(t, b.Allow())
+		assert.ErrorIs(t, b.Allow(), ErrOpen)
+	})
+
+	t.Run("closes after successful probes", func(t *testing.T) {
+		b.Success()
+		assert.Equal(t, HalfOpen, b.State())
+		b.Success()
+		assert.Equal(t, Closed, b.State())
+	})
+
+	t.Run("failed probe opens the circuit again", func(t *testing.T) {
+		require.NoError(t, b.Allow())
+		b.Failure()
+		assert.Equal(t, Open, b.State())
+
+		*now = now.Add(time.Minute)
+		require.NoError(t, b.Allow())
+		b.Failure()
+		assert.Equal(t, Open, b.State())
+	})
+
+	assert.Equal(t, []State{Closed, Open, HalfOpen, Closed, Open, HalfOpen, Open}, states)
+}
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "closed", Closed.String())
+	assert.Equal(t, "open", Open.String())
+	assert.Equal(t, "half_open", HalfOpen.String())
+}
@@ -0,0 +1,348 @@
+// Package digest buffers alerts per room and periodically flushes them
+// as a single digest instead of sending a message per alert.
+package digest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const timeLayout = "15:04"
+
+// ScheduleOpts represents the config for when a room's digest is sent.
+type ScheduleOpts struct {
+	// Interval sends a digest every interval.
+	Interval time.Duration
+	// At sends a digest at fixed times of the day in `15:04` format.
+	At []string
+	// Timezone in which At is evaluated. Defaults to UTC.
+	Timezone string
+}
+
+// Schedule decides when the next digest for a room is sent.
+type Schedule struct {
+	interval time.Duration
+	at       []time.Duration
+	loc      *time.Location
+}
+
+// NewSchedule parses and validates a schedule.
+func NewSchedule(opts ScheduleOpts) (*Schedule, error) {
+	s := &Schedule{
+		interval: opts.Interval,
+		loc:      time.UTC,
+	}
+
+	if (opts.Interval > 0) == (len(opts.At) > 0) {
+		return nil, fmt.Errorf("exactly one of interval or at is required")
+	}
+
+	if opts.Timezone != "" {
+		loc, err := time.LoadLocation(opts.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("error loading timezone: %w", err)
+		}
+		s.loc = loc
+	}
+
+	for _, at := range opts.At {
+		t, err := time.Parse(timeLayout, at)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing time %q: %w", at, err)
+		}
+		s.at = append(s.at, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	sort.Slice(s.at, func(i, j int) bool { return s.at[i] < s.at[j] })
+
+	return s, nil
+}
+
+// Next returns the time of the next digest after `now`.
+func (s *Schedule) Next(now time.Time) time.Time {
+	if s.interval > 0 {
+		return now.Add(s.interval)
+	}
+
+	now = now.In(s.loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
+	for _, at := range s.at {
+		if t := midnight.Add(at); t.After(now) {
+			return t
+		}
+	}
+
+	// All the times for today have passed.
+	return midnight.AddDate(0, 0, 1).Add(s.at[0])
+}
+
+// entry is the buffered state of an alert.
+type entry struct {
+	Alert alertmgrtmpl.Alert `json:"alert"`
+	Count int                `json:"count"`
+}
+
+// buffer holds the alerts of a room since the last digest.
+type buffer struct {
+	From    time.Time         `json:"from"`
+	Entries map[string]*entry `json:"entries"`
+}
+
+// Opts represents the options for a Scheduler.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// Schedules is a map of room names to their digest schedule.
+	Schedules map[string]*Schedule
+	// StateFile is where the buffers are persisted so that they survive
+	// restarts. The buffers are only kept in memory if empty.
+	StateFile string
+	// Flush is called with the digest of a room when it's due.
+	Flush func(d providers.Digest) error
+}
+
+// Scheduler buffers alerts per room and flushes them as digests on schedule.
+type Scheduler struct {
+	sync.Mutex
+	lo        *slog.Logger
+	metrics   *metrics.Manager
+	schedules map[string]*Schedule
+	stateFile string
+	flush     func(d providers.Digest) error
+	buffers   map[string]*buffer
+}
+
+// New returns a Scheduler. Buffers persisted in the state file are restored.
+func New(opts Opts) (*Scheduler, error) {
+	s := &Scheduler{
+		lo:        opts.Log,
+		metrics:   opts.Metrics,
+		schedules: opts.Schedules,
+		stateFile: opts.StateFile,
+		flush:     opts.Flush,
+		buffers:   make(map[string]*buffer),
+	}
+
+	// The data dir may not exist yet, eg if nothing else is persisted in it.
+	if s.stateFile != "" {
+		if err := os.MkdirAll(filepath.Dir(s.stateFile), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating digest state dir: %w", err)
+		}
+	}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("error loading digest state: %w", err)
+	}
+
+	return s, nil
+}
+
+// Has returns whether digests are enabled for the room.
+func (s *Scheduler) Has(room string) bool {
+	_, ok := s.schedules[room]
+	return ok
+}
+
+// Add buffers the alerts for the room's next digest.
+func (s *Scheduler) Add(alerts []alertmgrtmpl.Alert, room string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	b, ok := s.buffers[room]
+	if !ok {
+		b = &buffer{From: time.Now(), Entries: make(map[string]*entry)}
+		s.buffers[room] = b
+	}
+
+	for _, a := range alerts {
+		e, ok := b.Entries[a.Fingerprint]
+		if !ok {
+			e = &entry{}
+			b.Entries[a.Fingerprint] = e
+		}
+		e.Alert = a
+		e.Count++
+	}
+	s.metrics.Set(fmt.Sprintf(`digest_buffered_alerts{room="%s"}`, room), float64(len(b.Entries)))
+
+	return s.save()
+}
+
+// Start starts a background worker per room which flushes the digests on schedule.
+func (s *Scheduler) Start() {
+	for room, sched := range s.schedules {
+		go s.startWorker(room, sched)
+	}
+}
+
+// startWorker flushes the room's digest on schedule.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (s *Scheduler) startWorker(room string, sched *Schedule) {
+	for {
+		next := sched.Next(time.Now())
+		s.lo.Debug("scheduled next digest", "room", room, "at", next)
+		time.Sleep(time.Until(next))
+
+		if err := s.Flush(room, time.Now()); err != nil {
+			s.lo.Error("error sending digest", "room", room, "error", err)
+		}
+	}
+}
+
+// Flush sends the digest of the alerts buffered for the room. If sending fails,
+// the alerts are kept in the buffer for the next digest.
+func (s *Scheduler) Flush(room string, now time.Time) error {
+	s.Lock()
+	b, ok := s.buffers[room]
+	if !ok || len(b.Entries) == 0 {
+		s.Unlock()
+		return nil
+	}
+	delete(s.buffers, room)
+	s.Unlock()
+
+	d := build(b, room, now)
+	if err := s.flush(d); err != nil {
+		s.metrics.Increment(fmt.Sprintf(`digests_sent_errors_total{room="%s"}`, room))
+		s.restore(b, room)
+		return err
+	}
+
+	s.metrics.Increment(fmt.Sprintf(`digests_sent_total{room="%s"}`, room))
+	s.lo.Info("sent digest", "room", room, "alerts", len(d.Alerts), "firing", d.Firing, "resolved", d.Resolved)
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Alerts might've been buffered while the digest was being sent.
+	buffered := 0
+	if b, ok := s.buffers[room]; ok {
+		buffered = len(b.Entries)
+	}
+	s.metrics.Set(fmt.Sprintf(`digest_buffered_alerts{room="%s"}`, room), float64(buffered))
+
+	return s.save()
+}
+
+// restore merges back a buffer which couldn't be flushed. Notifications received
+// in the meantime are newer and take precedence.
+func (s *Scheduler) restore(old *buffer, room string) {
+	s.Lock()
+	defer s.Unlock()
+
+	b, ok := s.buffers[room]
+	if !ok {
+		s.buffers[room] = old
+	} else {
+		b.From = old.From
+		for fp, e := range old.Entries {
+			if n, ok := b.Entries[fp]; ok {
+				n.Count += e.Count
+				continue
+			}
+			b.Entries[fp] = e
+		}
+	}
+
+	if err := s.save(); err != nil {
+		s.lo.Error("error saving digest state", "error", err)
+	}
+}
+
+// build builds a digest from the buffered alerts.
+func build(b *buffer, room string, now time.Time) providers.Digest {
+	d := providers.Digest{
+		Room:   room,
+		From:   b.From,
+		To:     now,
+		Alerts: make([]providers.DigestAlert, 0, len(b.Entries)),
+	}
+
+	for _, e := range b.Entries {
+		a := providers.DigestAlert{Alert: e.Alert, Count: e.Count}
+		if e.Alert.Status == "resolved" {
+			d.Resolved++
+			a.Duration = e.Alert.EndsAt.Sub(e.Alert.StartsAt)
+		} else {
+			d.Firing++
+			a.Duration = now.Sub(e.Alert.StartsAt)
+		}
+		if e.Alert.StartsAt.IsZero() || a.Duration < 0 {
+			a.Duration = 0
+		}
+		a.Duration = a.Duration.Round(time.Second)
+		d.Alerts = append(d.Alerts, a)
+	}
+
+	// Firing alerts first, oldest first.
+	sort.Slice(d.Alerts, func(i, j int) bool {
+		if d.Alerts[i].Status != d.Alerts[j].Status {
+			return d.Alerts[i].Status == "firing"
+		}
+		if !d.Alerts[i].StartsAt.Equal(d.Alerts[j].StartsAt) {
+			return d.Alerts[i].StartsAt.Before(d.Alerts[j].StartsAt)
+		}
+		return d.Alerts[i].Fingerprint < d.Alerts[j].Fingerprint
+	})
+
+	return d
+}
+
+// load restores the buffers from the state file.
+func (s *Scheduler) load() error {
+	if s.stateFile == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, &s.buffers); err != nil {
+		return err
+	}
+
+	// Drop the buffers of rooms which no longer have digests enabled.
+	for room := range s.buffers {
+		if !s.Has(room) {
+			s.lo.Warn("dropping buffered digest alerts for unknown room", "room", room)
+			delete(s.buffers, room)
+		}
+	}
+
+	return nil
+}
+
+// save persists the buffers to the state file. The caller must hold the lock.
+func (s *Scheduler) save() error {
+	if s.stateFile == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.buffers)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename it so that a crash
+	// doesn't leave behind a corrupt state file.
+	tmp := filepath.Join(filepath.Dir(s.stateFile), "."+filepath.Base(s.stateFile)+".tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.stateFile)
+}
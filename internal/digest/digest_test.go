@@ -0,0 +1,175 @@
+package digest
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSchedule(t *testing.T) {
+	tests := map[string]ScheduleOpts{
+		"neither interval nor at": {},
+		"both interval and at":    {Interval: time.Minute, At: []string{"09:00"}},
+		"bad time":                {At: []string{"9am"}},
+		"bad timezone":            {At: []string{"09:00"}, Timezone: "Mars/Olympus"},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSchedule(opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	t.Run("interval", func(t *testing.T) {
+		s, err := NewSchedule(ScheduleOpts{Interval: 30 * time.Minute})
+		require.NoError(t, err)
+
+		now := time.Now()
+		assert.Equal(t, now.Add(30*time.Minute), s.Next(now))
+	})
+
+	t.Run("fixed times", func(t *testing.T) {
+		s, err := NewSchedule(ScheduleOpts{At: []string{"18:00", "09:00"}, Timezone: "Asia/Kolkata"})
+		require.NoError(t, err)
+
+		loc, _ := time.LoadLocation("Asia/Kolkata")
+		at := func(day, hour, min int) time.Time {
+			return time.Date(2026, 10, day, hour, min, 0, 0, loc)
+		}
+
+		assert.Equal(t, at(20, 9, 0), s.Next(at(20, 8, 0)))
+		assert.Equal(t, at(20, 18, 0), s.Next(at(20, 9, 0)))
+		assert.Equal(t, at(21, 9, 0), s.Next(at(20, 18, 30)))
+		// Times are evaluated in the schedule's timezone.
+		assert.True(t, at(20, 18, 0).Equal(s.Next(at(20, 12, 0).UTC())))
+	})
+}
+
+func newTestScheduler(t *testing.T, stateFile string, flush func(d providers.Digest) error) *Scheduler {
+	sched, err := NewSchedule(ScheduleOpts{Interval: time.Hour})
+	require.NoError(t, err)
+
+	s, err := New(Opts{
+		Log:       slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:   metrics.New("calert"),
+		Schedules: map[string]*Schedule{"info_alerts": sched},
+		StateFile: stateFile,
+		Flush:     flush,
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestFlush(t *testing.T) {
+	var (
+		now     = time.Now()
+		sent    []providers.Digest
+		sendErr error
+	)
+	s := newTestScheduler(t, "", func(d providers.Digest) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		sent = append(sent, d)
+		return nil
+	})
+
+	assert.True(t, s.Has("info_alerts"))
+	assert.False(t, s.Has("prod_alerts"))
+
+	t.Run("skips empty digests", func(t *testing.T) {
+		require.NoError(t, s.Flush("info_alerts", now))
+		assert.Empty(t, sent)
+	})
+
+	t.Run("summarises alerts", func(t *testing.T) {
+		require.NoError(t, s.Add([]alertmgrtmpl.Alert{
+			{Fingerprint: "a1", Status: "firing", StartsAt: now.Add(-time.Hour)},
+			{Fingerprint: "a2", Status: "firing", StartsAt: now.Add(-2 * time.Hour)},
+		}, "info_alerts"))
+		require.NoError(t, s.Add([]alertmgrtmpl.Alert{
+			{Fingerprint: "a1", Status: "resolved", StartsAt: now.Add(-time.Hour), EndsAt: now.Add(-30 * time.Minute)},
+			{Fingerprint: "a3", Status: "firing", StartsAt: now.Add(-10 * time.Minute)},
+		}, "info_alerts"))
+
+		require.NoError(t, s.Flush("info_alerts", now))
+		require.Len(t, sent, 1)
+
+		d := sent[0]
+		assert.Equal(t, "info_alerts", d.Room)
+		assert.Equal(t, 2, d.Firing)
+		assert.Equal(t, 1, d.Resolved)
+		require.Len(t, d.Alerts, 3)
+
+		// Firing alerts come first, oldest first.
+		assert.Equal(t, "a2", d.Alerts[0].Fingerprint)
+		assert.Equal(t, 2*time.Hour, d.Alerts[0].Duration)
+		assert.Equal(t, "a3", d.Alerts[1].Fingerprint)
+		assert.Equal(t, "a1", d.Alerts[2].Fingerprint)
+		assert.Equal(t, 2, d.Alerts[2].Count)
+		assert.Equal(t, 30*time.Minute, d.Alerts[2].Duration)
+
+		// The buffer is cleared after a digest.
+		require.NoError(t, s.Flush("info_alerts", now))
+		assert.Len(t, sent, 1)
+	})
+
+	t.Run("keeps alerts if sending fails", func(t *testing.T) {
+		sent = nil
+		require.NoError(t, s.Add([]alertmgrtmpl.Alert{{Fingerprint: "a1", Status: "firing"}}, "info_alerts"))
+
+		sendErr = errors.New("boom")
+		assert.Error(t, s.Flush("info_alerts", now))
+
+		sendErr = nil
+		require.NoError(t, s.Add([]alertmgrtmpl.Alert{{Fingerprint: "a1", Status: "firing"}}, "info_alerts"))
+		require.NoError(t, s.Flush("info_alerts", now))
+		require.Len(t, sent, 1)
+		require.Len(t, sent[0].Alerts, 1)
+		assert.Equal(t, 2, sent[0].Alerts[0].Count)
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "digest.json")
+
+	s := newTestScheduler(t, stateFile, func(d providers.Digest) error { return nil })
+	require.NoError(t, s.Add([]alertmgrtmpl.Alert{{Fingerprint: "a1", Status: "firing"}}, "info_alerts"))
+
+	// A new scheduler picks up the buffered alerts.
+	var sent []providers.Digest
+	s = newTestScheduler(t, stateFile, func(d providers.Digest) error {
+		sent = append(sent, d)
+		return nil
+	})
+	require.NoError(t, s.Flush("info_alerts", time.Now()))
+	require.Len(t, sent, 1)
+	assert.Equal(t, "a1", sent[0].Alerts[0].Fingerprint)
+
+	// The flushed buffer is persisted as well.
+	s = newTestScheduler(t, stateFile, func(d providers.Digest) error {
+		t.Fatal("unexpected digest")
+		return nil
+	})
+	require.NoError(t, s.Flush("info_alerts", time.Now()))
+}
+
+func TestPersistenceNewDir(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "data", "digest.json")
+
+	// The data dir is created for the state file.
+	s := newTestScheduler(t, stateFile, func(d providers.Digest) error { return nil })
+	require.NoError(t, s.Add([]alertmgrtmpl.Alert{{Fingerprint: "a1", Status: "firing"}}, "info_alerts"))
+	assert.FileExists(t, stateFile)
+}
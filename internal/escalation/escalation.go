@@ -0,0 +1,443 @@
+// Package escalation re-notifies about alerts which stay unresolved and
+// unacknowledged for too long, by following the steps of an escalation policy.
+package escalation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/oncall"
+	"github.com/mr-karan/calert/internal/state"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	// PolicyAnnotation is added to escalated alerts with the name of the policy.
+	PolicyAnnotation = "calert_escalation"
+	// StepAnnotation is added to escalated alerts with the step number, starting from 1.
+	StepAnnotation = "calert_escalation_step"
+	// MentionAnnotation is added to escalated alerts with the mention of the step, if any.
+	MentionAnnotation = "calert_escalation_mention"
+	// OnCallAnnotation is added to escalated alerts with the users on call, if the step mentions them.
+	OnCallAnnotation = "calert_escalation_oncall"
+)
+
+// StepOpts represents the config for a step of an escalation policy.
+type StepOpts struct {
+	// After is how long the alert has to be firing for the step to run.
+	After time.Duration
+	// Room to repost the alert to. Defaults to the room the alert was sent to.
+	Room string
+	// Mention is a comma separated list of user IDs (eg `users/all`) or label values
+	// mapped in the mentions file, rendered by the templates of the target room.
+	Mention string
+	// Webhook is a URL the alert is posted to instead of reposting it to a room.
+	Webhook string
+	// OnCall is the label (eg `team`) whose value is the team whose on-call
+	// users are mentioned in the reposted alert, or sent to the webhook.
+	OnCall string
+}
+
+// PolicyOpts represents the config for an escalation policy.
+type PolicyOpts struct {
+	Name string
+	// Rooms the policy applies to. Empty means all rooms.
+	Rooms []string
+	// Matchers is a list of Alertmanager style label matchers, eg `severity="critical"`.
+	// All of them must match for the policy to apply.
+	Matchers []string
+	Steps    []StepOpts
+}
+
+// Policy is a parsed escalation policy.
+type Policy struct {
+	name     string
+	rooms    map[string]bool
+	matchers labels.Matchers
+	steps    []StepOpts
+}
+
+// NewPolicy parses and validates an escalation policy.
+func NewPolicy(opts PolicyOpts) (*Policy, error) {
+	p := &Policy{
+		name:  opts.Name,
+		rooms: make(map[string]bool, len(opts.Rooms)),
+		steps: append([]StepOpts(nil), opts.Steps...),
+	}
+
+	for _, room := range opts.Rooms {
+		p.rooms[room] = true
+	}
+
+	for _, s := range opts.Matchers {
+		m, err := labels.ParseMatchers(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing matcher %q: %w", s, err)
+		}
+		p.matchers = append(p.matchers, m...)
+	}
+
+	if len(p.steps) == 0 {
+		return nil, fmt.Errorf("at least one step is required")
+	}
+	for i, s := range p.steps {
+		if s.After <= 0 {
+			return nil, fmt.Errorf("step %d: after must be greater than 0", i+1)
+		}
+		if s.Webhook != "" && (s.Room != "" || s.Mention != "") {
+			return nil, fmt.Errorf("step %d: webhook can't be combined with room or mention", i+1)
+		}
+		if s.Webhook == "" && s.Room == "" && s.Mention == "" && s.OnCall == "" {
+			return nil, fmt.Errorf("step %d: one of room, mention, oncall or webhook is required", i+1)
+		}
+	}
+	sort.SliceStable(p.steps, func(i, j int) bool { return p.steps[i].After < p.steps[j].After })
+
+	return p, nil
+}
+
+// Name returns the name of the policy.
+func (p *Policy) Name() string {
+	return p.name
+}
+
+// StepRooms returns the rooms the steps of the policy repost alerts to.
+func (p *Policy) StepRooms() []string {
+	out := make([]string, 0, len(p.steps))
+	for _, s := range p.steps {
+		if s.Room != "" {
+			out = append(out, s.Room)
+		}
+	}
+	return out
+}
+
+// Matches returns whether the policy applies to the alert sent to the room.
+func (p *Policy) Matches(a alertmgrtmpl.Alert, room string) bool {
+	if len(p.rooms) > 0 && !p.rooms[room] {
+		return false
+	}
+	for _, m := range p.matchers {
+		if !m.Matches(a.Labels[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Opts represents the options for an Escalator.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// Policies are evaluated in order and the first matching policy wins.
+	Policies []*Policy
+	// Push delivers escalated alerts to a room.
+	Push func(alerts []alertmgrtmpl.Alert, room string) error
+	// States returns the active alerts of the rooms an alert sent to `room` is
+	// delivered to. They're used to cancel escalations of acknowledged alerts.
+	States func(room string) []*state.ActiveAlerts
+	// Client is the HTTP client for webhook steps.
+	Client *http.Client
+	// OnCall resolves the on-call users of steps which mention them.
+	OnCall *oncall.Resolver
+}
+
+// entry is an alert being escalated.
+type entry struct {
+	alert  alertmgrtmpl.Alert
+	room   string
+	policy *Policy
+	since  time.Time
+	// next is the index of the next step to run.
+	next int
+}
+
+// Escalator tracks firing alerts and runs the steps of their escalation
+// policy until they're resolved or acknowledged.
+type Escalator struct {
+	sync.Mutex
+	lo       *slog.Logger
+	metrics  *metrics.Manager
+	policies []*Policy
+	push     func(alerts []alertmgrtmpl.Alert, room string) error
+	states   func(room string) []*state.ActiveAlerts
+	client   *http.Client
+	oncall   *oncall.Resolver
+	// entries is a map of room and fingerprint to the alerts being escalated.
+	entries map[string]*entry
+}
+
+// New returns an Escalator.
+func New(opts Opts) *Escalator {
+	e := &Escalator{
+		lo:       opts.Log,
+		metrics:  opts.Metrics,
+		policies: opts.Policies,
+		push:     opts.Push,
+		states:   opts.States,
+		client:   opts.Client,
+		oncall:   opts.OnCall,
+		entries:  make(map[string]*entry),
+	}
+	if e.client == nil {
+		e.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if e.states == nil {
+		e.states = func(string) []*state.ActiveAlerts { return nil }
+	}
+
+	return e
+}
+
+// Track starts escalating the firing alerts sent to the room which match a
+// policy, and cancels the escalation of resolved alerts.
+func (e *Escalator) Track(alerts []alertmgrtmpl.Alert, room string, now time.Time) {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, a := range alerts {
+		key := room + "/" + a.Fingerprint
+
+		if a.Status == "resolved" {
+			if en, ok := e.entries[key]; ok {
+				e.cancel(key, en, "resolved")
+			}
+			continue
+		}
+
+		// Keep the latest labels and annotations for the reposts.
+		if en, ok := e.entries[key]; ok {
+			en.alert = a
+			continue
+		}
+
+		p := e.match(a, room)
+		if p == nil {
+			continue
+		}
+
+		since := a.StartsAt
+		if since.IsZero() || since.After(now) {
+			since = now
+		}
+		e.entries[key] = &entry{alert: a, room: room, policy: p, since: since}
+		e.lo.Debug("tracking alert for escalation", "room", room, "policy", p.name, "fingerprint", a.Fingerprint)
+	}
+}
+
+// match returns the first policy that matches the alert.
+func (e *Escalator) match(a alertmgrtmpl.Alert, room string) *Policy {
+	for _, p := range e.policies {
+		if p.Matches(a, room) {
+			return p
+		}
+	}
+	return nil
+}
+
+// cancel stops escalating the alert. The caller must hold the lock.
+func (e *Escalator) cancel(key string, en *entry, reason string) {
+	delete(e.entries, key)
+	e.metrics.Increment(fmt.Sprintf(`alerts_escalations_cancelled_total{policy="%s", reason="%s"}`, en.policy.name, reason))
+	e.lo.Info("cancelled escalation", "room", en.room, "policy", en.policy.name, "fingerprint", en.alert.Fingerprint, "reason", reason)
+}
+
+// due is a step which is due to run.
+type due struct {
+	alert  alertmgrtmpl.Alert
+	room   string
+	policy *Policy
+	step   int
+	at     time.Time
+}
+
+// Evaluate runs the steps which are due at `now`. Escalations of alerts which
+// were acknowledged or resolved in the meantime are cancelled.
+func (e *Escalator) Evaluate(now time.Time) {
+	var steps []due
+
+	e.Lock()
+	for key, en := range e.entries {
+		if reason := e.settled(en); reason != "" {
+			e.cancel(key, en, reason)
+			continue
+		}
+
+		for en.next < len(en.policy.steps) && now.Sub(en.since) >= en.policy.steps[en.next].After {
+			steps = append(steps, due{alert: en.alert, room: en.room, policy: en.policy, step: en.next, at: now})
+			en.next++
+		}
+		if en.next == len(en.policy.steps) {
+			delete(e.entries, key)
+		}
+	}
+	e.Unlock()
+
+	// Run the steps in order, outside the lock, as they make network requests.
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].step != steps[j].step {
+			return steps[i].step < steps[j].step
+		}
+		return steps[i].alert.Fingerprint < steps[j].alert.Fingerprint
+	})
+	for _, d := range steps {
+		e.run(d)
+	}
+}
+
+// settled returns why the alert no longer needs to be escalated according to
+// the active alerts of its rooms, or an empty string if it still does.
+func (e *Escalator) settled(en *entry) string {
+	states := e.states(en.room)
+	if len(states) == 0 {
+		return ""
+	}
+
+	found := false
+	for _, s := range states {
+		a, ok := s.Get(en.alert.Fingerprint)
+		if !ok {
+			continue
+		}
+		found = true
+		if a.AckedBy != "" {
+			return "acked"
+		}
+		if a.Status == "resolved" {
+			return "resolved"
+		}
+	}
+	// The alert has expired from the active alerts.
+	if !found {
+		return "expired"
+	}
+
+	return ""
+}
+
+// run runs a step of the escalation policy for the alert.
+func (e *Escalator) run(d due) {
+	var (
+		step = d.policy.steps[d.step]
+		num  = strconv.Itoa(d.step + 1)
+		room = d.room
+		err  error
+
+		users   []string
+		mention = step.Mention
+	)
+
+	// Mention the users on call for the alert's team.
+	if step.OnCall != "" {
+		users = e.oncall.OnCall(d.alert.Labels[step.OnCall], d.at)
+		if len(users) == 0 {
+			e.lo.Warn("no one on call for escalation", "policy", d.policy.name, "step", num, "team", d.alert.Labels[step.OnCall])
+		}
+		// The raw user IDs are added, as the mention syntax depends on the provider of the target room.
+		if len(users) > 0 {
+			mention = strings.Trim(mention+","+strings.Join(users, ","), ",")
+		}
+	}
+
+	if step.Webhook != "" {
+		err = e.postWebhook(step.Webhook, d, users)
+	} else {
+		if step.Room != "" {
+			room = step.Room
+		}
+		err = e.push([]alertmgrtmpl.Alert{tag(d.alert, d.policy.name, num, mention, users)}, room)
+	}
+	if err != nil {
+		e.metrics.Increment(fmt.Sprintf(`alerts_escalation_errors_total{policy="%s", step="%s", room="%s"}`, d.policy.name, num, d.room))
+		e.lo.Error("error escalating alert", "room", d.room, "policy", d.policy.name, "step", num, "fingerprint", d.alert.Fingerprint, "error", err)
+		return
+	}
+
+	e.metrics.Increment(fmt.Sprintf(`alerts_escalated_total{policy="%s", step="%s", room="%s"}`, d.policy.name, num, d.room))
+	e.lo.Info("escalated alert", "room", d.room, "target", room, "webhook", step.Webhook != "", "policy", d.policy.name, "step", num, "fingerprint", d.alert.Fingerprint)
+}
+
+// webhookPayload is the body of webhook steps.
+type webhookPayload struct {
+	Policy string             `json:"policy"`
+	Step   int                `json:"step"`
+	Room   string             `json:"room"`
+	OnCall []string           `json:"oncall,omitempty"`
+	Alert  alertmgrtmpl.Alert `json:"alert"`
+}
+
+// postWebhook posts the alert to the webhook URL of a step.
+func (e *Escalator) postWebhook(url string, d due, oncall []string) error {
+	b, err := json.Marshal(webhookPayload{
+		Policy: d.policy.name,
+		Step:   d.step + 1,
+		Room:   d.room,
+		OnCall: oncall,
+		Alert:  d.alert,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// tag returns a copy of the alert marked as an escalation, so that
+// templates can tell them apart from regular alerts.
+func tag(a alertmgrtmpl.Alert, policy, step, mention string, oncall []string) alertmgrtmpl.Alert {
+	annotations := make(alertmgrtmpl.KV, len(a.Annotations)+4)
+	for k, v := range a.Annotations {
+		annotations[k] = v
+	}
+	annotations[PolicyAnnotation] = policy
+	annotations[StepAnnotation] = step
+	if mention != "" {
+		annotations[MentionAnnotation] = mention
+	}
+	if len(oncall) > 0 {
+		annotations[OnCallAnnotation] = strings.Join(oncall, ", ")
+	}
+	a.Annotations = annotations
+
+	return a
+}
+
+// Start periodically runs the steps which are due.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (e *Escalator) Start(interval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(interval).C
+	)
+
+	for range evalTicker {
+		e.Evaluate(time.Now())
+	}
+}
@@ -0,0 +1,258 @@
+package escalation
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/oncall"
+	"github.com/mr-karan/calert/internal/providers/discord"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := map[string]PolicyOpts{
+		"no steps":          {Name: "p"},
+		"no delay":          {Name: "p", Steps: []StepOpts{{Mention: "users/all"}}},
+		"no target":         {Name: "p", Steps: []StepOpts{{After: time.Minute}}},
+		"webhook with room": {Name: "p", Steps: []StepOpts{{After: time.Minute, Webhook: "http://pager", Room: "oncall"}}},
+		"bad matcher":       {Name: "p", Matchers: []string{"severity=~("}, Steps: []StepOpts{{After: time.Minute, Room: "oncall"}}},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPolicy(opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+type pushed struct {
+	alert alertmgrtmpl.Alert
+	room  string
+}
+
+func newTestEscalator(t *testing.T, webhook string, states func(string) []*state.ActiveAlerts) (*Escalator, *[]pushed) {
+	p, err := NewPolicy(PolicyOpts{
+		Name:     "critical",
+		Rooms:    []string{"prod_alerts"},
+		Matchers: []string{`severity="critical"`},
+		Steps: []StepOpts{
+			{After: 30 * time.Minute, Room: "oncall_alerts"},
+			{After: 15 * time.Minute, Mention: "users/all"},
+			{After: time.Hour, Webhook: webhook},
+		},
+	})
+	require.NoError(t, err)
+
+	out := &[]pushed{}
+	e := New(Opts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Policies: []*Policy{p},
+		Push: func(alerts []alertmgrtmpl.Alert, room string) error {
+			for _, a := range alerts {
+				*out = append(*out, pushed{alert: a, room: room})
+			}
+			return nil
+		},
+		States: states,
+	})
+	return e, out
+}
+
+func TestEscalate(t *testing.T) {
+	var payload webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+	}))
+	defer srv.Close()
+
+	var (
+		e, out = newTestEscalator(t, srv.URL, nil)
+		start  = time.Now()
+		alert  = alertmgrtmpl.Alert{
+			Fingerprint: "abc",
+			Status:      "firing",
+			StartsAt:    start,
+			Labels:      alertmgrtmpl.KV{"severity": "critical"},
+		}
+	)
+
+	// Alerts which don't match the policy aren't escalated.
+	e.Track([]alertmgrtmpl.Alert{alert}, "dev_alerts", start)
+	e.Track([]alertmgrtmpl.Alert{{Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "warning"}}}, "prod_alerts", start)
+	e.Track([]alertmgrtmpl.Alert{alert}, "prod_alerts", start)
+
+	e.Evaluate(start.Add(10 * time.Minute))
+	assert.Empty(t, *out)
+
+	// Steps are run in the order of their delays.
+	e.Evaluate(start.Add(15 * time.Minute))
+	require.Len(t, *out, 1)
+	assert.Equal(t, "prod_alerts", (*out)[0].room)
+	assert.Equal(t, "users/all", (*out)[0].alert.Annotations[MentionAnnotation])
+	assert.Equal(t, "1", (*out)[0].alert.Annotations[StepAnnotation])
+	assert.Equal(t, "critical", (*out)[0].alert.Annotations[PolicyAnnotation])
+	// The original alert isn't modified.
+	assert.Empty(t, alert.Annotations)
+
+	e.Evaluate(start.Add(45 * time.Minute))
+	require.Len(t, *out, 2)
+	assert.Equal(t, "oncall_alerts", (*out)[1].room)
+	assert.Equal(t, "2", (*out)[1].alert.Annotations[StepAnnotation])
+
+	e.Evaluate(start.Add(time.Hour))
+	assert.Len(t, *out, 2)
+	assert.Equal(t, "critical", payload.Policy)
+	assert.Equal(t, 3, payload.Step)
+	assert.Equal(t, "abc", payload.Alert.Fingerprint)
+
+	// The escalation is over after the last step.
+	assert.Empty(t, e.entries)
+}
+
+func TestCancel(t *testing.T) {
+	var (
+		lo     = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		active = state.New(state.Opts{Log: lo, Metrics: metrics.New("calert")})
+		e, out = newTestEscalator(t, "http://pager", func(room string) []*state.ActiveAlerts {
+			return []*state.ActiveAlerts{active}
+		})
+		start = time.Now()
+		alert = alertmgrtmpl.Alert{
+			Fingerprint: "abc",
+			Status:      "firing",
+			StartsAt:    start,
+			Labels:      alertmgrtmpl.KV{"severity": "critical"},
+		}
+	)
+
+	t.Run("on resolve", func(t *testing.T) {
+		require.NoError(t, active.Add(alert))
+		e.Track([]alertmgrtmpl.Alert{alert}, "prod_alerts", start)
+
+		resolved := alert
+		resolved.Status = "resolved"
+		e.Track([]alertmgrtmpl.Alert{resolved}, "prod_alerts", start.Add(time.Minute))
+
+		e.Evaluate(start.Add(time.Hour))
+		assert.Empty(t, *out)
+	})
+
+	t.Run("on ack", func(t *testing.T) {
+		e.Track([]alertmgrtmpl.Alert{alert}, "prod_alerts", start)
+		e.Evaluate(start.Add(15 * time.Minute))
+		require.Len(t, *out, 1)
+
+		require.NoError(t, active.Ack("abc", "Jane Doe", start.Add(20*time.Minute)))
+		e.Evaluate(start.Add(time.Hour))
+		assert.Len(t, *out, 1)
+		assert.Empty(t, e.entries)
+	})
+
+	t.Run("on expiry", func(t *testing.T) {
+		e.Track([]alertmgrtmpl.Alert{{Fingerprint: "missing", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "critical"}}}, "prod_alerts", start)
+		e.Evaluate(start.Add(time.Hour))
+		assert.Len(t, *out, 1)
+		assert.Empty(t, e.entries)
+	})
+}
+
+func TestEscalateOnCall(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	file := filepath.Join(t.TempDir(), "db.yml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+rotations:
+  - start: "2026-10-19 09:00"
+    shift: 24h
+    users: [users/123, users/456]
+`), 0o600))
+	resolver, err := oncall.New(oncall.Opts{
+		Log:     lo,
+		Metrics: metrics.New("calert"),
+		Sources: map[string]oncall.SourceOpts{"db": {File: file}},
+	})
+	require.NoError(t, err)
+
+	p, err := NewPolicy(PolicyOpts{
+		Name:  "critical",
+		Steps: []StepOpts{{After: 15 * time.Minute, OnCall: "team"}},
+	})
+	require.NoError(t, err)
+
+	var out []alertmgrtmpl.Alert
+	e := New(Opts{
+		Log:      lo,
+		Metrics:  metrics.New("calert"),
+		Policies: []*Policy{p},
+		Push: func(alerts []alertmgrtmpl.Alert, room string) error {
+			out = append(out, alerts...)
+			return nil
+		},
+		OnCall: resolver,
+	})
+
+	start := time.Date(2026, 10, 20, 10, 0, 0, 0, time.UTC)
+	e.Track([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing", StartsAt: start, Labels: alertmgrtmpl.KV{"team": "db"}}}, "prod_alerts", start)
+	e.Evaluate(start.Add(15 * time.Minute))
+
+	require.Len(t, out, 1)
+	assert.Equal(t, "users/456", out[0].Annotations[MentionAnnotation])
+	assert.Equal(t, "users/456", out[0].Annotations[OnCallAnnotation])
+}
+
+func TestEscalateDiscord(t *testing.T) {
+	var content string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Content string `json:"content"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		content = msg.Content
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	d, err := discord.NewDiscord(discord.DiscordOpts{
+		Log:      lo,
+		Metrics:  metrics.New("calert"),
+		Endpoint: server.URL,
+		Room:     "oncall_alerts",
+		Template: "../../static/discord.tmpl",
+		Timeout:  5 * time.Second,
+	})
+	require.NoError(t, err)
+
+	p, err := NewPolicy(PolicyOpts{
+		Name:  "critical",
+		Steps: []StepOpts{{After: 15 * time.Minute, Room: "oncall_alerts", Mention: "users/123,users/456"}},
+	})
+	require.NoError(t, err)
+
+	e := New(Opts{
+		Log:      lo,
+		Metrics:  metrics.New("calert"),
+		Policies: []*Policy{p},
+		Push: func(alerts []alertmgrtmpl.Alert, room string) error {
+			return d.Push(alerts)
+		},
+	})
+
+	start := time.Date(2026, 10, 20, 10, 0, 0, 0, time.UTC)
+	e.Track([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing", StartsAt: start, Labels: alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"}}}, "prod_alerts", start)
+	e.Evaluate(start.Add(15 * time.Minute))
+
+	// The mentions are rendered in Discord's syntax by the template of the target room.
+	assert.Equal(t, "<@123> <@456> this alert needs attention!\n**(CRITICAL) Diskfull - Firing**", content)
+}
@@ -0,0 +1,301 @@
+// Package history persists the notifications dispatched to rooms,
+// so that they can be looked up later.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/prometheus/alertmanager/pkg/labels"
+)
+
+const (
+	// Results of a dispatched notification.
+	ResultSent   = "sent"
+	ResultFailed = "failed"
+	ResultDryRun = "dry_run"
+	// ResultDeduplicated is a repeat of the last notification which wasn't sent.
+	ResultDeduplicated = "deduplicated"
+
+	// segmentLayout is the name of the daily segment files.
+	segmentLayout = "2006-01-02"
+	segmentExt    = ".jsonl"
+
+	defaultLimit = 100
+)
+
+// Entry is a notification dispatched to a room.
+type Entry struct {
+	Time        time.Time         `json:"time"`
+	Room        string            `json:"room"`
+	Provider    string            `json:"provider"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Text        string            `json:"text,omitempty"`
+	ThreadKey   string            `json:"thread_key,omitempty"`
+	Result      string            `json:"result"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// Query filters the entries returned by Store.Query.
+type Query struct {
+	Room     string
+	Status   string
+	Matchers labels.Matchers
+	// From and To are the (inclusive) time range of entries. Zero values are unbounded.
+	From time.Time
+	To   time.Time
+	// Limit is the maximum number of entries returned. Defaults to 100.
+	Limit int
+}
+
+// Opts represents the options for a Store.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// Dir is the directory in which the entries are stored.
+	Dir string
+	// Retention is how long entries are kept.
+	Retention time.Duration
+}
+
+// Store is an append only store of entries, kept in daily JSON lines files.
+// Files older than the retention are deleted periodically.
+type Store struct {
+	sync.Mutex
+	lo        *slog.Logger
+	metrics   *metrics.Manager
+	dir       string
+	retention time.Duration
+
+	// f is the segment currently being written to.
+	f       *os.File
+	segment string
+}
+
+// New returns a Store which keeps its files in opts.Dir.
+func New(opts Opts) (*Store, error) {
+	if opts.Retention <= 0 {
+		return nil, fmt.Errorf("retention should be greater than 0")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+
+	return &Store{
+		lo:        opts.Log,
+		metrics:   opts.Metrics,
+		dir:       opts.Dir,
+		retention: opts.Retention,
+	}, nil
+}
+
+// Add appends an entry to the store. Errors are logged as history is best effort
+// and shouldn't affect dispatching notifications. It's safe to call on a nil Store.
+func (s *Store) Add(e Entry) {
+	if s == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if err := s.add(e); err != nil {
+		s.metrics.Increment(`history_errors_total`)
+		s.lo.Error("error adding entry to history", "room", e.Room, "fingerprint", e.Fingerprint, "error", err)
+	}
+}
+
+func (s *Store) add(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	segment := e.Time.UTC().Format(segmentLayout)
+	if s.f == nil || s.segment != segment {
+		if s.f != nil {
+			s.f.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(s.dir, segment+segmentExt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			s.f = nil
+			return err
+		}
+		s.f, s.segment = f, segment
+	}
+
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Query returns the entries matching the query, newest first.
+func (s *Store) Query(q Query) ([]Entry, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	// Entries past the retention may not have been pruned yet.
+	if oldest := time.Now().Add(-s.retention); q.From.Before(oldest) {
+		q.From = oldest
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Entry, 0)
+	// Read the newest segments first and stop once there are enough entries.
+	for i := len(segments) - 1; i >= 0 && len(out) < q.Limit; i-- {
+		day := segments[i]
+		if day.Add(24*time.Hour).Before(q.From) || (!q.To.IsZero() && day.After(q.To)) {
+			continue
+		}
+
+		entries, err := s.read(day)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(entries) - 1; j >= 0 && len(out) < q.Limit; j-- {
+			if q.matches(entries[j]) {
+				out = append(out, entries[j])
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (q Query) matches(e Entry) bool {
+	if q.Room != "" && e.Room != q.Room {
+		return false
+	}
+	if q.Status != "" && e.Status != q.Status {
+		return false
+	}
+	if e.Time.Before(q.From) || (!q.To.IsZero() && e.Time.After(q.To)) {
+		return false
+	}
+
+	for _, m := range q.Matchers {
+		if !m.Matches(e.Labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// read returns the entries of a day, oldest first.
+func (s *Store) read(day time.Time) ([]Entry, error) {
+	// Hold the lock so that partially written lines aren't read.
+	s.Lock()
+	defer s.Unlock()
+
+	f, err := os.Open(filepath.Join(s.dir, day.Format(segmentLayout)+segmentExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		out []Entry
+		sc  = bufio.NewScanner(f)
+	)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// Skip lines which are corrupt, eg from a crash while writing.
+			s.lo.Warn("skipping invalid history entry", "segment", f.Name(), "error", err)
+			continue
+		}
+		out = append(out, e)
+	}
+
+	return out, sc.Err()
+}
+
+// segments returns the days of the segment files in the store, oldest first.
+func (s *Store) segments() ([]time.Time, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []time.Time
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), segmentExt)
+		if !ok || f.IsDir() {
+			continue
+		}
+		day, err := time.Parse(segmentLayout, name)
+		if err != nil {
+			continue
+		}
+		out = append(out, day)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+
+	return out, nil
+}
+
+// Prune deletes the segments whose entries are all older than the retention.
+func (s *Store) Prune(now time.Time) error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	oldest := now.Add(-s.retention)
+	for _, day := range segments {
+		if !day.Add(24 * time.Hour).Before(oldest) {
+			break
+		}
+
+		s.Lock()
+		if s.f != nil && s.segment == day.Format(segmentLayout) {
+			s.f.Close()
+			s.f = nil
+		}
+		err := os.Remove(filepath.Join(s.dir, day.Format(segmentLayout)+segmentExt))
+		s.Unlock()
+		if err != nil {
+			return err
+		}
+		s.lo.Debug("pruned history segment", "day", day.Format(segmentLayout))
+	}
+
+	return nil
+}
+
+// StartPruneWorker periodically deletes the segments older than the retention.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (s *Store) StartPruneWorker(interval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(interval).C
+	)
+
+	for range evalTicker {
+		if err := s.Prune(time.Now()); err != nil {
+			s.metrics.Increment(`history_errors_total`)
+			s.lo.Error("error pruning history", "error", err)
+		}
+	}
+}
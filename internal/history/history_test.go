@@ -0,0 +1,110 @@
+package history
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, dir string) *Store {
+	s, err := New(Opts{
+		Log:       slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:   metrics.New("calert"),
+		Dir:       dir,
+		Retention: 7 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func fingerprints(entries []Entry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Fingerprint)
+	}
+	return out
+}
+
+func TestQuery(t *testing.T) {
+	var (
+		s         = newTestStore(t, t.TempDir())
+		now       = time.Now()
+		yesterday = now.Add(-24 * time.Hour)
+	)
+
+	s.Add(Entry{Time: yesterday, Room: "prod", Fingerprint: "a", Status: "firing", Labels: map[string]string{"severity": "critical"}, Result: ResultSent})
+	s.Add(Entry{Time: yesterday.Add(time.Minute), Room: "dev", Fingerprint: "b", Status: "firing", Labels: map[string]string{"severity": "warning"}, Result: ResultSent})
+	s.Add(Entry{Time: now, Room: "prod", Fingerprint: "a", Status: "resolved", Labels: map[string]string{"severity": "critical"}, Result: ResultFailed, Error: "non ok response from gchat"})
+	// Entries past the retention aren't returned, even if they haven't been pruned.
+	s.Add(Entry{Time: now.Add(-8 * 24 * time.Hour), Room: "prod", Fingerprint: "old", Status: "firing"})
+
+	matchers, err := labels.ParseMatchers(`severity="critical"`)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		q    Query
+		want []string
+	}{
+		"all":      {Query{}, []string{"a", "b", "a"}},
+		"room":     {Query{Room: "prod"}, []string{"a", "a"}},
+		"status":   {Query{Status: "firing"}, []string{"b", "a"}},
+		"matchers": {Query{Matchers: matchers}, []string{"a", "a"}},
+		"from":     {Query{From: now.Add(-time.Hour)}, []string{"a"}},
+		"to":       {Query{To: yesterday}, []string{"a"}},
+		"limit":    {Query{Limit: 2}, []string{"a", "b"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := s.Query(tc.q)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, fingerprints(out))
+		})
+	}
+
+	// Entries are persisted across restarts.
+	out, err := newTestStore(t, s.dir).Query(Query{Status: "resolved"})
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	assert.Equal(t, ResultFailed, out[0].Result)
+	assert.Equal(t, "non ok response from gchat", out[0].Error)
+	assert.Equal(t, "critical", out[0].Labels["severity"])
+}
+
+func TestPrune(t *testing.T) {
+	var (
+		s   = newTestStore(t, t.TempDir())
+		now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	)
+
+	s.Add(Entry{Time: now.Add(-9 * 24 * time.Hour), Fingerprint: "a"})
+	s.Add(Entry{Time: now.Add(-7 * 24 * time.Hour), Fingerprint: "b"})
+	s.Add(Entry{Time: now, Fingerprint: "c"})
+
+	require.NoError(t, s.Prune(now))
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.jsonl"))
+	require.NoError(t, err)
+	// The segment which has entries within the retention is kept.
+	assert.Equal(t, []string{
+		filepath.Join(s.dir, "2026-10-12.jsonl"),
+		filepath.Join(s.dir, "2026-10-19.jsonl"),
+	}, files)
+
+	// Entries can be added after the current segment is pruned.
+	require.NoError(t, s.Prune(now.Add(30*24*time.Hour)))
+	s.Add(Entry{Time: now, Fingerprint: "d"})
+	_, err = os.Stat(filepath.Join(s.dir, "2026-10-19.jsonl"))
+	assert.NoError(t, err)
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	assert.NotPanics(t, func() { s.Add(Entry{Fingerprint: "a"}) })
+}
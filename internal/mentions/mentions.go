@@ -0,0 +1,182 @@
+// Package mentions maps label values (like the `team` or `owner` of an alert)
+// to the users to @-mention in messages, in the syntax of each provider.
+package mentions
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/mr-karan/calert/internal/metrics"
+)
+
+// Opts represents the options for a Mapper.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// File is the path to the TOML mapping file.
+	File string
+}
+
+// Mapper resolves label values to mentions. The mapping file can be
+// reloaded without restarting.
+type Mapper struct {
+	sync.RWMutex
+	lo      *slog.Logger
+	metrics *metrics.Manager
+	file    string
+	modTime time.Time
+
+	// users is a map of label values to user IDs.
+	users map[string][]string
+	// severities are the severities for which mentions are allowed. Empty means all.
+	severities map[string]bool
+}
+
+// New loads the mapping file and returns a Mapper.
+func New(opts Opts) (*Mapper, error) {
+	m := &Mapper{
+		lo:      opts.Log,
+		metrics: opts.Metrics,
+		file:    opts.File,
+	}
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Load (re)loads the mapping file. The current mapping is kept if it can't be loaded.
+func (m *Mapper) Load() error {
+	st, err := os.Stat(m.file)
+	if err != nil {
+		return fmt.Errorf("error loading mentions file: %w", err)
+	}
+
+	ko := koanf.New(".")
+	if err := ko.Load(file.Provider(m.file), toml.Parser()); err != nil {
+		return fmt.Errorf("error loading mentions file: %w", err)
+	}
+
+	users := make(map[string][]string)
+	for value, ids := range ko.StringsMap("users") {
+		for _, id := range ids {
+			if id = strings.TrimPrefix(strings.TrimSpace(id), "users/"); id == "" {
+				return fmt.Errorf("empty user ID for %s in mentions file", value)
+			}
+			users[value] = append(users[value], id)
+		}
+	}
+
+	severities := make(map[string]bool)
+	for _, s := range ko.Strings("severities") {
+		severities[s] = true
+	}
+
+	m.Lock()
+	m.users = users
+	m.severities = severities
+	m.modTime = st.ModTime()
+	m.Unlock()
+
+	m.lo.Info("loaded mentions", "file", m.file, "values", len(users))
+	return nil
+}
+
+// Format returns the mention of a user ID in the syntax of a provider.
+type Format func(id string) string
+
+// GoogleChat formats mentions as `<users/123>`. It's the default format.
+func GoogleChat(id string) string {
+	return "<users/" + id + ">"
+}
+
+// Plain formats mentions as `@123`, for providers without a mention syntax.
+func Plain(id string) string {
+	return "@" + id
+}
+
+// Mentions returns the Google Chat mentions (eg `<users/123>`) of the users mapped
+// to the label value, separated by spaces. See MentionsAs.
+func (m *Mapper) Mentions(value string, severity ...string) string {
+	return m.MentionsAs(GoogleChat, value, severity...)
+}
+
+// MentionsAs returns the mentions of the users mapped to the label value in the
+// format, separated by spaces. Multiple values can be separated by commas.
+// Values which are user IDs themselves (eg `users/123`) are mentioned as is.
+// If the mapping has a severity rule, mentions are only returned for those
+// severities, and not at all if the severity isn't passed. It's safe to call on a nil Mapper, which
+// only mentions user IDs. A nil format is GoogleChat.
+func (m *Mapper) MentionsAs(format Format, value string, severity ...string) string {
+	if format == nil {
+		format = GoogleChat
+	}
+
+	var users map[string][]string
+	if m != nil {
+		m.RLock()
+		defer m.RUnlock()
+
+		if len(m.severities) > 0 && (len(severity) == 0 || !m.severities[severity[0]]) {
+			return ""
+		}
+		users = m.users
+	}
+
+	var (
+		out  []string
+		seen = make(map[string]bool)
+	)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		ids, ok := users[v]
+		if !ok && strings.HasPrefix(v, "users/") {
+			ids = []string{strings.TrimPrefix(v, "users/")}
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			out = append(out, format(id))
+		}
+	}
+
+	return strings.Join(out, " ")
+}
+
+// StartReloadWorker periodically reloads the mapping file if it has changed.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (m *Mapper) StartReloadWorker(interval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(interval).C
+	)
+
+	for range evalTicker {
+		st, err := os.Stat(m.file)
+		if err != nil {
+			m.lo.Error("error checking mentions file", "file", m.file, "error", err)
+			continue
+		}
+
+		m.RLock()
+		changed := !st.ModTime().Equal(m.modTime)
+		m.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := m.Load(); err != nil {
+			m.metrics.Increment(`mentions_reload_errors_total`)
+			m.lo.Error("error reloading mentions, keeping the current mapping", "file", m.file, "error", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package mentions
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, body string) {
+	require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+}
+
+func newTestMapper(t *testing.T, body string) (*Mapper, string) {
+	path := filepath.Join(t.TempDir(), "mentions.toml")
+	writeFile(t, path, body)
+
+	m, err := New(Opts{
+		Log:     slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics: metrics.New("calert"),
+		File:    path,
+	})
+	require.NoError(t, err)
+	return m, path
+}
+
+func TestMentions(t *testing.T) {
+	m, _ := newTestMapper(t, `
+[users]
+db = ["users/123", "456"]
+alice = ["123"]
+oncall = ["all"]
+`)
+
+	assert.Equal(t, "<users/123> <users/456>", m.Mentions("db"))
+	assert.Equal(t, "<users/all>", m.Mentions("oncall"))
+	assert.Equal(t, "", m.Mentions("unknown"))
+	assert.Equal(t, "", m.Mentions(""))
+
+	// Users are only mentioned once across multiple values.
+	assert.Equal(t, "<users/123> <users/456>", m.Mentions("alice, db"))
+
+	// User IDs are mentioned as is, even without a mapping.
+	assert.Equal(t, "<users/789> <users/123>", m.Mentions("users/789,alice"))
+	var nilMapper *Mapper
+	assert.Equal(t, "<users/789>", nilMapper.Mentions("users/789,alice"))
+
+	// Mentions are formatted in the syntax of the provider.
+	assert.Equal(t, "@123 @456", m.MentionsAs(Plain, "db"))
+	assert.Equal(t, "<users/all>", m.MentionsAs(nil, "oncall"))
+}
+
+func TestSeverities(t *testing.T) {
+	m, _ := newTestMapper(t, `
+severities = ["critical"]
+
+[users]
+db = ["123"]
+`)
+
+	assert.Equal(t, "<users/123>", m.Mentions("db", "critical"))
+	assert.Equal(t, "", m.Mentions("db", "warning"))
+	// Without a severity, the rule doesn't match, eg `{{ mentions .Labels.team }}`.
+	assert.Equal(t, "", m.Mentions("db"))
+}
+
+func TestReload(t *testing.T) {
+	m, path := newTestMapper(t, `
+[users]
+db = ["123"]
+`)
+	go m.StartReloadWorker(10 * time.Millisecond)
+
+	// Ensure the modification time changes on filesystems with a coarse resolution.
+	writeFile(t, path, `
+[users]
+db = ["789"]
+`)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	assert.Eventually(t, func() bool {
+		return m.Mentions("db") == "<users/789>"
+	}, time.Second, 10*time.Millisecond)
+
+	// An invalid file doesn't replace the current mapping.
+	writeFile(t, path, `[users`)
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "<users/789>", m.Mentions("db"))
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Opts{
+		Log:  slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		File: filepath.Join(t.TempDir(), "missing.toml"),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,367 @@
+// Package mute implements schedule based mute rules for rooms. Mute rules are
+// used for planned maintenance windows and quiet hours, where Alertmanager still
+// sends alerts but certain rooms shouldn't be pinged.
+package mute
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Action represents what happens to an alert which matches an active mute rule.
+type Action string
+
+const (
+	// Suppress drops the alert.
+	Suppress Action = "suppress"
+	// Digest holds back the alert and delivers it once the mute window is over.
+	Digest Action = "digest"
+	// Downgrade delivers the alert with a lower severity.
+	Downgrade Action = "downgrade"
+)
+
+const (
+	timeLayout     = "15:04"
+	dateTimeLayout = "2006-01-02 15:04"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// RuleOpts represents the config for a mute rule.
+type RuleOpts struct {
+	Name string
+	// Rooms the rule applies to. Empty means all rooms.
+	Rooms []string
+	// Matchers is a list of Alertmanager style label matchers, eg `severity="warning"`.
+	// All of them must match for the rule to apply.
+	Matchers []string
+	// Timezone in which the schedule is evaluated. Defaults to UTC.
+	Timezone string
+	// Start and End define a one-off window (eg a maintenance)
+	// in the `2006-01-02 15:04` format.
+	Start string
+	End   string
+	// Weekdays, StartTime and EndTime define a recurring window (eg quiet hours).
+	// Times are in the `15:04` format. If EndTime is before StartTime, the
+	// window spans midnight.
+	Weekdays  []string
+	StartTime string
+	EndTime   string
+	// Action to take on muted alerts.
+	Action Action
+	// Severity is the value of the `severity` label for downgraded alerts.
+	Severity string
+}
+
+// Rule is a parsed mute rule.
+type Rule struct {
+	name     string
+	rooms    map[string]bool
+	matchers labels.Matchers
+	loc      *time.Location
+	action   Action
+	severity string
+
+	// One-off window.
+	start, end time.Time
+
+	// Recurring window, as offsets from the start of the day.
+	days               map[time.Weekday]bool
+	startTime, endTime time.Duration
+	recurring          bool
+}
+
+// NewRule parses and validates a mute rule.
+func NewRule(opts RuleOpts) (*Rule, error) {
+	r := &Rule{
+		name:     opts.Name,
+		rooms:    make(map[string]bool, len(opts.Rooms)),
+		action:   opts.Action,
+		severity: opts.Severity,
+		loc:      time.UTC,
+	}
+
+	for _, room := range opts.Rooms {
+		r.rooms[room] = true
+	}
+
+	switch r.action {
+	case "":
+		r.action = Suppress
+	case Suppress, Digest, Downgrade:
+	default:
+		return nil, fmt.Errorf("unknown action: %s", opts.Action)
+	}
+	if r.severity == "" {
+		r.severity = "info"
+	}
+
+	for _, s := range opts.Matchers {
+		m, err := labels.ParseMatchers(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing matcher %q: %w", s, err)
+		}
+		r.matchers = append(r.matchers, m...)
+	}
+
+	if opts.Timezone != "" {
+		loc, err := time.LoadLocation(opts.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("error loading timezone: %w", err)
+		}
+		r.loc = loc
+	}
+
+	// Parse the one-off window.
+	if opts.Start != "" || opts.End != "" {
+		var err error
+		if r.start, err = time.ParseInLocation(dateTimeLayout, opts.Start, r.loc); err != nil {
+			return nil, fmt.Errorf("error parsing start: %w", err)
+		}
+		if r.end, err = time.ParseInLocation(dateTimeLayout, opts.End, r.loc); err != nil {
+			return nil, fmt.Errorf("error parsing end: %w", err)
+		}
+		if !r.end.After(r.start) {
+			return nil, fmt.Errorf("end must be after start")
+		}
+	}
+
+	// Parse the recurring window.
+	if opts.StartTime != "" || opts.EndTime != "" || len(opts.Weekdays) > 0 {
+		r.recurring = true
+		r.days = make(map[time.Weekday]bool)
+		for _, d := range opts.Weekdays {
+			wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+			if !ok {
+				return nil, fmt.Errorf("unknown weekday: %s", d)
+			}
+			r.days[wd] = true
+		}
+		// No weekdays means every day.
+		if len(r.days) == 0 {
+			for _, wd := range weekdays {
+				r.days[wd] = true
+			}
+		}
+
+		var err error
+		if r.startTime, err = parseTimeOfDay(opts.StartTime, 0); err != nil {
+			return nil, fmt.Errorf("error parsing start_time: %w", err)
+		}
+		if r.endTime, err = parseTimeOfDay(opts.EndTime, 24*time.Hour); err != nil {
+			return nil, fmt.Errorf("error parsing end_time: %w", err)
+		}
+		if r.startTime == r.endTime {
+			return nil, fmt.Errorf("start_time and end_time can't be the same")
+		}
+	}
+
+	if r.start.IsZero() && !r.recurring {
+		return nil, fmt.Errorf("either a start/end window or a recurring window is required")
+	}
+
+	return r, nil
+}
+
+// parseTimeOfDay parses a `15:04` formatted string as an offset from the start of the day.
+func parseTimeOfDay(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	// Allow 24:00 as the end of the day.
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse(timeLayout, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Name returns the name of the rule.
+func (r *Rule) Name() string {
+	return r.name
+}
+
+// Active returns whether the rule's schedule is active at the given time.
+func (r *Rule) Active(now time.Time) bool {
+	now = now.In(r.loc)
+
+	if !r.start.IsZero() && (now.Before(r.start) || !now.Before(r.end)) {
+		return false
+	}
+	if !r.recurring {
+		return true
+	}
+
+	var (
+		midnight = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, r.loc)
+		offset   = now.Sub(midnight)
+	)
+
+	// Regular window within the same day.
+	if r.startTime < r.endTime {
+		return r.days[now.Weekday()] && offset >= r.startTime && offset < r.endTime
+	}
+
+	// The window spans midnight. The weekday refers to the day the window starts on.
+	if offset >= r.startTime {
+		return r.days[now.Weekday()]
+	}
+	if offset < r.endTime {
+		return r.days[midnight.AddDate(0, 0, -1).Weekday()]
+	}
+
+	return false
+}
+
+// Matches returns whether the rule applies to the alert sent to the room.
+func (r *Rule) Matches(a alertmgrtmpl.Alert, room string) bool {
+	if len(r.rooms) > 0 && !r.rooms[room] {
+		return false
+	}
+	for _, m := range r.matchers {
+		if !m.Matches(a.Labels[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Muter applies mute rules to alerts and holds back the alerts which
+// need to be delivered once the mute window is over.
+type Muter struct {
+	sync.Mutex
+	lo      *slog.Logger
+	metrics *metrics.Manager
+	rules   []*Rule
+	// held is a map of room to the list of held back alerts per rule.
+	held map[string]map[*Rule][]alertmgrtmpl.Alert
+}
+
+// New returns a Muter for the list of rules.
+func New(rules []*Rule, lo *slog.Logger, m *metrics.Manager) *Muter {
+	return &Muter{
+		lo:      lo,
+		metrics: m,
+		rules:   rules,
+		held:    make(map[string]map[*Rule][]alertmgrtmpl.Alert),
+	}
+}
+
+// Apply applies the mute rules which are active at `now` to the alerts sent to
+// the room. The returned alerts should be delivered right away. The first matching
+// rule wins.
+func (m *Muter) Apply(alerts []alertmgrtmpl.Alert, room string, now time.Time) []alertmgrtmpl.Alert {
+	out := make([]alertmgrtmpl.Alert, 0, len(alerts))
+
+	for _, a := range alerts {
+		r := m.match(a, room, now)
+		if r == nil {
+			out = append(out, a)
+			continue
+		}
+
+		m.metrics.Increment(fmt.Sprintf(`alerts_muted_total{room="%s", rule="%s", action="%s"}`, room, r.name, r.action))
+		m.lo.Info("muting alert",
+			"room", room,
+			"rule", r.name,
+			"action", r.action,
+			"fingerprint", a.Fingerprint,
+			"status", a.Status,
+			"labels", a.Labels,
+		)
+
+		switch r.action {
+		case Digest:
+			m.hold(a, room, r)
+		case Downgrade:
+			out = append(out, downgrade(a, r.severity))
+		}
+	}
+
+	return out
+}
+
+// Release returns the held back alerts, per room, for which the mute window is over.
+func (m *Muter) Release(now time.Time) map[string][]alertmgrtmpl.Alert {
+	m.Lock()
+	defer m.Unlock()
+
+	out := make(map[string][]alertmgrtmpl.Alert)
+	for room, rules := range m.held {
+		for r, alerts := range rules {
+			if r.Active(now) {
+				continue
+			}
+			out[room] = append(out[room], alerts...)
+			delete(rules, r)
+		}
+		if len(rules) == 0 {
+			delete(m.held, room)
+		}
+	}
+
+	return out
+}
+
+// match returns the first rule that is active and matches the alert.
+func (m *Muter) match(a alertmgrtmpl.Alert, room string, now time.Time) *Rule {
+	for _, r := range m.rules {
+		if r.Matches(a, room) && r.Active(now) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// hold holds back an alert until the rule is no longer active. A newer
+// notification for the same alert replaces the older one.
+func (m *Muter) hold(a alertmgrtmpl.Alert, room string, r *Rule) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.held[room]; !ok {
+		m.held[room] = make(map[*Rule][]alertmgrtmpl.Alert)
+	}
+
+	alerts := m.held[room][r]
+	for i, h := range alerts {
+		if h.Fingerprint == a.Fingerprint {
+			alerts[i] = a
+			return
+		}
+	}
+	m.held[room][r] = append(alerts, a)
+}
+
+// downgrade returns a copy of the alert with the severity label replaced.
+func downgrade(a alertmgrtmpl.Alert, severity string) alertmgrtmpl.Alert {
+	l := make(alertmgrtmpl.KV, len(a.Labels))
+	for k, v := range a.Labels {
+		l[k] = v
+	}
+	l["severity"] = severity
+	a.Labels = l
+
+	return a
+}
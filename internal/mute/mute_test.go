@@ -0,0 +1,199 @@
+package mute
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	ts, err := time.ParseInLocation(dateTimeLayout, s, loc)
+	require.NoError(t, err)
+	return ts
+}
+
+func TestNewRule(t *testing.T) {
+	tests := map[string]RuleOpts{
+		"no window":          {Name: "r"},
+		"unknown action":     {Name: "r", StartTime: "22:00", Action: "ignore"},
+		"bad matcher":        {Name: "r", StartTime: "22:00", Matchers: []string{`severity=~"(`}},
+		"bad timezone":       {Name: "r", StartTime: "22:00", Timezone: "Mars/Olympus"},
+		"end before start":   {Name: "r", Start: "2026-01-02 10:00", End: "2026-01-01 10:00"},
+		"unknown weekday":    {Name: "r", Weekdays: []string{"funday"}},
+		"same start and end": {Name: "r", StartTime: "10:00", EndTime: "10:00"},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewRule(opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRuleActive(t *testing.T) {
+	t.Run("one-off window", func(t *testing.T) {
+		r, err := NewRule(RuleOpts{
+			Name:     "maintenance",
+			Timezone: "Asia/Kolkata",
+			Start:    "2026-10-20 02:00",
+			End:      "2026-10-20 04:00",
+		})
+		require.NoError(t, err)
+
+		assert.False(t, r.Active(mustTime(t, "2026-10-20 01:59")))
+		assert.True(t, r.Active(mustTime(t, "2026-10-20 02:00")))
+		assert.True(t, r.Active(mustTime(t, "2026-10-20 03:59")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-20 04:00")))
+		// The window is evaluated in the rule's timezone.
+		assert.True(t, r.Active(mustTime(t, "2026-10-20 03:00").UTC()))
+	})
+
+	t.Run("recurring window within a day", func(t *testing.T) {
+		r, err := NewRule(RuleOpts{
+			Name:      "lunch",
+			Timezone:  "Asia/Kolkata",
+			Weekdays:  []string{"Mon", "tuesday"},
+			StartTime: "13:00",
+			EndTime:   "14:00",
+		})
+		require.NoError(t, err)
+
+		// 2026-10-19 is a Monday.
+		assert.True(t, r.Active(mustTime(t, "2026-10-19 13:30")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-19 14:00")))
+		assert.True(t, r.Active(mustTime(t, "2026-10-20 13:00")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-21 13:30")))
+	})
+
+	t.Run("recurring window spanning midnight", func(t *testing.T) {
+		r, err := NewRule(RuleOpts{
+			Name:      "quiet_hours",
+			Timezone:  "Asia/Kolkata",
+			Weekdays:  []string{"fri"},
+			StartTime: "22:00",
+			EndTime:   "06:00",
+		})
+		require.NoError(t, err)
+
+		// 2026-10-23 is a Friday.
+		assert.False(t, r.Active(mustTime(t, "2026-10-23 21:59")))
+		assert.True(t, r.Active(mustTime(t, "2026-10-23 23:00")))
+		assert.True(t, r.Active(mustTime(t, "2026-10-24 05:59")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-24 06:00")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-24 23:00")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-23 05:00")))
+	})
+
+	t.Run("recurring window within a one-off window", func(t *testing.T) {
+		r, err := NewRule(RuleOpts{
+			Name:      "freeze",
+			Timezone:  "Asia/Kolkata",
+			Start:     "2026-10-19 00:00",
+			End:       "2026-10-26 00:00",
+			StartTime: "09:00",
+			EndTime:   "18:00",
+		})
+		require.NoError(t, err)
+
+		assert.True(t, r.Active(mustTime(t, "2026-10-20 10:00")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-20 19:00")))
+		assert.False(t, r.Active(mustTime(t, "2026-10-27 10:00")))
+	})
+}
+
+func TestRuleMatches(t *testing.T) {
+	r, err := NewRule(RuleOpts{
+		Name:      "infra",
+		Rooms:     []string{"prod_alerts"},
+		Matchers:  []string{`severity="warning"`, `team=~"infra|db"`},
+		StartTime: "00:00",
+	})
+	require.NoError(t, err)
+
+	a := alertmgrtmpl.Alert{Labels: alertmgrtmpl.KV{"severity": "warning", "team": "db"}}
+	assert.True(t, r.Matches(a, "prod_alerts"))
+	assert.False(t, r.Matches(a, "dev_alerts"))
+
+	a.Labels = alertmgrtmpl.KV{"severity": "critical", "team": "db"}
+	assert.False(t, r.Matches(a, "prod_alerts"))
+
+	a.Labels = alertmgrtmpl.KV{"severity": "warning"}
+	assert.False(t, r.Matches(a, "prod_alerts"))
+}
+
+func TestMuter(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	now := mustTime(t, "2026-10-20 03:00")
+
+	newRule := func(name string, action Action, matcher string) *Rule {
+		r, err := NewRule(RuleOpts{
+			Name:     name,
+			Timezone: "Asia/Kolkata",
+			Start:    "2026-10-20 02:00",
+			End:      "2026-10-20 04:00",
+			Matchers: []string{matcher},
+			Action:   action,
+		})
+		require.NoError(t, err)
+		return r
+	}
+
+	t.Run("applies actions", func(t *testing.T) {
+		m := metrics.New("calert")
+		muter := New([]*Rule{
+			newRule("drop", Suppress, `severity="info"`),
+			newRule("later", Digest, `severity="warning"`),
+			newRule("lower", Downgrade, `severity="critical"`),
+		}, lo, m)
+
+		alerts := []alertmgrtmpl.Alert{
+			{Fingerprint: "info", Labels: alertmgrtmpl.KV{"severity": "info"}},
+			{Fingerprint: "warning", Labels: alertmgrtmpl.KV{"severity": "warning"}},
+			{Fingerprint: "critical", Labels: alertmgrtmpl.KV{"severity": "critical"}},
+			{Fingerprint: "other", Labels: alertmgrtmpl.KV{"severity": "page"}},
+		}
+
+		out := muter.Apply(alerts, "prod_alerts", now)
+		require.Len(t, out, 2)
+		assert.Equal(t, "critical", out[0].Fingerprint)
+		assert.Equal(t, "info", out[0].Labels["severity"])
+		assert.Equal(t, "critical", alerts[2].Labels["severity"], "original alert must not be modified")
+		assert.Equal(t, "other", out[1].Fingerprint)
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_alerts_muted_total{room="prod_alerts", rule="drop", action="suppress"} 1`)
+		assert.Contains(t, buf.String(), `calert_alerts_muted_total{room="prod_alerts", rule="later", action="digest"} 1`)
+		assert.Contains(t, buf.String(), `calert_alerts_muted_total{room="prod_alerts", rule="lower", action="downgrade"} 1`)
+	})
+
+	t.Run("releases held alerts after the window", func(t *testing.T) {
+		muter := New([]*Rule{newRule("later", Digest, `severity="warning"`)}, lo, metrics.New("calert"))
+
+		muter.Apply([]alertmgrtmpl.Alert{
+			{Fingerprint: "a1", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "warning"}},
+			{Fingerprint: "a2", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "warning"}},
+		}, "prod_alerts", now)
+		muter.Apply([]alertmgrtmpl.Alert{
+			{Fingerprint: "a1", Status: "resolved", Labels: alertmgrtmpl.KV{"severity": "warning"}},
+		}, "prod_alerts", now)
+
+		assert.Empty(t, muter.Release(now))
+
+		released := muter.Release(mustTime(t, "2026-10-20 04:00"))
+		require.Len(t, released["prod_alerts"], 2)
+		assert.Equal(t, "a1", released["prod_alerts"][0].Fingerprint)
+		assert.Equal(t, "resolved", released["prod_alerts"][0].Status)
+
+		assert.Empty(t, muter.Release(mustTime(t, "2026-10-20 04:00")))
+	})
+}
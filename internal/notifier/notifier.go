@@ -1,24 +1,62 @@
 package notifier
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mr-karan/calert/internal/digest"
+	"github.com/mr-karan/calert/internal/escalation"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/mute"
+	"github.com/mr-karan/calert/internal/oncall"
 	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 )
 
+// FallbackAnnotation is the annotation added to alerts which are resent to a
+// fallback room. The value is the name of the room the alerts were meant for.
+const FallbackAnnotation = "calert_fallback_from"
+
 // Notifier represents an instance that pushes out notifications to
 // upstream providers.
 type Notifier struct {
 	providers map[string]providers.Provider
+	groups    map[string][]string
+	fallbacks map[string]string
+	muter     *mute.Muter
+	digests   *digest.Scheduler
+	escalator *escalation.Escalator
 	lo        *slog.Logger
+	metrics   *metrics.Manager
 }
 
 type Opts struct {
 	Providers []providers.Provider
-	Log       *slog.Logger
+	// Fallbacks is a map of room names to the room where alerts are
+	// resent when they can't be delivered to the primary room.
+	Fallbacks map[string]string
+	// Groups is a map of logical room names to the list of rooms
+	// the alerts are fanned out to.
+	Groups map[string][]string
+	// Muter applies the mute rules on the alerts before they're dispatched.
+	Muter *mute.Muter
+	// Digests is a map of room names to their digest schedule. Alerts for these
+	// rooms are buffered and sent as a digest instead of being pushed right away.
+	Digests map[string]*digest.Schedule
+	// DigestStateFile is where the buffered digest alerts are persisted.
+	DigestStateFile string
+	// Escalations are the escalation policies for alerts which stay unresolved.
+	Escalations []*escalation.Policy
+	// OnCall is used by escalation steps which mention the users on call.
+	OnCall  *oncall.Resolver
+	Log     *slog.Logger
+	Metrics *metrics.Manager
 }
 
 // Init initialises a new instance of the Notifier.
@@ -28,24 +66,200 @@ func Init(opts Opts) (Notifier, error) {
 
 	for _, prov := range opts.Providers {
 		room := prov.Room()
+		if _, ok := m[room]; ok {
+			return Notifier{}, fmt.Errorf("duplicate room: %s", room)
+		}
 		m[room] = prov
 	}
 
-	return Notifier{
+	// Ensure room groups don't shadow a room and only fan out to configured rooms.
+	groups := make(map[string][]string, len(opts.Groups))
+	for group, targets := range opts.Groups {
+		if _, ok := m[group]; ok {
+			return Notifier{}, fmt.Errorf("duplicate room: %s is configured both as a room and a room group", group)
+		}
+		if len(targets) == 0 {
+			return Notifier{}, fmt.Errorf("room group %s has no targets", group)
+		}
+
+		seen := make(map[string]bool, len(targets))
+		for _, t := range targets {
+			if _, ok := m[t]; !ok {
+				return Notifier{}, fmt.Errorf("target room %s for room group %s is not configured", t, group)
+			}
+			if seen[t] {
+				return Notifier{}, fmt.Errorf("duplicate target room %s in room group %s", t, group)
+			}
+			seen[t] = true
+		}
+		groups[group] = targets
+	}
+
+	// Ensure all the fallback rooms are actually configured.
+	fallbacks := make(map[string]string, len(opts.Fallbacks))
+	for room, fallback := range opts.Fallbacks {
+		if fallback == "" {
+			continue
+		}
+		if fallback == room {
+			return Notifier{}, fmt.Errorf("fallback room for %s can't be the room itself", room)
+		}
+		if _, ok := m[fallback]; !ok {
+			return Notifier{}, fmt.Errorf("fallback room %s for %s is not configured", fallback, room)
+		}
+		fallbacks[room] = fallback
+	}
+
+	// Ensure all the digest rooms can send digests.
+	for room := range opts.Digests {
+		prov, ok := m[room]
+		if !ok {
+			return Notifier{}, fmt.Errorf("digest room %s is not configured", room)
+		}
+		if _, ok := prov.(providers.DigestPusher); !ok {
+			return Notifier{}, fmt.Errorf("provider %s for digest room %s doesn't support digests", prov.ID(), room)
+		}
+	}
+
+	// Ensure escalation steps only repost to configured rooms.
+	for _, p := range opts.Escalations {
+		for _, room := range p.StepRooms() {
+			_, isRoom := m[room]
+			_, isGroup := groups[room]
+			if !isRoom && !isGroup {
+				return Notifier{}, fmt.Errorf("escalation room %s for policy %s is not configured", room, p.Name())
+			}
+		}
+	}
+
+	n := Notifier{
 		lo:        opts.Log,
+		metrics:   opts.Metrics,
 		providers: m,
-	}, nil
+		groups:    groups,
+		fallbacks: fallbacks,
+		muter:     opts.Muter,
+	}
+
+	if len(opts.Digests) > 0 {
+		d, err := digest.New(digest.Opts{
+			Log:       opts.Log,
+			Metrics:   opts.Metrics,
+			Schedules: opts.Digests,
+			StateFile: opts.DigestStateFile,
+			Flush: func(d providers.Digest) error {
+				return m[d.Room].(providers.DigestPusher).PushDigest(d)
+			},
+		})
+		if err != nil {
+			return Notifier{}, err
+		}
+		n.digests = d
+		n.digests.Start()
+	}
+
+	if len(opts.Escalations) > 0 {
+		n.escalator = escalation.New(escalation.Opts{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			Policies: opts.Escalations,
+			Push: func(alerts []alertmgrtmpl.Alert, room string) error {
+				return n.route(alerts, room)
+			},
+			States: n.states,
+			OnCall: opts.OnCall,
+		})
+		go n.escalator.Start(time.Minute)
+	}
+
+	// Start a background worker to deliver the alerts held back by mute rules.
+	if n.muter != nil {
+		go n.startReleaseWorker(time.Minute)
+	}
+
+	return n, nil
 }
 
-// Dispatch pushes out a notification to an upstream provider.
+// Dispatch pushes out a notification to an upstream provider. If the room is a
+// room group, the alerts are pushed to all the target rooms in parallel.
 func (n *Notifier) Dispatch(alerts []alertmgrtmpl.Alert, room string) error {
 	n.lo.Info("dispatching alerts", "count", len(alerts))
 
+	if n.muter != nil {
+		alerts = n.muter.Apply(alerts, room, time.Now())
+		if len(alerts) == 0 {
+			return nil
+		}
+	}
+
+	if n.escalator != nil {
+		n.escalator.Track(alerts, room, time.Now())
+	}
+
+	return n.route(alerts, room)
+}
+
+// ActiveAlerts returns the active alerts tracked for the room, if its provider tracks them.
+func (n *Notifier) ActiveAlerts(room string) (*state.ActiveAlerts, bool) {
+	prov, ok := n.providers[room]
+	if !ok {
+		return nil, false
+	}
+	s, ok := prov.(providers.Stateful)
+	if !ok {
+		return nil, false
+	}
+	return s.ActiveAlerts(), true
+}
+
+// states returns the active alerts of the room, or of the
+// target rooms if it's a room group.
+func (n *Notifier) states(room string) []*state.ActiveAlerts {
+	rooms := []string{room}
+	if targets, ok := n.groups[room]; ok {
+		rooms = targets
+	}
+
+	out := make([]*state.ActiveAlerts, 0, len(rooms))
+	for _, r := range rooms {
+		if s, ok := n.ActiveAlerts(r); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Rooms returns the names of all the configured rooms, sorted.
+func (n *Notifier) Rooms() []string {
+	out := make([]string, 0, len(n.providers))
+	for room := range n.providers {
+		out = append(out, room)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// route pushes the alerts to the provider of the room or to all the target
+// rooms if it's a room group. Alerts for digest rooms are buffered instead.
+func (n *Notifier) route(alerts []alertmgrtmpl.Alert, room string) error {
+	// Alerts for digest rooms are sent on schedule.
+	if n.digests != nil && n.digests.Has(room) {
+		n.lo.Debug("buffering alerts for digest", "room", room, "count", len(alerts))
+		return n.digests.Add(alerts, room)
+	}
+
+	if targets, ok := n.groups[room]; ok {
+		return n.fanOut(alerts, room, targets)
+	}
+
 	if _, ok := n.providers[room]; !ok {
-		availableRooms := make([]string, 0, len(n.providers))
+		availableRooms := make([]string, 0, len(n.providers)+len(n.groups))
 		for r := range n.providers {
 			availableRooms = append(availableRooms, r)
 		}
+		for r := range n.groups {
+			availableRooms = append(availableRooms, r)
+		}
 
 		n.lo.Error("no provider available for room",
 			"room", room,
@@ -60,6 +274,138 @@ func (n *Notifier) Dispatch(alerts []alertmgrtmpl.Alert, room string) error {
 		return fmt.Errorf("no provider configured for room: %s, available: %v%s", room, availableRooms, hint)
 	}
 
-	n.providers[room].Push(alerts)
+	return n.push(alerts, room)
+}
+
+// fanOut routes the alerts to all the target rooms of a room group in parallel
+// and aggregates the results per target. Alerts for target rooms with a digest
+// are buffered like for the room itself.
+func (n *Notifier) fanOut(alerts []alertmgrtmpl.Alert, group string, targets []string) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(targets))
+	)
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			errs[i] = n.route(alerts, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	failed := make([]error, 0)
+	for i, target := range targets {
+		if errs[i] != nil {
+			n.metrics.Increment(fmt.Sprintf(`room_group_dispatch_total{group="%s", target="%s", result="error"}`, group, target))
+			n.lo.Error("error dispatching alerts to room group target", "group", group, "target", target, "error", errs[i])
+			failed = append(failed, fmt.Errorf("%s: %w", target, errs[i]))
+			continue
+		}
+		n.metrics.Increment(fmt.Sprintf(`room_group_dispatch_total{group="%s", target="%s", result="success"}`, group, target))
+		n.lo.Debug("dispatched alerts to room group target", "group", group, "target", target)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("error dispatching to %d/%d targets of room group %s: %w", len(failed), len(targets), group, errors.Join(failed...))
+	}
+
 	return nil
 }
+
+// push pushes the alerts to the provider of a room, rerouting any undelivered alerts.
+func (n *Notifier) push(alerts []alertmgrtmpl.Alert, room string) error {
+	err := n.providers[room].Push(alerts)
+	if err == nil {
+		return nil
+	}
+
+	// Alerts which couldn't be delivered are rerouted to the
+	// fallback room or the dead-letter path.
+	var pErr *providers.PushError
+	if errors.As(err, &pErr) {
+		n.lo.Error("error pushing alerts", "room", room, "count", len(pErr.Alerts), "error", pErr.Err)
+		n.reroute(pErr.Alerts, room)
+		return nil
+	}
+
+	return err
+}
+
+// reroute sends undelivered alerts to the fallback room of `room`. If there's no
+// fallback room or it fails as well, the alerts are dead-lettered.
+func (n *Notifier) reroute(alerts []alertmgrtmpl.Alert, room string) {
+	fallback, ok := n.fallbacks[room]
+	if !ok {
+		n.deadLetter(alerts, room)
+		return
+	}
+
+	n.lo.Warn("rerouting alerts to fallback room", "room", room, "fallback_room", fallback, "count", len(alerts))
+	n.metrics.Increment(fmt.Sprintf(`alerts_fallback_total{room="%s", fallback_room="%s"}`, room, fallback))
+
+	err := n.providers[fallback].Push(tagFallback(alerts, room))
+	if err == nil {
+		return
+	}
+
+	n.lo.Error("error pushing alerts to fallback room", "room", room, "fallback_room", fallback, "error", err)
+	var pErr *providers.PushError
+	if errors.As(err, &pErr) {
+		n.deadLetter(pErr.Alerts, room)
+		return
+	}
+	n.deadLetter(alerts, room)
+}
+
+// tagFallback returns a copy of the alerts marked as a fallback delivery
+// from `room`, so that templates can tell them apart from regular alerts.
+func tagFallback(alerts []alertmgrtmpl.Alert, room string) []alertmgrtmpl.Alert {
+	out := make([]alertmgrtmpl.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		annotations := make(alertmgrtmpl.KV, len(a.Annotations)+1)
+		for k, v := range a.Annotations {
+			annotations[k] = v
+		}
+		annotations[FallbackAnnotation] = room
+		a.Annotations = annotations
+		out = append(out, a)
+	}
+
+	return out
+}
+
+// startReleaseWorker periodically delivers the alerts held back by
+// mute rules whose window is over.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (n *Notifier) startReleaseWorker(interval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(interval).C
+	)
+
+	for range evalTicker {
+		for room, alerts := range n.muter.Release(time.Now()) {
+			n.lo.Info("delivering alerts held back by mute rules", "room", room, "count", len(alerts))
+			if err := n.route(alerts, room); err != nil {
+				n.lo.Error("error delivering held back alerts", "room", room, "error", err)
+			}
+		}
+	}
+}
+
+// deadLetter is the last resort for alerts that couldn't be delivered anywhere.
+// The entire alert is logged so that it can be recovered from the logs.
+func (n *Notifier) deadLetter(alerts []alertmgrtmpl.Alert, room string) {
+	for _, a := range alerts {
+		n.metrics.Increment(fmt.Sprintf(`alerts_dead_letter_total{room="%s"}`, room))
+		n.lo.Error("dead-lettering undelivered alert",
+			"room", room,
+			"fingerprint", a.Fingerprint,
+			"status", a.Status,
+			"starts_at", a.StartsAt,
+			"labels", a.Labels,
+			"annotations", a.Annotations,
+		)
+	}
+}
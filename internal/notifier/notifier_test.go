@@ -1,10 +1,18 @@
 package notifier
 
 import (
+	"bytes"
+	"errors"
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/mr-karan/calert/internal/breaker"
+	"github.com/mr-karan/calert/internal/digest"
+	"github.com/mr-karan/calert/internal/escalation"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/mute"
 	"github.com/mr-karan/calert/internal/providers"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 	"github.com/stretchr/testify/assert"
@@ -32,6 +40,17 @@ func (m *mockProvider) Push(alerts []alertmgrtmpl.Alert) error {
 	return m.pushErr
 }
 
+// mockDigestProvider is a mockProvider which supports digests.
+type mockDigestProvider struct {
+	mockProvider
+	digests []providers.Digest
+}
+
+func (m *mockDigestProvider) PushDigest(d providers.Digest) error {
+	m.digests = append(m.digests, d)
+	return nil
+}
+
 func TestInit(t *testing.T) {
 	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
@@ -58,19 +77,16 @@ func TestInit(t *testing.T) {
 		assert.Len(t, notif.providers, 0)
 	})
 
-	t.Run("overwrites duplicate rooms", func(t *testing.T) {
+	t.Run("rejects duplicate rooms", func(t *testing.T) {
 		prov1 := &mockProvider{id: "provider1", room: "same-room"}
 		prov2 := &mockProvider{id: "provider2", room: "same-room"}
 
-		notif, err := Init(Opts{
+		_, err := Init(Opts{
 			Providers: []providers.Provider{prov1, prov2},
 			Log:       lo,
 		})
 
-		require.NoError(t, err)
-		// Last provider wins
-		assert.Len(t, notif.providers, 1)
-		assert.Equal(t, "provider2", notif.providers["same-room"].ID())
+		assert.ErrorContains(t, err, "duplicate room: same-room")
 	})
 }
 
@@ -152,3 +168,344 @@ func TestDispatch(t *testing.T) {
 		assert.Equal(t, "alert-room2", prov2.pushed[0].Fingerprint)
 	})
 }
+
+func TestFallback(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	alerts := []alertmgrtmpl.Alert{{Fingerprint: "alert1"}}
+
+	t.Run("rejects unknown fallback room", func(t *testing.T) {
+		_, err := Init(Opts{
+			Providers: []providers.Provider{&mockProvider{id: "google_chat", room: "primary"}},
+			Fallbacks: map[string]string{"primary": "missing"},
+			Log:       lo,
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects fallback to itself", func(t *testing.T) {
+		_, err := Init(Opts{
+			Providers: []providers.Provider{&mockProvider{id: "google_chat", room: "primary"}},
+			Fallbacks: map[string]string{"primary": "primary"},
+			Log:       lo,
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("reroutes alerts when circuit is open", func(t *testing.T) {
+		primary := &mockProvider{id: "google_chat", room: "primary",
+			pushErr: &providers.PushError{Alerts: alerts, Err: breaker.ErrOpen}}
+		secondary := &mockProvider{id: "google_chat", room: "secondary"}
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{primary, secondary},
+			Fallbacks: map[string]string{"primary": "secondary"},
+			Log:       lo,
+			Metrics:   metrics.New("calert"),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, notif.Dispatch(alerts, "primary"))
+		require.Len(t, secondary.pushed, 1)
+		assert.Equal(t, "alert1", secondary.pushed[0].Fingerprint)
+		assert.Equal(t, "primary", secondary.pushed[0].Annotations[FallbackAnnotation])
+		// The original alerts must not be modified.
+		assert.Empty(t, alerts[0].Annotations)
+	})
+
+	t.Run("reroutes alerts which failed after retries", func(t *testing.T) {
+		primary := &mockProvider{id: "google_chat", room: "primary",
+			pushErr: &providers.PushError{Alerts: alerts, Err: errors.New("non ok response from gchat")}}
+		secondary := &mockProvider{id: "webhook", room: "secondary"}
+		m := metrics.New("calert")
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{primary, secondary},
+			Fallbacks: map[string]string{"primary": "secondary"},
+			Log:       lo,
+			Metrics:   m,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, notif.Dispatch(alerts, "primary"))
+		require.Len(t, secondary.pushed, 1)
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_alerts_fallback_total{room="primary", fallback_room="secondary"} 1`)
+	})
+
+	t.Run("dead-letters alerts when fallback fails too", func(t *testing.T) {
+		primary := &mockProvider{id: "google_chat", room: "primary",
+			pushErr: &providers.PushError{Alerts: alerts, Err: breaker.ErrOpen}}
+		secondary := &mockProvider{id: "google_chat", room: "secondary",
+			pushErr: &providers.PushError{Alerts: alerts, Err: breaker.ErrOpen}}
+		m := metrics.New("calert")
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{primary, secondary},
+			Fallbacks: map[string]string{"primary": "secondary"},
+			Log:       lo,
+			Metrics:   m,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, notif.Dispatch(alerts, "primary"))
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_alerts_dead_letter_total{room="primary"} 1`)
+	})
+
+	t.Run("dead-letters alerts without fallback", func(t *testing.T) {
+		primary := &mockProvider{id: "google_chat", room: "primary",
+			pushErr: &providers.PushError{Alerts: alerts, Err: breaker.ErrOpen}}
+		m := metrics.New("calert")
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{primary},
+			Log:       lo,
+			Metrics:   m,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, notif.Dispatch(alerts, "primary"))
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_alerts_dead_letter_total{room="primary"} 1`)
+	})
+
+	t.Run("returns other push errors", func(t *testing.T) {
+		primary := &mockProvider{id: "google_chat", room: "primary", pushErr: errors.New("boom")}
+		secondary := &mockProvider{id: "google_chat", room: "secondary"}
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{primary, secondary},
+			Fallbacks: map[string]string{"primary": "secondary"},
+			Log:       lo,
+		})
+		require.NoError(t, err)
+
+		assert.Error(t, notif.Dispatch(alerts, "primary"))
+		assert.Empty(t, secondary.pushed)
+	})
+}
+
+func TestRoomGroups(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	alerts := []alertmgrtmpl.Alert{{Fingerprint: "alert1"}}
+
+	t.Run("rejects invalid groups", func(t *testing.T) {
+		provs := []providers.Provider{
+			&mockProvider{id: "google_chat", room: "room1"},
+			&mockProvider{id: "google_chat", room: "room2"},
+		}
+
+		tests := map[string]map[string][]string{
+			"group shadows a room": {"room1": {"room2"}},
+			"unknown target":       {"group": {"room1", "room3"}},
+			"duplicate target":     {"group": {"room1", "room1"}},
+			"no targets":           {"group": {}},
+		}
+		for name, groups := range tests {
+			t.Run(name, func(t *testing.T) {
+				_, err := Init(Opts{Providers: provs, Groups: groups, Log: lo})
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("fans out to all targets", func(t *testing.T) {
+		prov1 := &mockProvider{id: "google_chat", room: "room1"}
+		prov2 := &mockProvider{id: "webhook", room: "room2"}
+		prov3 := &mockProvider{id: "google_chat", room: "room3"}
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{prov1, prov2, prov3},
+			Groups:    map[string][]string{"critical": {"room1", "room2"}},
+			Log:       lo,
+			Metrics:   metrics.New("calert"),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, notif.Dispatch(alerts, "critical"))
+		assert.Len(t, prov1.pushed, 1)
+		assert.Len(t, prov2.pushed, 1)
+		assert.Empty(t, prov3.pushed)
+	})
+
+	t.Run("aggregates errors per target", func(t *testing.T) {
+		prov1 := &mockProvider{id: "google_chat", room: "room1"}
+		prov2 := &mockProvider{id: "webhook", room: "room2", pushErr: errors.New("boom")}
+		m := metrics.New("calert")
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{prov1, prov2},
+			Groups:    map[string][]string{"critical": {"room1", "room2"}},
+			Log:       lo,
+			Metrics:   m,
+		})
+		require.NoError(t, err)
+
+		err = notif.Dispatch(alerts, "critical")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1/2 targets")
+		assert.Contains(t, err.Error(), "room2: boom")
+		assert.Len(t, prov1.pushed, 1)
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_room_group_dispatch_total{group="critical", target="room1", result="success"} 1`)
+		assert.Contains(t, buf.String(), `calert_room_group_dispatch_total{group="critical", target="room2", result="error"} 1`)
+	})
+}
+
+func TestMute(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	rule, err := mute.NewRule(mute.RuleOpts{
+		Name:      "always",
+		Matchers:  []string{`severity="info"`},
+		StartTime: "00:00",
+		EndTime:   "24:00",
+	})
+	require.NoError(t, err)
+
+	prov := &mockProvider{id: "google_chat", room: "test-room"}
+	m := metrics.New("calert")
+	notif, err := Init(Opts{
+		Providers: []providers.Provider{prov},
+		Muter:     mute.New([]*mute.Rule{rule}, lo, m),
+		Log:       lo,
+		Metrics:   m,
+	})
+	require.NoError(t, err)
+
+	t.Run("suppresses muted alerts", func(t *testing.T) {
+		err := notif.Dispatch([]alertmgrtmpl.Alert{
+			{Fingerprint: "muted", Labels: alertmgrtmpl.KV{"severity": "info"}},
+			{Fingerprint: "loud", Labels: alertmgrtmpl.KV{"severity": "critical"}},
+		}, "test-room")
+		require.NoError(t, err)
+
+		require.Len(t, prov.pushed, 1)
+		assert.Equal(t, "loud", prov.pushed[0].Fingerprint)
+	})
+
+	t.Run("skips push if all alerts are muted", func(t *testing.T) {
+		prov.pushed = nil
+		err := notif.Dispatch([]alertmgrtmpl.Alert{
+			{Fingerprint: "muted", Labels: alertmgrtmpl.KV{"severity": "info"}},
+		}, "test-room")
+		require.NoError(t, err)
+		assert.Nil(t, prov.pushed)
+	})
+}
+
+func TestDigests(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	sched, err := digest.NewSchedule(digest.ScheduleOpts{Interval: time.Hour})
+	require.NoError(t, err)
+
+	t.Run("rejects providers without digest support", func(t *testing.T) {
+		_, err := Init(Opts{
+			Providers: []providers.Provider{&mockProvider{id: "google_chat", room: "info"}},
+			Digests:   map[string]*digest.Schedule{"info": sched},
+			Log:       lo,
+		})
+		assert.ErrorContains(t, err, "doesn't support digests")
+	})
+
+	t.Run("buffers alerts for digest rooms", func(t *testing.T) {
+		prov := &mockDigestProvider{mockProvider: mockProvider{id: "google_chat", room: "info"}}
+		other := &mockProvider{id: "google_chat", room: "prod"}
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{prov, other},
+			Digests:   map[string]*digest.Schedule{"info": sched},
+			Log:       lo,
+			Metrics:   metrics.New("calert"),
+		})
+		require.NoError(t, err)
+
+		alerts := []alertmgrtmpl.Alert{{Fingerprint: "alert1", Status: "firing"}}
+		require.NoError(t, notif.Dispatch(alerts, "info"))
+		require.NoError(t, notif.Dispatch(alerts, "prod"))
+
+		assert.Nil(t, prov.pushed)
+		assert.Len(t, other.pushed, 1)
+
+		require.NoError(t, notif.digests.Flush("info", time.Now()))
+		require.Len(t, prov.digests, 1)
+		assert.Equal(t, "alert1", prov.digests[0].Alerts[0].Fingerprint)
+	})
+
+	t.Run("buffers alerts for digest rooms in room groups", func(t *testing.T) {
+		prov := &mockDigestProvider{mockProvider: mockProvider{id: "google_chat", room: "info"}}
+		other := &mockProvider{id: "google_chat", room: "prod"}
+
+		notif, err := Init(Opts{
+			Providers: []providers.Provider{prov, other},
+			Groups:    map[string][]string{"all": {"info", "prod"}},
+			Digests:   map[string]*digest.Schedule{"info": sched},
+			Log:       lo,
+			Metrics:   metrics.New("calert"),
+		})
+		require.NoError(t, err)
+
+		alerts := []alertmgrtmpl.Alert{{Fingerprint: "alert1", Status: "firing"}}
+		require.NoError(t, notif.Dispatch(alerts, "all"))
+
+		assert.Nil(t, prov.pushed)
+		assert.Len(t, other.pushed, 1)
+
+		require.NoError(t, notif.digests.Flush("info", time.Now()))
+		require.Len(t, prov.digests, 1)
+		assert.Equal(t, "alert1", prov.digests[0].Alerts[0].Fingerprint)
+	})
+}
+
+func TestEscalations(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	policy, err := escalation.NewPolicy(escalation.PolicyOpts{
+		Name:     "critical",
+		Matchers: []string{`severity="critical"`},
+		Steps:    []escalation.StepOpts{{After: time.Minute, Room: "oncall"}},
+	})
+	require.NoError(t, err)
+
+	t.Run("rejects unknown escalation rooms", func(t *testing.T) {
+		_, err := Init(Opts{
+			Providers:   []providers.Provider{&mockProvider{id: "google_chat", room: "prod"}},
+			Escalations: []*escalation.Policy{policy},
+			Log:         lo,
+		})
+		assert.ErrorContains(t, err, "escalation room oncall for policy critical is not configured")
+	})
+
+	t.Run("reposts unresolved alerts", func(t *testing.T) {
+		prod := &mockProvider{id: "google_chat", room: "prod"}
+		oncall := &mockProvider{id: "google_chat", room: "oncall"}
+
+		notif, err := Init(Opts{
+			Providers:   []providers.Provider{prod, oncall},
+			Escalations: []*escalation.Policy{policy},
+			Log:         lo,
+			Metrics:     metrics.New("calert"),
+		})
+		require.NoError(t, err)
+
+		start := time.Now()
+		require.NoError(t, notif.Dispatch([]alertmgrtmpl.Alert{
+			{Fingerprint: "alert1", Status: "firing", StartsAt: start, Labels: alertmgrtmpl.KV{"severity": "critical"}},
+		}, "prod"))
+		require.Len(t, prod.pushed, 1)
+
+		notif.escalator.Evaluate(start.Add(time.Minute))
+		require.Len(t, oncall.pushed, 1)
+		assert.Equal(t, "alert1", oncall.pushed[0].Fingerprint)
+		assert.Equal(t, "critical", oncall.pushed[0].Annotations[escalation.PolicyAnnotation])
+	})
+}
@@ -0,0 +1,358 @@
+package oncall
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// event is an on-call shift in an iCal calendar. The SUMMARY of the event is the user on call.
+type event struct {
+	uid   string
+	user  string
+	start time.Time
+	dur   time.Duration
+
+	// Recurrence, only daily and weekly rules are supported.
+	every int // days between occurrences, 0 if the event doesn't repeat.
+	count int
+	until time.Time
+	// exclude are the start times of occurrences which are
+	// excluded (EXDATE) or overridden (RECURRENCE-ID).
+	exclude map[int64]bool
+	// byDay is the weekday of weekly events, which must match the start.
+	byDay string
+
+	recurrenceID time.Time
+}
+
+// icalSchedule is a schedule of on-call shifts from an iCal calendar.
+type icalSchedule struct {
+	events []*event
+}
+
+// ParseICal parses an iCal calendar where each event is a shift of the user
+// in its SUMMARY. When shifts overlap, the one which started last wins, so
+// overrides can be added as separate events on top of the regular rotation.
+func ParseICal(r io.Reader) (Schedule, error) {
+	b, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lines   = unfold(b)
+		loc     = time.UTC
+		events  []*event
+		ev      *event
+		end     time.Time
+		inEvent bool
+	)
+
+	// The default timezone for floating times is set at the calendar level.
+	for _, l := range lines {
+		if name, _, value := parseLine(l); name == "X-WR-TIMEZONE" {
+			if loc, err = time.LoadLocation(value); err != nil {
+				return nil, fmt.Errorf("error loading timezone: %w", err)
+			}
+		}
+	}
+
+	for i, l := range lines {
+		name, params, value := parseLine(l)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			ev, end, inEvent = &event{exclude: make(map[int64]bool)}, time.Time{}, true
+			continue
+		case name == "END" && value == "VEVENT":
+			if ev.start.IsZero() {
+				return nil, fmt.Errorf("line %d: event without DTSTART", i+1)
+			}
+			if !end.IsZero() {
+				ev.dur = end.Sub(ev.start)
+			}
+			if ev.dur <= 0 {
+				return nil, fmt.Errorf("line %d: event %q without a duration", i+1, ev.user)
+			}
+			if ev.byDay != "" && ev.byDay != strings.ToUpper(ev.start.Weekday().String()[:2]) {
+				return nil, fmt.Errorf("line %d: unsupported BYDAY for event %q, only the weekday of DTSTART is supported", i+1, ev.user)
+			}
+			events = append(events, ev)
+			inEvent = false
+			continue
+		}
+		if !inEvent {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			ev.uid = value
+		case "SUMMARY":
+			ev.user = strings.TrimSpace(unescape(value))
+		case "DTSTART":
+			ev.start, err = parseTime(value, params, loc)
+		case "DTEND":
+			end, err = parseTime(value, params, loc)
+		case "DURATION":
+			ev.dur, err = parseDuration(value)
+		case "RECURRENCE-ID":
+			ev.recurrenceID, err = parseTime(value, params, loc)
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var t time.Time
+				if t, err = parseTime(v, params, loc); err != nil {
+					break
+				}
+				ev.exclude[t.Unix()] = true
+			}
+		case "RRULE":
+			err = parseRRule(ev, value, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: error parsing %s: %w", i+1, name, err)
+		}
+	}
+
+	// Occurrences overridden with a RECURRENCE-ID are excluded from the recurring event.
+	byUID := make(map[string]*event)
+	for _, ev := range events {
+		if ev.every > 0 && ev.uid != "" {
+			byUID[ev.uid] = ev
+		}
+	}
+	for _, ev := range events {
+		if master, ok := byUID[ev.uid]; ok && !ev.recurrenceID.IsZero() {
+			master.exclude[ev.recurrenceID.Unix()] = true
+		}
+	}
+
+	return &icalSchedule{events: events}, nil
+}
+
+// OnCall returns the users of the shifts which started last among the
+// shifts in progress at the given time.
+func (s *icalSchedule) OnCall(at time.Time) []string {
+	var (
+		out    []string
+		latest time.Time
+	)
+	for _, ev := range s.events {
+		start, ok := ev.occurrence(at)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.After(latest):
+			latest = start
+			out = []string{ev.user}
+		case start.Equal(latest):
+			out = append(out, ev.user)
+		}
+	}
+
+	return dedupe(out)
+}
+
+// occurrence returns the start of the occurrence of the event in progress at the given time.
+func (ev *event) occurrence(at time.Time) (time.Time, bool) {
+	if at.Before(ev.start) {
+		return time.Time{}, false
+	}
+	if ev.every == 0 {
+		return ev.start, at.Before(ev.start.Add(ev.dur))
+	}
+
+	// An occurrence might last longer than the interval, so check the previous ones as well.
+	n := shifts(ev.start, time.Duration(ev.every)*24*time.Hour, at)
+	for k := n; k >= 0 && k >= n-int(ev.dur/(time.Duration(ev.every)*24*time.Hour))-1; k-- {
+		start := ev.start.AddDate(0, 0, k*ev.every)
+		if ev.count > 0 && k >= ev.count {
+			continue
+		}
+		if !ev.until.IsZero() && start.After(ev.until) {
+			continue
+		}
+		if ev.exclude[start.Unix()] {
+			continue
+		}
+		if at.Before(start.Add(ev.dur)) {
+			return start, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// unfold splits the calendar into lines, joining folded lines.
+func unfold(b []byte) []string {
+	var (
+		out []string
+		sc  = bufio.NewScanner(bytes.NewReader(b))
+	)
+	sc.Buffer(make([]byte, 0, 64*1024), len(b)+1)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if len(out) > 0 && (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) {
+			out[len(out)-1] += l[1:]
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// parseLine splits a content line into its name, parameters and value.
+func parseLine(l string) (string, map[string]string, string) {
+	// The value starts at the first colon outside of quoted parameter values.
+	quoted, idx := false, -1
+	for i, c := range l {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return "", nil, ""
+	}
+
+	parts := strings.Split(l[:idx], ";")
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, l[idx+1:]
+}
+
+// parseTime parses a DATE or DATE-TIME value.
+func parseTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if tzid, ok := params["TZID"]; ok {
+		l, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error loading timezone: %w", err)
+		}
+		loc = l
+	}
+
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
+
+// parseDuration parses an iCal duration like `P1W`, `P1D` or `PT12H30M`.
+func parseDuration(value string) (time.Duration, error) {
+	s, ok := strings.CutPrefix(value, "P")
+	if !ok {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	var (
+		out    time.Duration
+		num    string
+		inTime bool
+	)
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		num = ""
+
+		switch {
+		case c == 'W' && !inTime:
+			out += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			out += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			out += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			out += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			out += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	return out, nil
+}
+
+// parseRRule parses a daily or weekly recurrence rule of the event.
+func parseRRule(ev *event, value string, loc *time.Location) error {
+	var (
+		freq     string
+		interval = 1
+		err      error
+	)
+	for _, p := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(p, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			freq = strings.ToUpper(v)
+		case "INTERVAL":
+			if interval, err = strconv.Atoi(v); err != nil || interval <= 0 {
+				return fmt.Errorf("invalid interval: %s", v)
+			}
+		case "COUNT":
+			if ev.count, err = strconv.Atoi(v); err != nil || ev.count <= 0 {
+				return fmt.Errorf("invalid count: %s", v)
+			}
+		case "UNTIL":
+			if ev.until, err = parseTime(v, nil, loc); err != nil {
+				return err
+			}
+		case "BYDAY":
+			// Calendar apps add the weekday of weekly events, which is implied by the start.
+			ev.byDay = strings.ToUpper(v)
+		case "WKST":
+		default:
+			return fmt.Errorf("unsupported rule part: %s", k)
+		}
+	}
+
+	switch freq {
+	case "DAILY":
+		ev.every = interval
+	case "WEEKLY":
+		ev.every = 7 * interval
+	default:
+		return fmt.Errorf("unsupported frequency: %s", freq)
+	}
+	if ev.byDay != "" && freq != "WEEKLY" {
+		return fmt.Errorf("unsupported rule part: BYDAY")
+	}
+
+	return nil
+}
+
+// unescape unescapes a TEXT value.
+func unescape(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,203 @@
+// Package oncall works out who is on call for a team at a given time, from
+// rotation schedules in YAML files or iCal calendars (local files or URLs).
+package oncall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+)
+
+// Schedule returns the users on call at a given time.
+type Schedule interface {
+	OnCall(at time.Time) []string
+}
+
+// SourceOpts represents where the schedule of a team is loaded from.
+// Exactly one of File or URL is required. Files ending with `.ics` are
+// parsed as iCal calendars and the rest as YAML schedules. URLs are
+// always parsed as iCal calendars.
+type SourceOpts struct {
+	File string
+	URL  string
+}
+
+// Opts represents the options for a Resolver.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// Sources is a map of team names to where their schedule is loaded from.
+	Sources map[string]SourceOpts
+	// Client is the HTTP client used to fetch iCal URLs.
+	Client *http.Client
+}
+
+// Resolver resolves the users on call for teams. Schedules can be
+// reloaded without restarting.
+type Resolver struct {
+	sync.RWMutex
+	lo        *slog.Logger
+	metrics   *metrics.Manager
+	sources   map[string]SourceOpts
+	client    *http.Client
+	schedules map[string]Schedule
+}
+
+// New loads the schedules of all the teams and returns a Resolver.
+func New(opts Opts) (*Resolver, error) {
+	r := &Resolver{
+		lo:        opts.Log,
+		metrics:   opts.Metrics,
+		sources:   opts.Sources,
+		client:    opts.Client,
+		schedules: make(map[string]Schedule, len(opts.Sources)),
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	for team, src := range r.sources {
+		if (src.File == "") == (src.URL == "") {
+			return nil, fmt.Errorf("exactly one of file or url is required for the schedule of %s", team)
+		}
+	}
+
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Load (re)loads the schedules of all the teams. The current schedule
+// of a team is kept if its new schedule can't be loaded.
+func (r *Resolver) Load() error {
+	var errs []error
+	for team, src := range r.sources {
+		s, err := r.load(src)
+		if err != nil {
+			r.metrics.Increment(fmt.Sprintf(`oncall_reload_errors_total{team="%s"}`, team))
+			errs = append(errs, fmt.Errorf("error loading schedule for %s: %w", team, err))
+			continue
+		}
+
+		r.Lock()
+		r.schedules[team] = s
+		r.Unlock()
+		r.lo.Debug("loaded on-call schedule", "team", team)
+	}
+
+	return errors.Join(errs...)
+}
+
+// load loads a schedule from its source.
+func (r *Resolver) load(src SourceOpts) (Schedule, error) {
+	if src.URL != "" {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, src.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error fetching calendar: status %d", resp.StatusCode)
+		}
+		return ParseICal(resp.Body)
+	}
+
+	f, err := os.Open(src.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(src.File), ".ics") {
+		return ParseICal(f)
+	}
+	return ParseYAML(f)
+}
+
+// OnCall returns the users on call for the team at the given time.
+// It's safe to call on a nil Resolver.
+func (r *Resolver) OnCall(team string, at time.Time) []string {
+	if r == nil {
+		return nil
+	}
+
+	r.RLock()
+	s, ok := r.schedules[team]
+	r.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	return s.OnCall(at)
+}
+
+// Teams returns the names of the teams with a schedule, sorted.
+func (r *Resolver) Teams() []string {
+	out := make([]string, 0, len(r.sources))
+	for team := range r.sources {
+		out = append(out, team)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// StartReloadWorker periodically reloads the schedules.
+// This is a blocking function so the caller must invoke as a goroutine.
+func (r *Resolver) StartReloadWorker(interval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(interval).C
+	)
+
+	for range evalTicker {
+		if err := r.Load(); err != nil {
+			r.lo.Error("error reloading on-call schedules, keeping the current schedules", "error", err)
+		}
+	}
+}
+
+// dedupe removes duplicate users, keeping the order.
+func dedupe(users []string) []string {
+	var (
+		out  = make([]string, 0, len(users))
+		seen = make(map[string]bool, len(users))
+	)
+	for _, u := range users {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		out = append(out, u)
+	}
+	return out
+}
+
+// readAll reads a schedule with a sane upper limit on its size.
+func readAll(r io.Reader) ([]byte, error) {
+	const maxSize = 10 << 20
+	b, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSize {
+		return nil, fmt.Errorf("schedule is larger than %d bytes", maxSize)
+	}
+	return b, nil
+}
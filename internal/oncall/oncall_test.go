@@ -0,0 +1,166 @@
+package oncall
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadFixture(t *testing.T, name string, parse func(f *os.File) (Schedule, error)) Schedule {
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	s, err := parse(f)
+	require.NoError(t, err)
+	return s
+}
+
+func at(t *testing.T, tz, s string) time.Time {
+	loc, err := time.LoadLocation(tz)
+	require.NoError(t, err)
+	out, err := time.ParseInLocation(dateTimeLayout, s, loc)
+	require.NoError(t, err)
+	return out
+}
+
+func TestYAML(t *testing.T) {
+	s := loadFixture(t, "db.yml", func(f *os.File) (Schedule, error) { return ParseYAML(f) })
+	ny := "America/New_York"
+
+	tests := []struct {
+		at   time.Time
+		want []string
+	}{
+		// Only the secondary rotation has started.
+		{at(t, ny, "2026-10-20 12:00"), []string{"dave"}},
+		{at(t, ny, "2026-10-26 10:00"), []string{"alice", "erin"}},
+		{at(t, ny, "2026-10-27 08:59"), []string{"alice", "erin"}},
+		{at(t, ny, "2026-10-27 09:00"), []string{"bob", "erin"}},
+		// Times in other timezones are evaluated in the schedule's timezone.
+		{at(t, "UTC", "2026-10-27 13:00"), []string{"bob", "erin"}},
+		// Override of a rotation.
+		{at(t, ny, "2026-10-28 12:00"), []string{"frank", "erin"}},
+		{at(t, ny, "2026-10-28 21:00"), []string{"carol", "erin"}},
+		// Override of the whole schedule.
+		{at(t, ny, "2026-10-31 06:00"), []string{"grace"}},
+		// Handoffs happen at the same wall clock time after DST ends on 2026-11-01.
+		{at(t, ny, "2026-11-02 08:30"), []string{"alice", "erin"}},
+		{at(t, ny, "2026-11-02 09:00"), []string{"bob", "dave"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, s.OnCall(tc.at), tc.at.String())
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"no rotations":     `timezone: UTC`,
+		"bad timezone":     "timezone: Mars/Olympus\nrotations: [{start: '2026-10-19 09:00', shift: 24h, users: [a]}]",
+		"bad shift":        `rotations: [{start: '2026-10-19 09:00', shift: 1d, users: [a]}]`,
+		"no users":         `rotations: [{start: '2026-10-19 09:00', shift: 24h}]`,
+		"unknown rotation": "rotations: [{start: '2026-10-19 09:00', shift: 24h, users: [a]}]\noverrides: [{rotation: x, users: [b], start: '2026-10-19 09:00', end: '2026-10-19 10:00'}]",
+		"end before start": "rotations: [{start: '2026-10-19 09:00', shift: 24h, users: [a]}]\noverrides: [{users: [b], start: '2026-10-19 09:00', end: '2026-10-19 08:00'}]",
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseYAML(strings.NewReader(body))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestICal(t *testing.T) {
+	s := loadFixture(t, "db.ics", func(f *os.File) (Schedule, error) { return ParseICal(f) })
+	berlin := "Europe/Berlin"
+
+	tests := []struct {
+		at   time.Time
+		want []string
+	}{
+		{at(t, berlin, "2026-10-19 08:59"), []string{}},
+		{at(t, berlin, "2026-10-20 12:00"), []string{"alice"}},
+		// An override event on top of the rotation, in UTC.
+		{at(t, "UTC", "2026-10-21 12:00"), []string{"carol"}},
+		// Handoffs happen at the same wall clock time after DST ends on 2026-10-25.
+		{at(t, berlin, "2026-10-26 08:30"), []string{"alice"}},
+		{at(t, berlin, "2026-10-26 09:00"), []string{"bob"}},
+		// An occurrence of the recurring event overridden with a RECURRENCE-ID.
+		{at(t, berlin, "2026-11-02 10:00"), []string{"dave"}},
+		{at(t, berlin, "2026-11-09 10:00"), []string{"bob"}},
+		{at(t, berlin, "2026-11-16 10:00"), []string{"alice"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, s.OnCall(tc.at), tc.at.String())
+	}
+}
+
+func TestICalErrors(t *testing.T) {
+	event := func(lines ...string) string {
+		return "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:alice\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+
+	tests := map[string]string{
+		"no start":          event("DTEND:20261026T090000Z"),
+		"no duration":       event("DTSTART:20261019T090000Z"),
+		"bad timezone":      event("DTSTART;TZID=Mars/Olympus:20261019T090000", "DURATION:P1D"),
+		"bad duration":      event("DTSTART:20261019T090000Z", "DURATION:P1X"),
+		"monthly":           event("DTSTART:20261019T090000Z", "DURATION:P1D", "RRULE:FREQ=MONTHLY"),
+		"other weekday":     event("DTSTART:20261019T090000Z", "DURATION:P1D", "RRULE:FREQ=WEEKLY;BYDAY=TU"),
+		"multiple weekdays": event("DTSTART:20261019T090000Z", "DURATION:P1D", "RRULE:FREQ=WEEKLY;BYDAY=MO,TU"),
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseICal(strings.NewReader(body))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	ics, err := os.ReadFile(filepath.Join("testdata", "db.ics"))
+	require.NoError(t, err)
+
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(ics)
+	}))
+	defer srv.Close()
+
+	r, err := New(Opts{
+		Log:     slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics: metrics.New("calert"),
+		Sources: map[string]SourceOpts{
+			"db":    {File: filepath.Join("testdata", "db.yml")},
+			"infra": {URL: srv.URL},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"db", "infra"}, r.Teams())
+	assert.Equal(t, []string{"alice", "erin"}, r.OnCall("db", at(t, "America/New_York", "2026-10-26 10:00")))
+	assert.Equal(t, []string{"alice"}, r.OnCall("infra", at(t, "Europe/Berlin", "2026-10-20 12:00")))
+	assert.Nil(t, r.OnCall("unknown", time.Now()))
+
+	// The current schedule is kept if it can't be reloaded.
+	fail = true
+	assert.Error(t, r.Load())
+	assert.Equal(t, []string{"alice"}, r.OnCall("infra", at(t, "Europe/Berlin", "2026-10-20 12:00")))
+
+	// A nil resolver has no one on call.
+	var nilResolver *Resolver
+	assert.Nil(t, nilResolver.OnCall("db", time.Now()))
+}
@@ -0,0 +1,181 @@
+package oncall
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const dateTimeLayout = "2006-01-02 15:04"
+
+// yamlFile is the format of YAML schedules.
+type yamlFile struct {
+	// Timezone in which the times are evaluated. Defaults to UTC.
+	Timezone  string         `yaml:"timezone"`
+	Rotations []yamlRotation `yaml:"rotations"`
+	Overrides []yamlOverride `yaml:"overrides"`
+}
+
+type yamlRotation struct {
+	Name string `yaml:"name"`
+	// Start is the first handoff, in `2006-01-02 15:04` format.
+	Start string `yaml:"start"`
+	// Shift is how long each user is on call, eg `24h` or `168h`.
+	Shift string   `yaml:"shift"`
+	Users []string `yaml:"users"`
+}
+
+type yamlOverride struct {
+	// Rotation the override applies to. Applies to all rotations if empty.
+	Rotation string   `yaml:"rotation"`
+	Users    []string `yaml:"users"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+}
+
+// rotation is a parsed rotation where users take turns.
+type rotation struct {
+	name  string
+	start time.Time
+	shift time.Duration
+	users []string
+}
+
+// override replaces the users on call between start and end.
+type override struct {
+	rotation   string
+	users      []string
+	start, end time.Time
+}
+
+// yamlSchedule is a schedule made of rotations and overrides.
+type yamlSchedule struct {
+	rotations []rotation
+	overrides []override
+}
+
+// ParseYAML parses a YAML schedule.
+func ParseYAML(r io.Reader) (Schedule, error) {
+	b, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var f yamlFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("error parsing schedule: %w", err)
+	}
+
+	loc := time.UTC
+	if f.Timezone != "" {
+		if loc, err = time.LoadLocation(f.Timezone); err != nil {
+			return nil, fmt.Errorf("error loading timezone: %w", err)
+		}
+	}
+
+	s := &yamlSchedule{}
+	names := make(map[string]bool, len(f.Rotations))
+	for i, r := range f.Rotations {
+		rot := rotation{name: r.Name, users: r.Users}
+		if rot.start, err = time.ParseInLocation(dateTimeLayout, r.Start, loc); err != nil {
+			return nil, fmt.Errorf("rotation %d: error parsing start: %w", i+1, err)
+		}
+		if rot.shift, err = time.ParseDuration(r.Shift); err != nil || rot.shift <= 0 {
+			return nil, fmt.Errorf("rotation %d: invalid shift: %q", i+1, r.Shift)
+		}
+		if len(rot.users) == 0 {
+			return nil, fmt.Errorf("rotation %d: no users", i+1)
+		}
+		names[r.Name] = true
+		s.rotations = append(s.rotations, rot)
+	}
+	if len(s.rotations) == 0 {
+		return nil, fmt.Errorf("no rotations in schedule")
+	}
+
+	for i, o := range f.Overrides {
+		ov := override{rotation: o.Rotation, users: o.Users}
+		if ov.start, err = time.ParseInLocation(dateTimeLayout, o.Start, loc); err != nil {
+			return nil, fmt.Errorf("override %d: error parsing start: %w", i+1, err)
+		}
+		if ov.end, err = time.ParseInLocation(dateTimeLayout, o.End, loc); err != nil {
+			return nil, fmt.Errorf("override %d: error parsing end: %w", i+1, err)
+		}
+		if !ov.end.After(ov.start) {
+			return nil, fmt.Errorf("override %d: end must be after start", i+1)
+		}
+		if ov.rotation != "" && !names[ov.rotation] {
+			return nil, fmt.Errorf("override %d: unknown rotation: %s", i+1, ov.rotation)
+		}
+		if len(ov.users) == 0 {
+			return nil, fmt.Errorf("override %d: no users", i+1)
+		}
+		s.overrides = append(s.overrides, ov)
+	}
+
+	return s, nil
+}
+
+// OnCall returns the users on call in each rotation at the given time,
+// with overrides taking precedence. Later overrides win.
+func (s *yamlSchedule) OnCall(at time.Time) []string {
+	// An override for all the rotations replaces the entire schedule.
+	if o := s.override("", at); o != nil {
+		return dedupe(o.users)
+	}
+
+	var out []string
+	for _, r := range s.rotations {
+		if o := s.override(r.name, at); o != nil {
+			out = append(out, o.users...)
+			continue
+		}
+		if u, ok := r.onCall(at); ok {
+			out = append(out, u)
+		}
+	}
+
+	return dedupe(out)
+}
+
+// override returns the last override active at the given time for the rotation.
+func (s *yamlSchedule) override(rotation string, at time.Time) *override {
+	for i := len(s.overrides) - 1; i >= 0; i-- {
+		o := &s.overrides[i]
+		if o.rotation == rotation && !at.Before(o.start) && at.Before(o.end) {
+			return o
+		}
+	}
+	return nil
+}
+
+// onCall returns the user whose shift it is at the given time.
+func (r rotation) onCall(at time.Time) (string, bool) {
+	if at.Before(r.start) {
+		return "", false
+	}
+
+	n := shifts(r.start, r.shift, at)
+	return r.users[n%len(r.users)], true
+}
+
+// shifts returns the number of shifts that started after `start` and
+// until `at`. Shifts which are a multiple of a day are handed off at the
+// same wall clock time, even across DST changes.
+func shifts(start time.Time, shift time.Duration, at time.Time) int {
+	n := int(at.Sub(start) / shift)
+	if shift%(24*time.Hour) != 0 {
+		return n
+	}
+
+	days := int(shift / (24 * time.Hour))
+	for n > 0 && start.AddDate(0, 0, n*days).After(at) {
+		n--
+	}
+	for !start.AddDate(0, 0, (n+1)*days).After(at) {
+		n++
+	}
+	return n
+}
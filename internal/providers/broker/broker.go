@@ -0,0 +1,200 @@
+// Package broker publishes alerts to message brokers: Kafka topics, NATS
+// JetStream subjects and Redis streams.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Formats of the published payloads.
+const (
+	// FormatAlert publishes the alert as JSON, as received from Alertmanager.
+	FormatAlert = "alert"
+	// FormatMessage publishes the rendered template as is.
+	FormatMessage = "message"
+)
+
+// message is a message to publish. The key is the fingerprint of the alert,
+// and the ID is unique to the message so that brokers can drop retried duplicates.
+type message struct {
+	id      string
+	key     string
+	value   []byte
+	headers map[string]string
+}
+
+// publisher publishes messages to a broker and waits for the broker to acknowledge them.
+type publisher interface {
+	publish(ctx context.Context, msg message) error
+	// close flushes the pending messages and closes the connection.
+	close() error
+}
+
+type BrokerManager struct {
+	lo           *slog.Logger
+	dispatcher   *providers.Dispatcher
+	id           string
+	room         string
+	format       string
+	pub          publisher
+	timeout      time.Duration
+	msgTmpl      *template.Template
+	retryMax     int
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+
+	// ctx is cancelled on Close, which stops waiting to retry messages.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BrokerOpts are the options common to all brokers.
+type BrokerOpts struct {
+	Log      *slog.Logger
+	Metrics  *metrics.Manager
+	DryRun   bool
+	Room     string
+	Template string
+	// Format is FormatAlert (default) or FormatMessage.
+	Format string
+	// Timeout is how long to wait for the broker to acknowledge a message.
+	Timeout      time.Duration
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// newBroker initializes a broker provider object which publishes with pub.
+func newBroker(id string, opts BrokerOpts, pub publisher) (*BrokerManager, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatAlert
+	case FormatAlert, FormatMessage:
+	default:
+		return nil, fmt.Errorf("invalid format %s, should be %s or %s", opts.Format, FormatAlert, FormatMessage)
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BrokerManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			History:  opts.History,
+			Provider: id,
+			Room:     opts.Room,
+			DryRun:   opts.DryRun,
+			Breaker:  providers.NewBreaker(opts.Log, opts.Metrics, id, opts.Room, opts.BreakerOpts),
+		},
+		id:           id,
+		room:         opts.Room,
+		format:       opts.Format,
+		pub:          pub,
+		timeout:      opts.Timeout,
+		msgTmpl:      tmpl,
+		retryMax:     opts.RetryMax,
+		retryWaitMin: opts.RetryWaitMin,
+		retryWaitMax: opts.RetryWaitMax,
+		ctx:          ctx,
+		cancel:       cancel,
+	}, nil
+}
+
+// Push accepts the list of alerts and publishes a message for each of them, with
+// the fingerprint as the key. Alerts which the broker didn't acknowledge after
+// retries are returned as a *providers.PushError.
+func (m *BrokerManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msg, err := m.prepareMessage(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: string(msg.value),
+			Send: func() error { return m.publishWithRetries(msg) },
+		}}, nil
+	})
+}
+
+// publishWithRetries publishes the message, retrying with an exponential backoff
+// between RetryWaitMin and RetryWaitMax if it isn't acknowledged. The retries
+// stop once the provider is closed.
+func (m *BrokerManager) publishWithRetries(msg message) error {
+	var (
+		wait = m.retryWaitMin
+		err  error
+	)
+	for i := 0; i <= m.retryMax; i++ {
+		if i > 0 {
+			m.lo.Debug("retrying message", "room", m.Room(), "wait", wait, "remaining", m.retryMax-i+1, "error", err)
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-m.ctx.Done():
+				t.Stop()
+				return fmt.Errorf("giving up after %d attempt(s), provider is closed: %w", i, err)
+			}
+			wait = min(wait*2, m.retryWaitMax)
+		}
+		if err = m.publish(msg); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempt(s): %w", m.retryMax+1, err)
+}
+
+// publish publishes the message, waiting up to the timeout for the acknowledgement.
+func (m *BrokerManager) publish(msg message) error {
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	m.lo.Debug("publishing alert", "room", m.Room(), "key", msg.key)
+	return m.pub.publish(ctx, msg)
+}
+
+// Close stops the retries in flight, and closes the connection to the broker
+// once the pending messages are flushed.
+func (m *BrokerManager) Close() error {
+	m.cancel()
+	return m.pub.close()
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *BrokerManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name, which is the type of the broker.
+func (m *BrokerManager) ID() string {
+	return m.id
+}
@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAlerts = []alertmgrtmpl.Alert{
+	{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Labels:      alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"},
+		Annotations: alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+	},
+	{Fingerprint: "def", Status: "resolved", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "warning"}},
+}
+
+func testOpts(format string) BrokerOpts {
+	return BrokerOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Room:         "events",
+		Template:     "../../../static/broker.tmpl",
+		Format:       format,
+		Timeout:      5 * time.Second,
+		RetryMax:     1,
+		RetryWaitMin: 10 * time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+	}
+}
+
+// flakyPublisher fails the first n publishes.
+type flakyPublisher struct {
+	fails int
+	msgs  []message
+}
+
+func (p *flakyPublisher) publish(_ context.Context, msg message) error {
+	p.msgs = append(p.msgs, msg)
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("not acknowledged")
+	}
+	return nil
+}
+
+func (p *flakyPublisher) close() error {
+	return nil
+}
+
+func TestPrepareMessage(t *testing.T) {
+	m, err := newBroker("test", testOpts(""), &flakyPublisher{})
+	require.NoError(t, err)
+
+	msg, err := m.prepareMessage(testAlerts[0])
+	require.NoError(t, err)
+	assert.Equal(t, "abc", msg.key)
+	assert.NotEmpty(t, msg.id)
+	assert.Equal(t, map[string]string{headerRoom: "events", headerStatus: "firing"}, msg.headers)
+	var a alertmgrtmpl.Alert
+	require.NoError(t, json.Unmarshal(msg.value, &a))
+	assert.Equal(t, testAlerts[0], a)
+
+	m, err = newBroker("test", testOpts(FormatMessage), &flakyPublisher{})
+	require.NoError(t, err)
+	msg, err = m.prepareMessage(testAlerts[0])
+	require.NoError(t, err)
+	assert.Equal(t, "(CRITICAL) Diskfull - Firing\nSummary: /var is > 90% full", string(msg.value))
+
+	_, err = newBroker("test", testOpts("xml"), &flakyPublisher{})
+	assert.ErrorContains(t, err, "invalid format xml")
+}
+
+func TestPushRetries(t *testing.T) {
+	// The first attempt fails and the retry is acknowledged, with the same message ID.
+	pub := &flakyPublisher{fails: 1}
+	m, err := newBroker("test", testOpts(""), pub)
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts[:1]))
+	require.Len(t, pub.msgs, 2)
+	assert.Equal(t, pub.msgs[0].id, pub.msgs[1].id)
+
+	// Both attempts fail.
+	pub = &flakyPublisher{fails: 2}
+	m, err = newBroker("test", testOpts(""), pub)
+	require.NoError(t, err)
+	err = m.Push(testAlerts)
+	var pushErr *providers.PushError
+	require.ErrorAs(t, err, &pushErr)
+	assert.Equal(t, testAlerts[:1], pushErr.Alerts)
+	assert.ErrorContains(t, err, "giving up after 2 attempt(s): not acknowledged")
+	assert.Len(t, pub.msgs, 3)
+
+	// Closing the provider stops waiting to retry.
+	pub = &flakyPublisher{fails: 2}
+	opts := testOpts("")
+	opts.RetryWaitMin, opts.RetryWaitMax = time.Hour, time.Hour
+	m, err = newBroker("test", opts, pub)
+	require.NoError(t, err)
+	time.AfterFunc(10*time.Millisecond, func() { m.Close() })
+	err = m.Push(testAlerts[:1])
+	require.ErrorAs(t, err, &pushErr)
+	assert.ErrorContains(t, err, "giving up after 1 attempt(s), provider is closed: not acknowledged")
+
+	// Nothing is published in dry run.
+	pub = &flakyPublisher{}
+	opts = testOpts("")
+	opts.DryRun = true
+	m, err = newBroker("test", opts, pub)
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts))
+	assert.Empty(t, pub.msgs)
+}
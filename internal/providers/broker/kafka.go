@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// SASL mechanisms of Kafka.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaOpts are the options of Kafka brokers.
+type KafkaOpts struct {
+	// Brokers are the addresses of the seed brokers, eg localhost:9092.
+	Brokers []string
+	Topic   string
+	// SASLMechanism is one of SASLPlain, SASLScramSHA256 or SASLScramSHA512,
+	// if Username is set. Defaults to SASLPlain.
+	SASLMechanism string
+	Username      string
+	Password      string
+	TLS           bool
+	TLSSkipVerify bool
+}
+
+type kafkaPublisher struct {
+	client *kgo.Client
+}
+
+// NewKafka initializes a provider object which produces alerts to a Kafka topic.
+// Records are only acknowledged once all the in-sync replicas have them.
+func NewKafka(opts BrokerOpts, kOpts KafkaOpts) (*BrokerManager, error) {
+	if len(kOpts.Brokers) == 0 || kOpts.Topic == "" {
+		return nil, fmt.Errorf("brokers and topic are required")
+	}
+
+	kgoOpts := []kgo.Opt{
+		kgo.SeedBrokers(kOpts.Brokers...),
+		kgo.DefaultProduceTopic(kOpts.Topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.ClientID("calert"),
+	}
+	if kOpts.Username != "" {
+		var mech kgo.Opt
+		switch kOpts.SASLMechanism {
+		case "", SASLPlain:
+			mech = kgo.SASL(plain.Auth{User: kOpts.Username, Pass: kOpts.Password}.AsMechanism())
+		case SASLScramSHA256:
+			mech = kgo.SASL(scram.Auth{User: kOpts.Username, Pass: kOpts.Password}.AsSha256Mechanism())
+		case SASLScramSHA512:
+			mech = kgo.SASL(scram.Auth{User: kOpts.Username, Pass: kOpts.Password}.AsSha512Mechanism())
+		default:
+			return nil, fmt.Errorf("invalid sasl mechanism %s, should be %s, %s or %s", kOpts.SASLMechanism, SASLPlain, SASLScramSHA256, SASLScramSHA512)
+		}
+		kgoOpts = append(kgoOpts, mech)
+	}
+	if kOpts.TLS {
+		kgoOpts = append(kgoOpts, kgo.DialTLSConfig(&tls.Config{InsecureSkipVerify: kOpts.TLSSkipVerify}))
+	}
+
+	// The client connects to the brokers lazily, so calert starts even if they're down.
+	client, err := kgo.NewClient(kgoOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBroker("kafka", opts, &kafkaPublisher{client: client})
+}
+
+// publish produces the message as a record to the topic, with the key of the message
+// as the record's key, so that all the records for an alert are in the same partition.
+func (p *kafkaPublisher) publish(ctx context.Context, msg message) error {
+	rec := &kgo.Record{
+		Key:   []byte(msg.key),
+		Value: msg.value,
+	}
+	for _, k := range sortedKeys(msg.headers) {
+		rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: k, Value: []byte(msg.headers[k])})
+	}
+
+	return p.client.ProduceSync(ctx, rec).FirstErr()
+}
+
+// close flushes the buffered records and closes the client.
+func (p *kafkaPublisher) close() error {
+	err := p.client.Flush(context.Background())
+	p.client.Close()
+	return err
+}
+
+// sortedKeys returns the keys of the headers in order, so that they're published in a stable order.
+func sortedKeys(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
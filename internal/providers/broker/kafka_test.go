@@ -0,0 +1,48 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKafka(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "alerts"))
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	m, err := NewKafka(testOpts(""), KafkaOpts{Brokers: cluster.ListenAddrs(), Topic: "alerts"})
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts))
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics("alerts"))
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var recs []*kgo.Record
+	for len(recs) < 2 {
+		fetches := consumer.PollFetches(ctx)
+		require.NoError(t, fetches.Err())
+		recs = append(recs, fetches.Records()...)
+	}
+
+	assert.Equal(t, "abc", string(recs[0].Key))
+	assert.Contains(t, string(recs[0].Value), `"fingerprint":"abc"`)
+	assert.Equal(t, []kgo.RecordHeader{
+		{Key: headerRoom, Value: []byte("events")},
+		{Key: headerStatus, Value: []byte("firing")},
+	}, recs[0].Headers)
+	assert.Equal(t, "def", string(recs[1].Key))
+
+	_, err = NewKafka(testOpts(""), KafkaOpts{Brokers: cluster.ListenAddrs()})
+	assert.Error(t, err)
+	_, err = NewKafka(testOpts(""), KafkaOpts{Brokers: cluster.ListenAddrs(), Topic: "alerts", Username: "calert", SASLMechanism: "GSSAPI"})
+	assert.ErrorContains(t, err, "invalid sasl mechanism")
+}
@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Headers of the published messages. Redis streams have them as fields of the entries.
+const (
+	headerRoom   = "calert-room"
+	headerStatus = "calert-status"
+)
+
+// prepareMessage returns the message for the alert, with the alert as JSON or
+// the rendered template as the value, depending on the format.
+func (m *BrokerManager) prepareMessage(a alertmgrtmpl.Alert) (message, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return message{}, err
+	}
+
+	msg := message{
+		id:  uid.String(),
+		key: a.Fingerprint,
+		headers: map[string]string{
+			headerRoom:   m.Room(),
+			headerStatus: a.Status,
+		},
+	}
+
+	if m.format == FormatAlert {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return message{}, err
+		}
+		msg.value = b
+		return msg, nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return message{}, err
+	}
+	msg.value = []byte(strings.TrimSpace(buf.String()))
+
+	return msg, nil
+}
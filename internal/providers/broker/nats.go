@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// headerKey is the header with the key of messages, as NATS messages don't have keys.
+const headerKey = "calert-key"
+
+// NATSOpts are the options of NATS servers.
+type NATSOpts struct {
+	// URL is the URL of the server, or a comma separated list of URLs of the cluster.
+	URL string
+	// Subject is the subject to publish to. It has to be captured by a JetStream stream.
+	Subject  string
+	Username string
+	Password string
+	Token    string
+}
+
+type natsPublisher struct {
+	subject string
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	// closed is closed once the connection is closed after draining.
+	closed chan struct{}
+}
+
+// NewNATS initializes a provider object which publishes alerts to a NATS JetStream
+// subject. Messages are acknowledged once the stream has stored them.
+func NewNATS(opts BrokerOpts, nOpts NATSOpts) (*BrokerManager, error) {
+	if nOpts.URL == "" || nOpts.Subject == "" {
+		return nil, fmt.Errorf("url and subject are required")
+	}
+
+	closed := make(chan struct{})
+	natsOpts := []nats.Option{
+		nats.Name("calert"),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+		// Keep reconnecting, and don't fail if the server is down when calert starts.
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if nOpts.Username != "" {
+		natsOpts = append(natsOpts, nats.UserInfo(nOpts.Username, nOpts.Password))
+	}
+	if nOpts.Token != "" {
+		natsOpts = append(natsOpts, nats.Token(nOpts.Token))
+	}
+
+	nc, err := nats.Connect(nOpts.URL, natsOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to nats: %w", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBroker("nats", opts, &natsPublisher{subject: nOpts.Subject, nc: nc, js: js, closed: closed})
+}
+
+// publish publishes the message to the subject and waits for the stream's ack.
+// The ID of the message lets the stream drop duplicates of retried messages.
+func (p *natsPublisher) publish(ctx context.Context, msg message) error {
+	m := nats.NewMsg(p.subject)
+	m.Data = msg.value
+	m.Header.Set(headerKey, msg.key)
+	for k, v := range msg.headers {
+		m.Header.Set(k, v)
+	}
+
+	_, err := p.js.PublishMsg(ctx, m, jetstream.WithMsgID(msg.id))
+	return err
+}
+
+// close drains the connection, which flushes the pending messages, and waits
+// for it to be closed, up to the drain timeout of the client.
+func (p *natsPublisher) close() error {
+	if err := p.nc.Drain(); err != nil {
+		return err
+	}
+	<-p.closed
+	return p.nc.LastError()
+}
@@ -0,0 +1,75 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startNATS(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestNATS(t *testing.T) {
+	s := startNATS(t)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Nothing acknowledges messages on subjects without a stream.
+	opts := testOpts(FormatMessage)
+	opts.Timeout = time.Second
+	opts.RetryMax = 0
+	m, err := NewNATS(opts, NATSOpts{URL: s.ClientURL(), Subject: "alerts.events"})
+	require.NoError(t, err)
+	var pushErr *providers.PushError
+	require.ErrorAs(t, m.Push(testAlerts), &pushErr)
+	assert.Equal(t, testAlerts, pushErr.Alerts)
+
+	stream, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "ALERTS", Subjects: []string{"alerts.>"}})
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts))
+
+	info, err := stream.Info(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, info.State.Msgs)
+
+	msg, err := stream.GetMsg(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "alerts.events", msg.Subject)
+	assert.Equal(t, "(CRITICAL) Diskfull - Firing\nSummary: /var is > 90% full", string(msg.Data))
+	assert.Equal(t, "abc", msg.Header.Get(headerKey))
+	assert.Equal(t, "events", msg.Header.Get(headerRoom))
+	assert.Equal(t, "firing", msg.Header.Get(headerStatus))
+	assert.NotEmpty(t, msg.Header.Get(jetstream.MsgIDHeader))
+
+	// The connection is drained and closed, after which nothing is published.
+	require.NoError(t, m.Close())
+	require.ErrorAs(t, m.Push(testAlerts), &pushErr)
+	assert.ErrorContains(t, pushErr, "connection closed")
+}
@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields of the entries of Redis streams, along with the headers.
+const (
+	fieldKey   = "key"
+	fieldValue = "value"
+)
+
+// RedisOpts are the options of Redis servers.
+type RedisOpts struct {
+	// Address is the host:port of the server.
+	Address  string
+	Username string
+	Password string
+	DB       int
+	Stream   string
+	// MaxLen caps the stream at about this many entries. 0 means it isn't capped.
+	MaxLen        int64
+	TLS           bool
+	TLSSkipVerify bool
+}
+
+type redisPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedis initializes a provider object which adds alerts to a Redis stream.
+// Entries are acknowledged with their ID once the server has added them.
+func NewRedis(opts BrokerOpts, rOpts RedisOpts) (*BrokerManager, error) {
+	if rOpts.Address == "" || rOpts.Stream == "" {
+		return nil, fmt.Errorf("address and stream are required")
+	}
+
+	redisOpts := &redis.Options{
+		Addr:     rOpts.Address,
+		Username: rOpts.Username,
+		Password: rOpts.Password,
+		DB:       rOpts.DB,
+		// Retries are handled by the provider.
+		MaxRetries: -1,
+	}
+	if rOpts.TLS {
+		redisOpts.TLSConfig = &tls.Config{InsecureSkipVerify: rOpts.TLSSkipVerify}
+	}
+
+	return newBroker("redis", opts, &redisPublisher{
+		client: redis.NewClient(redisOpts),
+		stream: rOpts.Stream,
+		maxLen: rOpts.MaxLen,
+	})
+}
+
+// publish adds the message as an entry to the stream, with the key, value
+// and headers of the message as its fields.
+func (p *redisPublisher) publish(ctx context.Context, msg message) error {
+	values := []string{fieldKey, msg.key, fieldValue, string(msg.value)}
+	for _, k := range sortedKeys(msg.headers) {
+		values = append(values, k, msg.headers[k])
+	}
+
+	return p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: values,
+	}).Err()
+}
+
+// close closes the client.
+func (p *redisPublisher) close() error {
+	return p.client.Close()
+}
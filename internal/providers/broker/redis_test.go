@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedis(t *testing.T) {
+	s := miniredis.RunT(t)
+
+	m, err := NewRedis(testOpts(FormatMessage), RedisOpts{Address: s.Addr(), Stream: "alerts"})
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts))
+
+	entries, err := s.Stream("alerts")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, []string{
+		fieldKey, "abc",
+		fieldValue, "(CRITICAL) Diskfull - Firing\nSummary: /var is > 90% full",
+		headerRoom, "events",
+		headerStatus, "firing",
+	}, entries[0].Values)
+	assert.Equal(t, "def", entries[1].Values[1])
+
+	// The stream is capped.
+	m, err = NewRedis(testOpts(""), RedisOpts{Address: s.Addr(), Stream: "capped", MaxLen: 1})
+	require.NoError(t, err)
+	require.NoError(t, m.Push(testAlerts))
+	entries, err = s.Stream("capped")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "def", entries[0].Values[1])
+
+	// Alerts aren't acknowledged once the server is down.
+	addr := s.Addr()
+	s.Close()
+	m, err = NewRedis(testOpts(""), RedisOpts{Address: addr, Stream: "alerts"})
+	require.NoError(t, err)
+	assert.Error(t, m.Push(testAlerts))
+}
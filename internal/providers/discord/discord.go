@@ -0,0 +1,124 @@
+// Package discord sends alerts to Discord channels with webhooks.
+package discord
+
+import (
+	"log/slog"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+type DiscordManager struct {
+	lo         *slog.Logger
+	dispatcher *providers.Dispatcher
+	endpoint   string
+	room       string
+	username   string
+	avatarURL  string
+	client     *retryablehttp.Client
+	msgTmpl    *template.Template
+}
+
+type DiscordOpts struct {
+	Log          *slog.Logger
+	Metrics      *metrics.Manager
+	DryRun       bool
+	MaxIdleConn  int
+	Timeout      time.Duration
+	ProxyURL     string
+	Endpoint     string
+	Room         string
+	Template     string
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	// Username and AvatarURL override the webhook's default name and avatar. Optional.
+	Username  string
+	AvatarURL string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewDiscord initializes a Discord provider object.
+func NewDiscord(opts DiscordOpts) (*DiscordManager, error) {
+	// Discord rate limits webhooks and sends the time to wait in the body of 429 responses.
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+		// ref. https://discord.com/developers/docs/topics/rate-limits#exceeding-a-rate-limit
+		RetryAfter: providers.RetryAfterJSON(time.Second, "retry_after"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mention,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiscordManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			History:  opts.History,
+			Provider: "discord",
+			Room:     opts.Room,
+			DryRun:   opts.DryRun,
+			Breaker:  providers.NewBreaker(opts.Log, opts.Metrics, "discord", opts.Room, opts.BreakerOpts),
+		},
+		endpoint:  opts.Endpoint,
+		room:      opts.Room,
+		username:  opts.Username,
+		avatarURL: opts.AvatarURL,
+		client:    client,
+		msgTmpl:   tmpl,
+	}, nil
+}
+
+// Push accepts the list of alerts and dispatches them to the webhook. Alerts
+// which couldn't be sent after retries are returned as a *providers.PushError.
+func (m *DiscordManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msgs, err := m.prepareMessages(a)
+		if err != nil {
+			return nil, err
+		}
+
+		out := make([]providers.Message, 0, len(msgs))
+		for _, msg := range msgs {
+			out = append(out, providers.Message{Text: msg.Content, Send: func() error { return m.sendMessage(msg) }})
+		}
+		return out, nil
+	})
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *DiscordManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name.
+func (m *DiscordManager) ID() string {
+	return "discord"
+}
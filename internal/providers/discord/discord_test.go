@@ -0,0 +1,165 @@
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiscord(t *testing.T, endpoint, tmpl string) *DiscordManager {
+	if tmpl == "" {
+		tmpl = "../../../static/discord.tmpl"
+	}
+	d, err := NewDiscord(DiscordOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Endpoint:     endpoint,
+		Room:         "oss",
+		Template:     tmpl,
+		Timeout:      5 * time.Second,
+		RetryMax:     2,
+		RetryWaitMin: 10 * time.Second,
+		RetryWaitMax: 10 * time.Second,
+		Username:     "calert",
+	})
+	require.NoError(t, err)
+	return d
+}
+
+func TestPush(t *testing.T) {
+	var msgs []message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		msgs = append(msgs, msg)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := newTestDiscord(t, server.URL, "")
+	require.NoError(t, d.Push([]alertmgrtmpl.Alert{{
+		Fingerprint: "abc",
+		Status:      "firing",
+		StartsAt:    time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLatency", "severity": "critical"},
+		Annotations: alertmgrtmpl.KV{"summary": "p99 is 2s", "runbook": "https://runbooks/latency", "calert_fallback_from": "prod"},
+	}}))
+
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "**(CRITICAL) Highlatency - Firing**", msgs[0].Content)
+	assert.Equal(t, "calert", msgs[0].Username)
+	require.Len(t, msgs[0].Embeds, 1)
+
+	e := msgs[0].Embeds[0]
+	assert.Equal(t, "[FIRING] HighLatency", e.Title)
+	assert.Equal(t, colourCritical, e.Color)
+	assert.Equal(t, "2026-10-19T09:00:00Z", e.Timestamp)
+	// Annotations added by calert aren't fields.
+	assert.Equal(t, []field{
+		{Name: "runbook", Value: "https://runbooks/latency"},
+		{Name: "summary", Value: "p99 is 2s"},
+	}, e.Fields)
+}
+
+func TestRetryAfter(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.05, "global": false}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The wait in the body is used instead of the 10s backoff.
+	d := newTestDiscord(t, server.URL, "")
+	start := time.Now()
+	require.NoError(t, d.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requestCount))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := newTestDiscord(t, server.URL, "")
+	err := d.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+}
+
+func TestLimits(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "long.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ range .Annotations.SortedPairs }}{{ .Value }}
+{{ end }}`), 0o600))
+	d := newTestDiscord(t, "http://discord", tmpl)
+
+	a := alertmgrtmpl.Alert{
+		Status:      "resolved",
+		Labels:      alertmgrtmpl.KV{"alertname": strings.Repeat("a", 300), "severity": "critical"},
+		Annotations: alertmgrtmpl.KV{},
+	}
+	for i := 0; i < 30; i++ {
+		a.Annotations[fmt.Sprintf("note_%02d", i)] = strings.Repeat("é", 1500)
+	}
+
+	msgs, err := d.prepareMessages(a)
+	require.NoError(t, err)
+	require.Greater(t, len(msgs), 1)
+	for i, msg := range msgs {
+		assert.LessOrEqual(t, utf8.RuneCountInString(msg.Content), maxContentSize)
+		// Only the last message has the embed.
+		assert.Equal(t, i == len(msgs)-1, len(msg.Embeds) == 1)
+	}
+
+	e := msgs[len(msgs)-1].Embeds[0]
+	assert.Equal(t, colourResolved, e.Color)
+	assert.Equal(t, maxTitleSize, utf8.RuneCountInString(e.Title))
+	size := utf8.RuneCountInString(e.Title)
+	for _, f := range e.Fields {
+		assert.Equal(t, maxFieldValueSize, utf8.RuneCountInString(f.Value))
+		size += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	assert.LessOrEqual(t, len(e.Fields), maxFields)
+	assert.LessOrEqual(t, size, maxEmbedSize)
+}
+
+func TestColour(t *testing.T) {
+	tests := map[string]int{
+		"critical": colourCritical,
+		"warning":  colourWarning,
+		"info":     colourInfo,
+		"":         colourDefault,
+	}
+	for severity, want := range tests {
+		assert.Equal(t, want, colour(alertmgrtmpl.Alert{Status: "firing", Labels: alertmgrtmpl.KV{"severity": severity}}), severity)
+	}
+}
+
+func TestMention(t *testing.T) {
+	assert.Equal(t, "<@123>", mention("123"))
+}
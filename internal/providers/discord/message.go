@@ -0,0 +1,159 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Limits of Discord messages.
+// ref. https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	maxContentSize    = 2000
+	maxTitleSize      = 256
+	maxFieldNameSize  = 256
+	maxFieldValueSize = 1024
+	maxFields         = 25
+	maxEmbedSize      = 6000
+)
+
+// mention formats the mention of a Discord user ID, eg `<@123>`.
+func mention(id string) string {
+	return "<@" + id + ">"
+}
+
+// Colours of embeds by the severity of alerts.
+const (
+	colourCritical = 0xED4245
+	colourWarning  = 0xFEE75C
+	colourInfo     = 0x5865F2
+	colourResolved = 0x57F287
+	colourDefault  = 0x95A5A6
+)
+
+// message is the payload of a webhook message.
+// ref. https://discord.com/developers/docs/resources/webhook#execute-webhook
+type message struct {
+	Content   string  `json:"content,omitempty"`
+	Username  string  `json:"username,omitempty"`
+	AvatarURL string  `json:"avatar_url,omitempty"`
+	Embeds    []embed `json:"embeds,omitempty"`
+}
+
+type embed struct {
+	Title     string  `json:"title,omitempty"`
+	Color     int     `json:"color"`
+	Fields    []field `json:"fields,omitempty"`
+	Timestamp string  `json:"timestamp,omitempty"`
+}
+
+type field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// prepareMessages renders the template for the alert as the content of messages,
+// split at Discord's limit, with an embed of the alert in the last message.
+func (m *DiscordManager) prepareMessages(a alertmgrtmpl.Alert) ([]message, error) {
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return nil, err
+	}
+
+	var out []message
+	for _, chunk := range providers.SplitText(strings.TrimSpace(buf.String()), maxContentSize) {
+		out = append(out, message{Content: chunk})
+	}
+	if len(out) == 0 {
+		out = append(out, message{})
+	}
+	out[len(out)-1].Embeds = []embed{newEmbed(a)}
+
+	for i := range out {
+		out[i].Username = m.username
+		out[i].AvatarURL = m.avatarURL
+	}
+
+	return out, nil
+}
+
+// newEmbed returns an embed for the alert, coloured by its severity,
+// with a field for each annotation.
+func newEmbed(a alertmgrtmpl.Alert) embed {
+	e := embed{
+		Title: providers.Truncate(fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), a.Labels["alertname"]), maxTitleSize),
+		Color: colour(a),
+	}
+	if !a.StartsAt.IsZero() {
+		e.Timestamp = a.StartsAt.Format(time.RFC3339)
+	}
+	size := utf8.RuneCountInString(e.Title)
+
+	for _, kv := range a.Annotations.SortedPairs() {
+		// Annotations added by calert are hooks for templates.
+		if strings.HasPrefix(kv.Name, "calert_") || kv.Value == "" {
+			continue
+		}
+		f := field{
+			Name:  providers.Truncate(kv.Name, maxFieldNameSize),
+			Value: providers.Truncate(kv.Value, maxFieldValueSize),
+		}
+		fSize := utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+		if len(e.Fields) == maxFields || size+fSize > maxEmbedSize {
+			break
+		}
+		e.Fields = append(e.Fields, f)
+		size += fSize
+	}
+
+	return e
+}
+
+// colour returns the colour of the embed for the alert.
+func colour(a alertmgrtmpl.Alert) int {
+	if a.Status == "resolved" {
+		return colourResolved
+	}
+	switch strings.ToLower(a.Labels["severity"]) {
+	case "critical", "error", "page":
+		return colourCritical
+	case "warning":
+		return colourWarning
+	case "info":
+		return colourInfo
+	}
+	return colourDefault
+}
+
+// sendMessage pushes out a message to the webhook.
+func (m *DiscordManager) sendMessage(msg message) error {
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	m.lo.Debug("sending alert", "url", m.endpoint, "payload", string(out))
+	resp, err := m.client.Post(m.endpoint, "application/json", bytes.NewBuffer(out))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Webhooks respond with 204 No Content, or 200 OK with `?wait=true`.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from Discord Webhook endpoint", "status", resp.StatusCode, "responseBody", string(body))
+		return fmt.Errorf("non ok response from discord: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+// Package email sends alerts as emails over SMTP.
+package email
+
+import (
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// TLS modes of the connection to the SMTP server.
+const (
+	// TLSModeStartTLS upgrades the connection with STARTTLS, and fails if the server doesn't support it.
+	TLSModeStartTLS = "starttls"
+	// TLSModeTLS connects with implicit TLS, usually on port 465.
+	TLSModeTLS = "tls"
+	// TLSModeNone doesn't encrypt the connection.
+	TLSModeNone = "none"
+)
+
+// Names of the template blocks of emails.
+const (
+	blockSubject = "subject"
+	blockText    = "text"
+	blockHTML    = "html"
+)
+
+type EmailManager struct {
+	lo           *slog.Logger
+	activeAlerts *state.ActiveAlerts
+	dispatcher   *providers.Dispatcher
+	room         string
+	smtp         smtpOpts
+	from         *mail.Address
+	to           []*template.Template
+	cc           []*template.Template
+	msgTmpl      *template.Template
+	retryMax     int
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+}
+
+type EmailOpts struct {
+	Log      *slog.Logger
+	Metrics  *metrics.Manager
+	DryRun   bool
+	Timeout  time.Duration
+	Room     string
+	Template string
+	// ThreadTTL is how long the Message-ID of the first email for an alert
+	// is kept, for the later emails to reply to.
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept for threads. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLSMode is one of TLSModeStartTLS (default), TLSModeTLS or TLSModeNone.
+	TLSMode       string
+	TLSSkipVerify bool
+
+	From string
+	// To and CC are the recipients of emails. Each of them is a template,
+	// which is rendered for the alert and can have a comma separated list.
+	To []string
+	CC []string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewEmail initializes an email provider object.
+func NewEmail(opts EmailOpts) (*EmailManager, error) {
+	if opts.Host == "" || opts.Port == 0 {
+		return nil, fmt.Errorf("host and port are required")
+	}
+	switch opts.TLSMode {
+	case "":
+		opts.TLSMode = TLSModeStartTLS
+	case TLSModeStartTLS, TLSModeTLS, TLSModeNone:
+	default:
+		return nil, fmt.Errorf("invalid tls mode %s, should be %s, %s or %s", opts.TLSMode, TLSModeStartTLS, TLSModeTLS, TLSModeNone)
+	}
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing from address: %w", err)
+	}
+	if len(opts.To) == 0 {
+		return nil, fmt.Errorf("to is required")
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template, which has the blocks of the subject and the bodies.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup(blockSubject) == nil {
+		return nil, fmt.Errorf("template should define the %q block", blockSubject)
+	}
+	if tmpl.Lookup(blockText) == nil && tmpl.Lookup(blockHTML) == nil {
+		return nil, fmt.Errorf("template should define the %q or %q block", blockText, blockHTML)
+	}
+
+	// Parse the templates of the recipients.
+	parseRcpts := func(field string, rcpts []string) ([]*template.Template, error) {
+		out := make([]*template.Template, 0, len(rcpts))
+		for i, r := range rcpts {
+			t, err := template.New(fmt.Sprintf("%s.%d", field, i)).Funcs(templateFuncMap).Parse(r)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s address %q: %w", field, r, err)
+			}
+			out = append(out, t)
+		}
+		return out, nil
+	}
+	to, err := parseRcpts("to", opts.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := parseRcpts("cc", opts.CC)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &EmailManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "email",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "email", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.MessageID(activeAlerts),
+		},
+		room: opts.Room,
+		smtp: smtpOpts{
+			host:          opts.Host,
+			port:          opts.Port,
+			username:      opts.Username,
+			password:      opts.Password,
+			tlsMode:       opts.TLSMode,
+			tlsSkipVerify: opts.TLSSkipVerify,
+			timeout:       opts.Timeout,
+		},
+		from:         from,
+		to:           to,
+		cc:           cc,
+		msgTmpl:      tmpl,
+		retryMax:     opts.RetryMax,
+		retryWaitMin: opts.RetryWaitMin,
+		retryWaitMax: opts.RetryWaitMax,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and sends an email for each of them. Emails
+// for an alert are replies to its first email, so that mail clients thread them.
+// Alerts which couldn't be sent after retries are returned as a *providers.PushError.
+func (m *EmailManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		details, _ := m.activeAlerts.Get(a.Fingerprint)
+
+		msg, err := m.prepareMessage(a, details.MessageID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: msg.body,
+			Send: func() error {
+				if err := m.sendWithRetries(msg); err != nil {
+					return err
+				}
+				// Later emails for the alert reply to the first one.
+				if details.MessageID == "" {
+					m.activeAlerts.SetMessageID(a.Fingerprint, msg.messageID)
+				}
+				return nil
+			},
+		}}, nil
+	})
+}
+
+// sendWithRetries sends the message, retrying with an exponential backoff
+// between RetryWaitMin and RetryWaitMax if it fails.
+func (m *EmailManager) sendWithRetries(msg message) error {
+	var (
+		wait = m.retryWaitMin
+		err  error
+	)
+	for i := 0; i <= m.retryMax; i++ {
+		if i > 0 {
+			m.lo.Debug("retrying email", "room", m.Room(), "wait", wait, "remaining", m.retryMax-i+1, "error", err)
+			time.Sleep(wait)
+			wait = min(wait*2, m.retryWaitMax)
+		}
+		if err = m.sendMessage(msg); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempt(s): %w", m.retryMax+1, err)
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *EmailManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *EmailManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *EmailManager) ID() string {
+	return "email"
+}
@@ -0,0 +1,357 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received is an email received by the sink.
+type received struct {
+	from  string
+	rcpts []string
+	data  string
+	tls   bool
+	user  string
+}
+
+// sink is a local SMTP server which records the emails sent to it.
+type sink struct {
+	sync.Mutex
+	ln     net.Listener
+	tlsCfg *tls.Config
+	mails  []received
+}
+
+// newSink starts a sink, which supports STARTTLS if tlsCfg is set.
+func newSink(t *testing.T, tlsCfg *tls.Config) *sink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &sink{ln: ln, tlsCfg: tlsCfg}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *sink) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *sink) received() []received {
+	s.Lock()
+	defer s.Unlock()
+	return append([]received(nil), s.mails...)
+}
+
+func (s *sink) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		tp  = textproto.NewConn(conn)
+		cur received
+	)
+	tp.PrintfLine("220 localhost ESMTP sink")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.tlsCfg != nil && !cur.tls {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 ready to start tls")
+			tlsConn := tls.Server(conn, s.tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tp, cur.tls = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			// AUTH PLAIN <base64(authzid \0 user \0 pass)>
+			_, resp, _ := strings.Cut(arg, " ")
+			b, _ := base64.StdEncoding.DecodeString(resp)
+			if parts := strings.Split(string(b), "\x00"); len(parts) == 3 && parts[2] == "pass" {
+				cur.user = parts[1]
+				tp.PrintfLine("235 authenticated")
+			} else {
+				tp.PrintfLine("535 invalid credentials")
+			}
+		case "MAIL":
+			cur.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			cur.rcpts = append(cur.rcpts, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			cur.data = string(data)
+			s.Lock()
+			s.mails = append(s.mails, cur)
+			s.Unlock()
+			cur.rcpts = nil
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+// selfSignedTLS returns a TLS config with a self signed certificate for 127.0.0.1.
+func selfSignedTLS(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func newTestEmail(t *testing.T, opts EmailOpts) *EmailManager {
+	opts.Log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	opts.Metrics = metrics.New("calert")
+	opts.Room = "audit"
+	opts.Host = "127.0.0.1"
+	opts.Timeout = 5 * time.Second
+	opts.ThreadTTL = time.Hour
+	if opts.Template == "" {
+		opts.Template = "../../../static/email.tmpl"
+	}
+	if opts.From == "" {
+		opts.From = "calert <calert@example.com>"
+	}
+	if len(opts.To) == 0 {
+		opts.To = []string{"oncall@example.com"}
+	}
+
+	m, err := NewEmail(opts)
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	s := newSink(t, selfSignedTLS(t))
+	m := newTestEmail(t, EmailOpts{
+		Port:          s.port(),
+		Username:      "calert",
+		Password:      "pass",
+		TLSMode:       TLSModeStartTLS,
+		TLSSkipVerify: true,
+		To:            []string{"oncall@example.com", `{{ with .Labels.team }}{{ . }}@example.com{{ end }}`},
+		CC:            []string{`"Audit, Team" <audit@example.com>, sre@example.com`},
+	})
+
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		StartsAt:    time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+		Labels:      alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical", "team": "db", "instance": "db-1"},
+		Annotations: alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	// Alerts without the label are only sent to the other addresses.
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "Other"}}}))
+
+	mails := s.received()
+	require.Len(t, mails, 3)
+	assert.True(t, mails[0].tls)
+	assert.Equal(t, "calert", mails[0].user)
+	assert.Equal(t, "calert@example.com", mails[0].from)
+	assert.Equal(t, []string{"oncall@example.com", "db@example.com", "audit@example.com", "sre@example.com"}, mails[0].rcpts)
+	assert.Equal(t, []string{"oncall@example.com", "audit@example.com", "sre@example.com"}, mails[2].rcpts)
+
+	first, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	require.NoError(t, err)
+	assert.Equal(t, "[CRITICAL] DiskFull on db-1", first.Header.Get("Subject"))
+	assert.Equal(t, `"calert" <calert@example.com>`, first.Header.Get("From"))
+	assert.Equal(t, "<oncall@example.com>, <db@example.com>", first.Header.Get("To"))
+	assert.Equal(t, `"Audit, Team" <audit@example.com>, <sre@example.com>`, first.Header.Get("Cc"))
+	assert.Empty(t, first.Header.Get("In-Reply-To"))
+	messageID := first.Header.Get("Message-ID")
+	assert.True(t, strings.HasSuffix(messageID, "@example.com>"), messageID)
+
+	// The body has both the plain text and HTML parts.
+	mediaType, params, err := mime.ParseMediaType(first.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	mr := multipart.NewReader(first.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "summary: /var is > 90% full"},
+		{"text/html; charset=utf-8", "<td>/var is &gt; 90% full</td>"},
+	} {
+		p, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, want.contentType, p.Header.Get("Content-Type"))
+		b, err := io.ReadAll(p)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), want.body)
+	}
+
+	// The resolved email is a reply to the first one.
+	second, err := mail.ReadMessage(strings.NewReader(mails[1].data))
+	require.NoError(t, err)
+	assert.Equal(t, "Re: [CRITICAL] DiskFull on db-1", second.Header.Get("Subject"))
+	assert.Equal(t, messageID, second.Header.Get("In-Reply-To"))
+	assert.Equal(t, messageID, second.Header.Get("References"))
+	assert.NotEqual(t, messageID, second.Header.Get("Message-ID"))
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, messageID, a.MessageID)
+}
+
+func TestPushTextOnly(t *testing.T) {
+	s := newSink(t, nil)
+
+	tmpl := filepath.Join(t.TempDir(), "text.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ define "subject" }}{{ .Labels.alertname }} ü{{ end }}{{ define "text" }}Status: {{ .Status }}{{ end }}`), 0o600))
+	m := newTestEmail(t, EmailOpts{Port: s.port(), TLSMode: TLSModeNone, Template: tmpl})
+
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "DiskFull"}}}))
+
+	mails := s.received()
+	require.Len(t, mails, 1)
+	assert.False(t, mails[0].tls)
+
+	msg, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "DiskFull ü", subject)
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	b, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Status: firing", strings.TrimSpace(string(b)))
+}
+
+func TestPushHistory(t *testing.T) {
+	s := newSink(t, nil)
+	hist, err := history.New(history.Opts{
+		Log:       slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:   metrics.New("calert"),
+		Dir:       t.TempDir(),
+		Retention: time.Hour,
+	})
+	require.NoError(t, err)
+
+	// The HTML block is recorded if there's no text block.
+	tmpl := filepath.Join(t.TempDir(), "html.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ define "subject" }}{{ .Labels.alertname }}{{ end }}{{ define "html" }}<p>Status: {{ .Status }}</p>{{ end }}`), 0o600))
+	for _, m := range []*EmailManager{
+		newTestEmail(t, EmailOpts{Port: s.port(), TLSMode: TLSModeNone, Shared: providers.Shared{History: hist}}),
+		newTestEmail(t, EmailOpts{Port: s.port(), TLSMode: TLSModeNone, Template: tmpl, Shared: providers.Shared{History: hist}}),
+	} {
+		require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "DiskFull"}}}))
+	}
+
+	entries, err := hist.Query(history.Query{Room: "audit"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	var texts []string
+	for _, e := range entries {
+		assert.Equal(t, history.ResultSent, e.Result)
+		texts = append(texts, e.Text)
+	}
+	assert.Contains(t, texts, "<p>Status: firing</p>")
+	// The text block is recorded rather than the subject.
+	assert.True(t, slices.ContainsFunc(texts, func(s string) bool { return strings.HasPrefix(s, "DiskFull is FIRING") }), texts)
+}
+
+func TestPushError(t *testing.T) {
+	// The sink doesn't support STARTTLS, which is required.
+	s := newSink(t, nil)
+	m := newTestEmail(t, EmailOpts{Port: s.port()})
+
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "smtp server doesn't support STARTTLS")
+	assert.Empty(t, s.received())
+
+	// The first email which is delivered starts the thread.
+	a, ok := m.ActiveAlerts().Get("abc")
+	require.True(t, ok)
+	assert.Empty(t, a.MessageID)
+}
+
+func TestNewEmail(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "subject.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ define "subject" }}{{ .Labels.alertname }}{{ end }}`), 0o600))
+
+	for name, opts := range map[string]EmailOpts{
+		"no body":     {Template: tmpl, To: []string{"oncall@example.com"}},
+		"invalid tls": {TLSMode: "ssl", To: []string{"oncall@example.com"}},
+		"invalid to":  {To: []string{"{{ .Labels.team"}},
+		"no to":       {},
+		"no from":     {From: "calert", To: []string{"oncall@example.com"}},
+	} {
+		opts.Log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		opts.Host, opts.Port = "127.0.0.1", 25
+		if opts.Template == "" {
+			opts.Template = "../../../static/email.tmpl"
+		}
+		if opts.From == "" {
+			opts.From = "calert@example.com"
+		}
+		_, err := NewEmail(opts)
+		assert.Error(t, err, name)
+	}
+}
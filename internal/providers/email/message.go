@@ -0,0 +1,199 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// message is an email, ready to be sent.
+type message struct {
+	messageID string
+	subject   string
+	// body is the text block, or the HTML block if there's no text block.
+	// It's the text recorded in the history.
+	body string
+	// rcpts are the addresses of all the recipients, To and CC.
+	rcpts []string
+	raw   []byte
+}
+
+// prepareMessage renders the template blocks and the recipients for the alert into
+// an email. If inReplyTo is set, the email is a reply to the email with that Message-ID.
+func (m *EmailManager) prepareMessage(a alertmgrtmpl.Alert, inReplyTo string, now time.Time) (message, error) {
+	subject, err := providers.ExecuteBlock(m.msgTmpl, blockSubject, a)
+	if err != nil {
+		return message{}, err
+	}
+	// Keep the subject on one line. Some mail clients only thread emails with the same subject.
+	subject = strings.Join(strings.Fields(subject), " ")
+	if inReplyTo != "" {
+		subject = "Re: " + subject
+	}
+
+	text, err := providers.ExecuteBlock(m.msgTmpl, blockText, a)
+	if err != nil {
+		return message{}, err
+	}
+	html, err := providers.ExecuteBlock(m.msgTmpl, blockHTML, a)
+	if err != nil {
+		return message{}, err
+	}
+
+	to, err := renderAddresses(m.to, a)
+	if err != nil {
+		return message{}, err
+	}
+	if len(to) == 0 {
+		return message{}, fmt.Errorf("no to addresses for the alert")
+	}
+	cc, err := renderAddresses(m.cc, a)
+	if err != nil {
+		return message{}, err
+	}
+
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return message{}, err
+	}
+	msg := message{
+		messageID: fmt.Sprintf("<%s@%s>", uid, domain(m.from.Address)),
+		subject:   subject,
+		body:      text,
+	}
+	if text == "" {
+		msg.body = html
+	}
+
+	var (
+		buf bytes.Buffer
+		h   = textproto.MIMEHeader{}
+	)
+	h.Set("From", m.from.String())
+	h.Set("To", joinAddresses(to))
+	if len(cc) > 0 {
+		h.Set("Cc", joinAddresses(cc))
+	}
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", subject))
+	h.Set("Date", now.Format(time.RFC1123Z))
+	h.Set("Message-ID", msg.messageID)
+	if inReplyTo != "" {
+		h.Set("In-Reply-To", inReplyTo)
+		h.Set("References", inReplyTo)
+	}
+	h.Set("MIME-Version", "1.0")
+
+	switch {
+	case text != "" && html != "":
+		mw := multipart.NewWriter(&buf)
+		h.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary()))
+		writeHeader(&buf, h)
+		// Mail clients show the last part they can render, so HTML goes last.
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", text},
+			{"text/html; charset=utf-8", html},
+		} {
+			w, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return message{}, err
+			}
+			if err := writeQuotedPrintable(w, part.body); err != nil {
+				return message{}, err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return message{}, err
+		}
+	default:
+		body, contentType := text, "text/plain; charset=utf-8"
+		if html != "" {
+			body, contentType = html, "text/html; charset=utf-8"
+		}
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(&buf, h)
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return message{}, err
+		}
+	}
+
+	for _, addr := range append(to, cc...) {
+		msg.rcpts = append(msg.rcpts, addr.Address)
+	}
+	msg.raw = buf.Bytes()
+
+	return msg, nil
+}
+
+// renderAddresses renders the templates of addresses for the alert. Each of them
+// can render to a list of addresses as in RFC 5322, whose display names can have
+// commas when they're quoted, or nothing.
+func renderAddresses(tmpls []*template.Template, a alertmgrtmpl.Alert) ([]*mail.Address, error) {
+	var out []*mail.Address
+	for _, t := range tmpls {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, a); err != nil {
+			return nil, err
+		}
+		// Leading and trailing commas are left by templates which range over values.
+		s := strings.Trim(buf.String(), ", \t\r\n")
+		if s == "" {
+			continue
+		}
+		addrs, err := mail.ParseAddressList(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing addresses %q: %w", s, err)
+		}
+		out = append(out, addrs...)
+	}
+	return out, nil
+}
+
+func joinAddresses(addrs []*mail.Address) string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// domain returns the domain of the address, for Message-IDs.
+func domain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "calert"
+}
+
+// writeHeader writes the header in a stable order, followed by a blank line.
+func writeHeader(buf *bytes.Buffer, h textproto.MIMEHeader) {
+	for _, k := range []string{"From", "To", "Cc", "Subject", "Date", "Message-ID", "In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"} {
+		if v := h.Get(k); v != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
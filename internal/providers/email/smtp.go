@@ -0,0 +1,91 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpOpts are the options of the connection to the SMTP server.
+type smtpOpts struct {
+	host          string
+	port          int
+	username      string
+	password      string
+	tlsMode       string
+	tlsSkipVerify bool
+	timeout       time.Duration
+}
+
+// sendMessage sends the message to its recipients with the SMTP server.
+func (m *EmailManager) sendMessage(msg message) error {
+	var (
+		o      = m.smtp
+		addr   = net.JoinHostPort(o.host, strconv.Itoa(o.port))
+		dialer = &net.Dialer{Timeout: o.timeout}
+		tlsCfg = &tls.Config{ServerName: o.host, InsecureSkipVerify: o.tlsSkipVerify}
+		conn   net.Conn
+		err    error
+	)
+
+	if o.tlsMode == TLSModeTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to smtp server: %w", err)
+	}
+	if o.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(o.timeout))
+	}
+
+	c, err := smtp.NewClient(conn, o.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to smtp server: %w", err)
+	}
+	defer c.Close()
+
+	if o.tlsMode == TLSModeStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("error starting tls: %w", err)
+		}
+	}
+
+	if o.username != "" {
+		// PlainAuth refuses to send credentials over unencrypted connections, except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", o.username, o.password, o.host)); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	m.lo.Debug("sending email", "room", m.Room(), "message_id", msg.messageID, "rcpts", msg.rcpts)
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	for _, r := range msg.rcpts {
+		if err := c.Rcpt(r); err != nil {
+			return fmt.Errorf("error adding recipient %s: %w", r, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
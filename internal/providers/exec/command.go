@@ -0,0 +1,119 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// waitDelay is how long to wait for the output of a command
+// after it's killed, eg if it started children which hold it open.
+const waitDelay = time.Second
+
+// render renders the template for each alert, each of them ending with a newline.
+func (m *ExecManager) render(alerts []alertmgrtmpl.Alert) (string, error) {
+	var out strings.Builder
+	for _, a := range alerts {
+		var buf bytes.Buffer
+		if err := m.msgTmpl.Execute(&buf, a); err != nil {
+			return "", err
+		}
+		out.WriteString(strings.TrimSpace(buf.String()))
+		out.WriteByte('\n')
+	}
+	return out.String(), nil
+}
+
+// run runs the command with the input on stdin and the env vars added to calert's
+// environment, once there's a free slot. The command fails if it exits with a
+// non-zero code or runs past the timeout. Its stderr is logged.
+func (m *ExecManager) run(stdin string, env []string) error {
+	m.sem <- struct{}{}
+	defer func() { <-m.sem }()
+
+	ctx := context.Background()
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := osexec.CommandContext(ctx, m.command, m.args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), env...)
+	cmd.WaitDelay = waitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+
+	m.lo.Debug("ran command", "room", m.Room(), "command", m.command, "duration", time.Since(start), "stdout", stdout.String())
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		if line != "" {
+			m.lo.Warn("command stderr", "room", m.Room(), "command", m.command, "line", line)
+		}
+	}
+
+	var exitErr *osexec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("command timed out after %s", m.timeout)
+	case errors.As(err, &exitErr):
+		return fmt.Errorf("command exited with code %d", exitErr.ExitCode())
+	default:
+		return fmt.Errorf("error running command: %w", err)
+	}
+}
+
+// alertEnv returns the env vars with the fields of the alert. Labels and annotations
+// are CALERT_LABEL_<NAME> and CALERT_ANNOTATION_<NAME>, with uppercased names.
+func alertEnv(room string, a alertmgrtmpl.Alert) []string {
+	env := []string{
+		"CALERT_ROOM=" + room,
+		"CALERT_FINGERPRINT=" + a.Fingerprint,
+		"CALERT_STATUS=" + a.Status,
+		"CALERT_STARTS_AT=" + formatTime(a.StartsAt),
+		"CALERT_ENDS_AT=" + formatTime(a.EndsAt),
+		"CALERT_GENERATOR_URL=" + a.GeneratorURL,
+	}
+	for _, kv := range a.Labels.SortedPairs() {
+		env = append(env, fmt.Sprintf("CALERT_LABEL_%s=%s", strings.ToUpper(kv.Name), kv.Value))
+	}
+	for _, kv := range a.Annotations.SortedPairs() {
+		env = append(env, fmt.Sprintf("CALERT_ANNOTATION_%s=%s", strings.ToUpper(kv.Name), kv.Value))
+	}
+	return env
+}
+
+// batchEnv returns the env vars for a batch of alerts, with the number of
+// alerts and their fingerprints, separated by spaces.
+func batchEnv(room string, alerts []alertmgrtmpl.Alert) []string {
+	fps := make([]string, 0, len(alerts))
+	for _, a := range alerts {
+		fps = append(fps, a.Fingerprint)
+	}
+	return []string{
+		"CALERT_ROOM=" + room,
+		"CALERT_ALERT_COUNT=" + strconv.Itoa(len(alerts)),
+		"CALERT_FINGERPRINTS=" + strings.Join(fps, " "),
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
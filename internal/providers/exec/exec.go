@@ -0,0 +1,200 @@
+// Package exec sends alerts to custom integrations by running a command.
+package exec
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Modes of running the command.
+const (
+	// ModeAlert runs the command once for each alert.
+	ModeAlert = "alert"
+	// ModeBatch runs the command once for all the alerts of a notification.
+	ModeBatch = "batch"
+)
+
+type ExecManager struct {
+	lo         *slog.Logger
+	dispatcher *providers.Dispatcher
+	room       string
+	command    string
+	args       []string
+	mode       string
+	timeout    time.Duration
+	// sem limits the number of commands running at once.
+	sem     chan struct{}
+	msgTmpl *template.Template
+}
+
+type ExecOpts struct {
+	Log      *slog.Logger
+	Metrics  *metrics.Manager
+	DryRun   bool
+	Room     string
+	Template string
+
+	// Command is the path of the command to run, and Args its arguments.
+	Command string
+	Args    []string
+	// Mode is ModeAlert (default) or ModeBatch.
+	Mode string
+	// Timeout is how long the command can run before it's killed. 0 means no timeout.
+	Timeout time.Duration
+	// Concurrency is the maximum number of commands running at once. Defaults to 1.
+	Concurrency int
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewExec initializes an exec provider object.
+func NewExec(opts ExecOpts) (*ExecManager, error) {
+	if opts.Command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = ModeAlert
+	case ModeAlert, ModeBatch:
+	default:
+		return nil, fmt.Errorf("invalid mode %s, should be %s or %s", opts.Mode, ModeAlert, ModeBatch)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			History:  opts.History,
+			Provider: "exec",
+			Room:     opts.Room,
+			DryRun:   opts.DryRun,
+			Breaker:  providers.NewBreaker(opts.Log, opts.Metrics, "exec", opts.Room, opts.BreakerOpts),
+		},
+		room:    opts.Room,
+		command: opts.Command,
+		args:    opts.Args,
+		mode:    opts.Mode,
+		timeout: opts.Timeout,
+		sem:     make(chan struct{}, opts.Concurrency),
+		msgTmpl: tmpl,
+	}, nil
+}
+
+// Push accepts the list of alerts and runs the command for each of them, or once
+// for all of them in the batch mode. Alerts for which the command failed are
+// returned as a *providers.PushError.
+func (m *ExecManager) Push(alerts []alertmgrtmpl.Alert) error {
+	m.lo.Info("dispatching alerts to exec", "count", len(alerts), "mode", m.mode)
+
+	var undelivered providers.Undelivered
+	if m.mode == ModeBatch {
+		m.pushBatch(alerts, &undelivered)
+	} else {
+		m.pushAlerts(alerts, &undelivered)
+	}
+
+	return undelivered.Err()
+}
+
+// pushAlerts runs the command for each alert, up to the concurrency limit at once.
+func (m *ExecManager) pushAlerts(alerts []alertmgrtmpl.Alert, undelivered *providers.Undelivered) {
+	var wg sync.WaitGroup
+	for _, a := range alerts {
+		now := time.Now()
+
+		m.dispatcher.Count()
+
+		stdin, err := m.render([]alertmgrtmpl.Alert{a})
+		if err != nil {
+			m.dispatcher.PrepareError(a, err)
+			continue
+		}
+
+		if m.dispatcher.DryRun {
+			m.dispatcher.DryRunSkip(a, stdin)
+			continue
+		}
+
+		wg.Add(1)
+		go func(a alertmgrtmpl.Alert) {
+			defer wg.Done()
+
+			m.dispatcher.Send(undelivered, a, providers.Message{
+				Text: stdin,
+				Send: func() error { return m.run(stdin, alertEnv(m.Room(), a)) },
+			})
+			m.dispatcher.Duration(now)
+		}(a)
+	}
+	wg.Wait()
+}
+
+// pushBatch runs the command once for all the alerts.
+func (m *ExecManager) pushBatch(alerts []alertmgrtmpl.Alert, undelivered *providers.Undelivered) {
+	now := time.Now()
+
+	for range alerts {
+		m.dispatcher.Count()
+	}
+
+	stdin, err := m.render(alerts)
+	if err != nil {
+		for _, a := range alerts {
+			m.dispatcher.PrepareError(a, err)
+		}
+		return
+	}
+
+	if m.dispatcher.DryRun {
+		for _, a := range alerts {
+			m.dispatcher.DryRunSkip(a, stdin)
+		}
+		return
+	}
+
+	err = m.dispatcher.Call(func() error { return m.run(stdin, batchEnv(m.Room(), alerts)) })
+	for _, a := range alerts {
+		if err != nil {
+			m.dispatcher.SendError(undelivered, a, stdin, err)
+		} else {
+			m.dispatcher.Sent(a, stdin)
+		}
+	}
+	m.dispatcher.Duration(now)
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *ExecManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name.
+func (m *ExecManager) ID() string {
+	return "exec"
+}
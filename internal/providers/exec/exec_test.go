@@ -0,0 +1,138 @@
+package exec
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestExec(t *testing.T, script string, mode string, concurrency int, timeout time.Duration) *ExecManager {
+	m, err := NewExec(ExecOpts{
+		Log:         slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:     metrics.New("calert"),
+		Room:        "sms",
+		Template:    "../../../static/exec.tmpl",
+		Command:     "/bin/sh",
+		Args:        []string{"-c", script},
+		Mode:        mode,
+		Concurrency: concurrency,
+		Timeout:     timeout,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OUT_DIR", dir)
+
+	m := newTestExec(t, `cat > "$OUT_DIR/$CALERT_FINGERPRINT.in" && env | grep ^CALERT_ | sort > "$OUT_DIR/$CALERT_FINGERPRINT.env"`, "", 0, 5*time.Second)
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{
+		{
+			Fingerprint:  "abc",
+			Status:       "firing",
+			Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"},
+			Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+			StartsAt:     time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC),
+			GeneratorURL: "http://prometheus/graph",
+		},
+		{Fingerprint: "def", Status: "resolved"},
+	}))
+
+	in, err := os.ReadFile(filepath.Join(dir, "abc.in"))
+	require.NoError(t, err)
+	assert.Equal(t, "(CRITICAL) Diskfull - Firing\nSummary: /var is > 90% full\n", string(in))
+
+	env, err := os.ReadFile(filepath.Join(dir, "abc.env"))
+	require.NoError(t, err)
+	assert.Equal(t, `CALERT_ANNOTATION_SUMMARY=/var is > 90% full
+CALERT_ENDS_AT=
+CALERT_FINGERPRINT=abc
+CALERT_GENERATOR_URL=http://prometheus/graph
+CALERT_LABEL_ALERTNAME=DiskFull
+CALERT_LABEL_SEVERITY=critical
+CALERT_ROOM=sms
+CALERT_STARTS_AT=2026-10-19T10:00:00Z
+CALERT_STATUS=firing
+`, string(env))
+
+	assert.FileExists(t, filepath.Join(dir, "def.in"))
+}
+
+func TestPushBatch(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("OUT", out)
+
+	m := newTestExec(t, `echo "$CALERT_ALERT_COUNT $CALERT_FINGERPRINTS" > "$OUT" && cat >> "$OUT"`, ModeBatch, 0, 5*time.Second)
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{
+		{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"}},
+		{Fingerprint: "def", Status: "resolved", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "warning"}},
+	}))
+
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "2 abc def\n(CRITICAL) Diskfull - Firing\n(WARNING) Highload - Resolved\n", string(b))
+}
+
+func TestPushError(t *testing.T) {
+	alerts := []alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}, {Fingerprint: "def", Status: "firing"}}
+
+	m := newTestExec(t, `echo "gateway unreachable" >&2; exit 3`, "", 0, 5*time.Second)
+	err := m.Push(alerts)
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 2)
+	assert.ErrorContains(t, err, "command exited with code 3")
+
+	m = newTestExec(t, `exit 1`, ModeBatch, 0, 5*time.Second)
+	err = m.Push(alerts)
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 2)
+
+	// Commands running past the timeout are killed.
+	m = newTestExec(t, `sleep 5`, "", 0, 100*time.Millisecond)
+	start := time.Now()
+	err = m.Push(alerts[:1])
+	assert.ErrorContains(t, err, "command timed out after 100ms")
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestConcurrency(t *testing.T) {
+	alerts := []alertmgrtmpl.Alert{
+		{Fingerprint: "a", Status: "firing"},
+		{Fingerprint: "b", Status: "firing"},
+		{Fingerprint: "c", Status: "firing"},
+		{Fingerprint: "d", Status: "firing"},
+	}
+
+	// 4 commands of 300ms, 2 at once.
+	m := newTestExec(t, `sleep 0.3`, "", 2, 5*time.Second)
+	start := time.Now()
+	require.NoError(t, m.Push(alerts))
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 600*time.Millisecond)
+	assert.Less(t, elapsed, 1200*time.Millisecond)
+}
+
+func TestNewExec(t *testing.T) {
+	opts := ExecOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Template: "../../../static/exec.tmpl",
+	}
+	_, err := NewExec(opts)
+	assert.ErrorContains(t, err, "command is required")
+
+	opts.Command = "/bin/true"
+	opts.Mode = "digest"
+	_, err = NewExec(opts)
+	assert.ErrorContains(t, err, "invalid mode digest")
+}
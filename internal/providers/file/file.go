@@ -0,0 +1,136 @@
+// Package file appends alerts as JSON lines to a file, for audit trails and debugging.
+package file
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Formats of the lines.
+const (
+	// FormatAlert writes the alert as received from Alertmanager.
+	FormatAlert = "alert"
+	// FormatMessage writes the rendered template, with the fingerprint, status and labels of the alert.
+	FormatMessage = "message"
+)
+
+type FileManager struct {
+	lo         *slog.Logger
+	dispatcher *providers.Dispatcher
+	room       string
+	format     string
+	msgTmpl    *template.Template
+
+	// mu guards the file, which is shared by concurrent pushes.
+	mu sync.Mutex
+	w  *rotatingFile
+}
+
+type FileOpts struct {
+	Log      *slog.Logger
+	Metrics  *metrics.Manager
+	DryRun   bool
+	Room     string
+	Template string
+
+	// Path is the path of the file. Its directory is created if it doesn't exist.
+	Path string
+	// Format is FormatAlert (default) or FormatMessage.
+	Format string
+	// MaxSize is the size in bytes after which the file is rotated. 0 means no limit.
+	MaxSize int64
+	// RotateInterval is how often the file is rotated. 0 means it isn't rotated by time.
+	RotateInterval time.Duration
+	// MaxBackups is the number of rotated files kept. 0 keeps all of them.
+	MaxBackups int
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewFile initializes a file provider object.
+func NewFile(opts FileOpts) (*FileManager, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	switch opts.Format {
+	case "":
+		opts.Format = FormatAlert
+	case FormatAlert, FormatMessage:
+	default:
+		return nil, fmt.Errorf("invalid format %s, should be %s or %s", opts.Format, FormatAlert, FormatMessage)
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newRotatingFile(opts.Log, opts.Path, opts.MaxSize, opts.RotateInterval, opts.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			History:  opts.History,
+			Provider: "file",
+			Room:     opts.Room,
+			DryRun:   opts.DryRun,
+			Breaker:  providers.NewBreaker(opts.Log, opts.Metrics, "file", opts.Room, opts.BreakerOpts),
+		},
+		room:    opts.Room,
+		format:  opts.Format,
+		msgTmpl: tmpl,
+		w:       w,
+	}, nil
+}
+
+// Push accepts the list of alerts and appends a line for each of them to the file.
+// Alerts which couldn't be written are returned as a *providers.PushError.
+func (m *FileManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		now := time.Now()
+		line, text, err := m.prepareLine(a, now)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: text,
+			Send: func() error {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				return m.w.write(line, now)
+			},
+		}}, nil
+	})
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *FileManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name.
+func (m *FileManager) ID() string {
+	return "file"
+}
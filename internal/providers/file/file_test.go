@@ -0,0 +1,158 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFile(t *testing.T, path, format string) *FileManager {
+	m, err := NewFile(FileOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Room:     "audit",
+		Template: "../../../static/file.tmpl",
+		Path:     path,
+		Format:   format,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func readLines(t *testing.T, path string) []map[string]any {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var out []map[string]any
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var l map[string]any
+		require.NoError(t, json.Unmarshal(s.Bytes(), &l))
+		out = append(out, l)
+	}
+	require.NoError(t, s.Err())
+	return out
+}
+
+func TestPush(t *testing.T) {
+	alerts := []alertmgrtmpl.Alert{
+		{
+			Fingerprint: "abc",
+			Status:      "firing",
+			Labels:      alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"},
+			Annotations: alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+		},
+		{Fingerprint: "def", Status: "resolved", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "warning"}},
+	}
+
+	// The directory is created.
+	path := filepath.Join(t.TempDir(), "audit", "alerts.jsonl")
+	m := newTestFile(t, path, "")
+	require.NoError(t, m.Push(alerts))
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "audit", lines[0]["room"])
+	assert.NotEmpty(t, lines[0]["time"])
+	alert := lines[0]["alert"].(map[string]any)
+	assert.Equal(t, "abc", alert["fingerprint"])
+	assert.Equal(t, "firing", alert["status"])
+	assert.Equal(t, map[string]any{"alertname": "DiskFull", "severity": "critical"}, alert["labels"])
+	assert.Equal(t, "resolved", lines[1]["alert"].(map[string]any)["status"])
+
+	path = filepath.Join(t.TempDir(), "messages.jsonl")
+	m = newTestFile(t, path, FormatMessage)
+	require.NoError(t, m.Push(alerts))
+	// Lines are appended to the existing file.
+	m = newTestFile(t, path, FormatMessage)
+	require.NoError(t, m.Push(alerts[:1]))
+
+	lines = readLines(t, path)
+	require.Len(t, lines, 3)
+	delete(lines[0], "time")
+	assert.Equal(t, map[string]any{
+		"room":        "audit",
+		"fingerprint": "abc",
+		"status":      "firing",
+		"labels":      map[string]any{"alertname": "DiskFull", "severity": "critical"},
+		"message":     "(CRITICAL) Diskfull - Firing\nSummary: /var is > 90% full",
+	}, lines[0])
+	assert.Equal(t, "(WARNING) Highload - Resolved", lines[1]["message"])
+}
+
+func TestPushError(t *testing.T) {
+	m := newTestFile(t, filepath.Join(t.TempDir(), "alerts.jsonl"), "")
+	m.w.f.Close()
+
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+}
+
+func TestRotate(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		path = filepath.Join(dir, "alerts.jsonl")
+		lo   = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		now  = time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+		line = []byte(`{"n":1}`)
+	)
+
+	// Rotated by size, once the next line doesn't fit.
+	r, err := newRotatingFile(lo, path, 16, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, r.write(line, now))
+	require.NoError(t, r.write(line, now))
+	require.NoError(t, r.write(line, now))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "alerts-2026-10-19T10-00-00.000.jsonl"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n{\"n\":1}\n", string(b))
+
+	// Rotated by time. Backups with the same time don't overwrite each other.
+	r, err = newRotatingFile(lo, path, 0, time.Hour, 0)
+	require.NoError(t, err)
+	r.openedAt = now.Add(-time.Hour)
+	require.NoError(t, r.write(line, now))
+	require.NoError(t, r.write(line, now.Add(time.Minute)))
+	b, err = os.ReadFile(filepath.Join(dir, "alerts-2026-10-19T10-00-00.000.1.jsonl"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n", string(b))
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n{\"n\":1}\n", string(b))
+
+	// An existing file is rotated by the time since its last write.
+	require.NoError(t, os.Chtimes(path, now, now))
+	r = &rotatingFile{lo: lo, path: path, interval: time.Hour}
+	require.NoError(t, r.open(now.Add(30*time.Minute)))
+	assert.Equal(t, now, r.openedAt.UTC())
+	require.NoError(t, r.f.Close())
+
+	// Only the newest backups are kept.
+	require.NoError(t, os.Chtimes(path, now.Add(time.Hour), now.Add(time.Hour)))
+	r, err = newRotatingFile(lo, path, 0, time.Hour, 2)
+	require.NoError(t, err)
+	require.NoError(t, r.write(line, now.Add(2*time.Hour)))
+	matches, err := filepath.Glob(filepath.Join(dir, "alerts-*.jsonl"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "alerts-2026-10-19T10-00-00.000.1.jsonl"),
+		filepath.Join(dir, "alerts-2026-10-19T12-00-00.000.jsonl"),
+	}, matches)
+}
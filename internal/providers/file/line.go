@@ -0,0 +1,58 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// alertLine is a line of the file in FormatAlert.
+type alertLine struct {
+	Time  time.Time          `json:"time"`
+	Room  string             `json:"room"`
+	Alert alertmgrtmpl.Alert `json:"alert"`
+}
+
+// messageLine is a line of the file in FormatMessage.
+type messageLine struct {
+	Time        time.Time       `json:"time"`
+	Room        string          `json:"room"`
+	Fingerprint string          `json:"fingerprint"`
+	Status      string          `json:"status"`
+	Labels      alertmgrtmpl.KV `json:"labels"`
+	Message     string          `json:"message"`
+}
+
+// prepareLine returns the JSON line for the alert, and its text for the history,
+// which is the rendered template in FormatMessage and the line otherwise.
+func (m *FileManager) prepareLine(a alertmgrtmpl.Alert, now time.Time) ([]byte, string, error) {
+	if m.format == FormatAlert {
+		b, err := json.Marshal(alertLine{Time: now, Room: m.Room(), Alert: a})
+		if err != nil {
+			return nil, "", err
+		}
+		return b, string(b), nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return nil, "", err
+	}
+	msg := strings.TrimSpace(buf.String())
+
+	b, err := json.Marshal(messageLine{
+		Time:        now,
+		Room:        m.Room(),
+		Fingerprint: a.Fingerprint,
+		Status:      a.Status,
+		Labels:      a.Labels,
+		Message:     msg,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return b, msg, nil
+}
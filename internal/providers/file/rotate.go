@@ -0,0 +1,191 @@
+package file
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is the format of the time in the names of rotated files,
+// which sorts them by time.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a file which is rotated once it's over its max size or older
+// than the rotation interval. Rotated files are renamed to <name>-<time><ext>,
+// eg alerts-2026-10-19T10-00-00.000.jsonl. It isn't safe for concurrent use.
+type rotatingFile struct {
+	lo         *slog.Logger
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newRotatingFile(lo *slog.Logger, path string, maxSize int64, interval time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		lo:         lo,
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := r.open(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// write appends the line to the file, rotating it first if it's due.
+func (r *rotatingFile) write(line []byte, now time.Time) error {
+	if r.f == nil {
+		if err := r.open(now); err != nil {
+			return err
+		}
+	}
+
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(line))+1 > r.maxSize) ||
+		(r.interval > 0 && now.Sub(r.openedAt) >= r.interval)) {
+		if err := r.rotate(now); err != nil {
+			return fmt.Errorf("error rotating file: %w", err)
+		}
+	}
+
+	// Lines are written with a single write, so they aren't interleaved with
+	// other writers appending to the file.
+	n, err := r.f.Write(append(line, '\n'))
+	r.size += int64(n)
+	return err
+}
+
+// open opens the file for appending, creating it if it doesn't exist.
+// The rotation interval of an existing file counts from its modification time.
+func (r *rotatingFile) open(now time.Time) error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+	r.openedAt = now
+	// An existing file is as old as its last write, so that restarts
+	// don't postpone its rotation by interval.
+	if r.size > 0 && info.ModTime().Before(now) {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// rotate renames the file to a backup, opens a new file and removes the
+// oldest backups over the max.
+func (r *rotatingFile) rotate(now time.Time) error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+
+	backup := r.backupName(now)
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	r.lo.Info("rotated file", "path", r.path, "backup", backup)
+
+	if err := r.open(now); err != nil {
+		return err
+	}
+
+	return r.removeBackups()
+}
+
+// backupName returns a name for a rotated file which doesn't exist yet.
+func (r *rotatingFile) backupName(now time.Time) string {
+	var (
+		ext  = filepath.Ext(r.path)
+		base = strings.TrimSuffix(r.path, ext)
+		name = fmt.Sprintf("%s-%s%s", base, now.UTC().Format(backupTimeFormat), ext)
+	)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%s.%d%s", base, now.UTC().Format(backupTimeFormat), i, ext)
+	}
+}
+
+// removeBackups removes the oldest rotated files over the max backups.
+func (r *rotatingFile) removeBackups() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+
+	var (
+		ext  = filepath.Ext(r.path)
+		base = strings.TrimSuffix(r.path, ext)
+	)
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+
+	// Backups are sorted by their time, and the order they were created
+	// in for the same time, which is the number after it.
+	type backup struct {
+		path string
+		at   time.Time
+		n    int
+	}
+	backups := make([]backup, 0, len(matches))
+	for _, m := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(m, base+"-"), ext)
+		if len(name) < len(backupTimeFormat) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, name[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		b := backup{path: m, at: at}
+		if suffix := name[len(backupTimeFormat):]; suffix != "" {
+			if b.n, err = strconv.Atoi(strings.TrimPrefix(suffix, ".")); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, b)
+	}
+	if len(backups) <= r.maxBackups {
+		return nil
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].at.Equal(backups[j].at) {
+			return backups[i].at.Before(backups[j].at)
+		}
+		return backups[i].n < backups[j].n
+	})
+	for _, b := range backups[:len(backups)-r.maxBackups] {
+		if err := os.Remove(b.path); err != nil {
+			return err
+		}
+		r.lo.Debug("removed rotated file", "path", b.path)
+	}
+
+	return nil
+}
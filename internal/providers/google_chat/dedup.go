@@ -0,0 +1,51 @@
+package google_chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// notificationHash returns a hash of the status, labels and annotations of the alert.
+// Notifications with the same hash render the same message.
+func notificationHash(a alertmgrtmpl.Alert) string {
+	h := sha256.New()
+	h.Write([]byte(a.Status))
+	for _, kv := range []alertmgrtmpl.KV{a.Labels, a.Annotations} {
+		// Separate labels from annotations.
+		h.Write([]byte{0xff})
+		// Names are sorted, so that the hash is stable.
+		for _, k := range kv.Names() {
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write([]byte(kv[k]))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isDuplicate returns whether the notification is the same as the last one delivered for
+// the alert, and the previous notification arrived within the dedup window. The window
+// slides with each repeat, so that repeats are skipped as long as they keep arriving.
+// Duplicates are still sent once the reminder interval has passed since the last delivery.
+func (m *GoogleChatManager) isDuplicate(a alertmgrtmpl.Alert, now time.Time) bool {
+	if m.dedupWindow <= 0 {
+		return false
+	}
+
+	d, ok := m.activeAlerts.Get(a.Fingerprint)
+	if !ok || d.Hash != notificationHash(a) || d.DeliveredAt.IsZero() {
+		return false
+	}
+	if now.Sub(d.LastSeen) > m.dedupWindow {
+		return false
+	}
+	if m.dedupReminder > 0 && now.Sub(d.DeliveredAt) >= m.dedupReminder {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package google_chat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+	"github.com/mr-karan/calert/internal/breaker"
+	"github.com/mr-karan/calert/internal/providers"
+	chatv1 "google.golang.org/api/chat/v1"
+)
+
+// PushDigest renders the digest with the `digest` template block
+// and sends it to the Google Chat space in a new thread.
+func (m *GoogleChatManager) PushDigest(d providers.Digest) error {
+	if m.msgTmpl.Lookup("digest") == nil {
+		return fmt.Errorf("template %s has no digest block", m.msgTmpl.Name())
+	}
+
+	var buf bytes.Buffer
+	if err := m.msgTmpl.ExecuteTemplate(&buf, "digest", d); err != nil {
+		m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="preparing"}`, m.ID(), m.Room()))
+		return err
+	}
+
+	if m.dryRun {
+		m.lo.Info("dry_run is enabled for this room. skipping pushing digest", "room", m.Room())
+		return nil
+	}
+
+	// Every digest goes in its own thread.
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	for _, text := range providers.SplitText(buf.String(), maxMsgSize) {
+		err := m.dispatcher.Call(func() error {
+			return m.sendMessage(chatv1.Message{Text: text}, uid.String())
+		})
+		if errors.Is(err, breaker.ErrOpen) {
+			m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="circuit_open"}`, m.ID(), m.Room()))
+			return err
+		}
+		if err != nil {
+			m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="sending"}`, m.ID(), m.Room()))
+			return err
+		}
+	}
+
+	return nil
+}
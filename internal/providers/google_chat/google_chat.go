@@ -1,159 +1,106 @@
 package google_chat
 
 import (
-	"context"
-	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"path/filepath"
-	"regexp"
-	"strings"
 	"text/template"
 	"time"
 
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
 	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 type GoogleChatManager struct {
 	lo              *slog.Logger
 	metrics         *metrics.Manager
-	activeAlerts    *ActiveAlerts
+	activeAlerts    *state.ActiveAlerts
+	dispatcher      *providers.Dispatcher
 	endpoint        string
 	room            string
 	client          *retryablehttp.Client
+	dedupWindow     time.Duration
+	dedupReminder   time.Duration
 	msgTmpl         *template.Template
 	dryRun          bool
 	threadedReplies bool
 }
 
 type GoogleChatOpts struct {
-	Log             *slog.Logger
-	Metrics         *metrics.Manager
-	DryRun          bool
-	MaxIdleConn     int
-	Timeout         time.Duration
-	ProxyURL        string
-	Endpoint        string
-	Room            string
-	Template        string
-	ThreadTTL       time.Duration
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	Endpoint    string
+	Room        string
+	Template    string
+	ThreadTTL   time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept for threads. 0 means unbounded.
+	MaxActiveAlerts int
+	// DedupWindow skips notifications which are the same as the last delivered one, while
+	// repeats keep arriving within the window of each other. 0 disables deduplication.
+	DedupWindow time.Duration
+	// DedupReminder sends duplicates anyway once this long has passed since the last delivery.
+	DedupReminder   time.Duration
 	ThreadedReplies bool
 	RetryMax        int
 	RetryWaitMin    time.Duration
 	RetryWaitMax    time.Duration
+
+	providers.Shared
+
+	providers.BreakerOpts
 }
 
 // NewGoogleChat initializes a Google Chat provider object.
 func NewGoogleChat(opts GoogleChatOpts) (*GoogleChatManager, error) {
-	transport := &http.Transport{
-		MaxIdleConnsPerHost: opts.MaxIdleConn,
-	}
-
-	// Add a proxy to make upstream requests if specified in config.
-	if opts.ProxyURL != "" {
-		u, err := url.Parse(opts.ProxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing proxy URL: %s", err)
-		}
-		transport.Proxy = http.ProxyURL(u)
-	}
-
 	// Initialise a retryable HTTP Client for communicating with the G-Chat APIs.
-	client := retryablehttp.NewClient()
-	client.RetryMax = opts.RetryMax
-	client.RetryWaitMin = opts.RetryWaitMin
-	client.RetryWaitMax = opts.RetryWaitMax
-	client.HTTPClient.Timeout = opts.Timeout
-	client.HTTPClient.Transport = transport
-	client.Logger = &slogAdapter{logger: opts.Log}
-
-	// Custom CheckRetry policy that also retries on 429 (Too Many Requests).
-	// This is important for Google Chat API which rate limits requests.
-	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
-		// First, check the default retry policy.
-		shouldRetry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
-		if shouldRetry {
-			return true, checkErr
-		}
-
-		// Additionally retry on 429 Too Many Requests.
-		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-			return true, nil
-		}
-
-		return false, nil
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialise the map of active alerts.
-	alerts := make(map[string]AlertDetails, 0)
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
 
 	// Initialise message template functions.
-	templateFuncMap := template.FuncMap{
-		"Title": func(v any) string {
-			s := fmt.Sprintf("%v", v)
-			titleCaser := cases.Title(language.English)
-			return titleCaser.String(s)
-		},
-		"toUpper": func(v any) string {
-			return strings.ToUpper(fmt.Sprintf("%v", v))
-		},
-		"toLower": func(v any) string {
-			return strings.ToLower(fmt.Sprintf("%v", v))
-		},
-		"Contains": func(s, substr any) bool {
-			return strings.Contains(fmt.Sprintf("%v", s), fmt.Sprintf("%v", substr))
-		},
-		"HasPrefix": func(s, prefix any) bool {
-			return strings.HasPrefix(fmt.Sprintf("%v", s), fmt.Sprintf("%v", prefix))
-		},
-		"HasSuffix": func(s, suffix any) bool {
-			return strings.HasSuffix(fmt.Sprintf("%v", s), fmt.Sprintf("%v", suffix))
-		},
-		"Replace": func(s, old, new any) string {
-			return strings.ReplaceAll(fmt.Sprintf("%v", s), fmt.Sprintf("%v", old), fmt.Sprintf("%v", new))
-		},
-		"TrimSpace": func(v any) string {
-			return strings.TrimSpace(fmt.Sprintf("%v", v))
-		},
-		"Default": func(defaultVal, val any) any {
-			if val == nil || fmt.Sprintf("%v", val) == "" {
-				return defaultVal
-			}
-			return val
-		},
-		"reReplaceAll": func(pattern, repl, text string) string {
-			re := regexp.MustCompile(pattern)
-			return re.ReplaceAllString(text, repl)
-		},
-		"CurrentTime": func(location ...string) string {
-			if len(location) == 0 || location[0] == "" {
-				return time.Now().Format("2006-01-02 15:04:05 MST")
-			}
-			loc, err := time.LoadLocation(location[0])
-			if err != nil {
-				return fmt.Sprintf("Error loading timezone: %v", err)
-			}
-			return time.Now().In(loc).Format("2006-01-02 15:04:05 MST")
-		},
-		"ConvertTZ": func(t time.Time, location string) string {
-			loc, err := time.LoadLocation(location)
-			if err != nil {
-				return fmt.Sprintf("Error loading timezone: %v", err)
-			}
-			return t.In(loc).Format("2006-01-02 15:04:05 MST")
-		},
-		"DurationSince": func(t time.Time) string {
-			d := time.Since(t)
-			h := int(d.Hours())
-			m := int(d.Minutes()) % 60
-			s := int(d.Seconds()) % 60
-			return fmt.Sprintf("%dh %dm %ds", h, m, s)
-		},
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.GoogleChat,
+		OnCall:        opts.OnCall,
+	})
+	templateFuncMap["SilenceButtons"] = silenceButtons
+	templateFuncMap["AckButton"] = func(a alertmgrtmpl.Alert) (string, error) {
+		return ackButton(a, opts.Room)
+	}
+	// Ack returns the ack state of the alert, or nil if it hasn't been acknowledged.
+	templateFuncMap["Ack"] = func(fingerprint string) *Ack {
+		a, ok := activeAlerts.Get(fingerprint)
+		if !ok || a.AckedBy == "" {
+			return nil
+		}
+		return &Ack{By: a.AckedBy, At: a.AckedAt}
 	}
 
 	// Load the template.
@@ -163,68 +110,65 @@ func NewGoogleChat(opts GoogleChatOpts) (*GoogleChatManager, error) {
 	}
 
 	mgr := &GoogleChatManager{
-		lo:       opts.Log,
-		metrics:  opts.Metrics,
-		client:   client,
-		endpoint: opts.Endpoint,
-		room:     opts.Room,
-		activeAlerts: &ActiveAlerts{
-			alerts:  alerts,
-			lo:      opts.Log,
-			metrics: opts.Metrics,
-		},
+		lo:              opts.Log,
+		metrics:         opts.Metrics,
+		client:          client,
+		endpoint:        opts.Endpoint,
+		room:            opts.Room,
+		activeAlerts:    activeAlerts,
 		msgTmpl:         tmpl,
 		dryRun:          opts.DryRun,
+		dedupWindow:     opts.DedupWindow,
+		dedupReminder:   opts.DedupReminder,
 		threadedReplies: opts.ThreadedReplies,
 	}
+	mgr.dispatcher = &providers.Dispatcher{
+		Log:          opts.Log,
+		Metrics:      opts.Metrics,
+		History:      opts.History,
+		Provider:     "google_chat",
+		Room:         opts.Room,
+		DryRun:       opts.DryRun,
+		ActiveAlerts: activeAlerts,
+		Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "google_chat", opts.Room, opts.BreakerOpts),
+		ThreadKey: func(a alertmgrtmpl.Alert) string {
+			return activeAlerts.Lookup(a.Fingerprint)
+		},
+		Duplicate: mgr.isDuplicate,
+		// The hash of the delivered notification is kept to skip its repeats.
+		Delivered: func(a alertmgrtmpl.Alert, now time.Time) {
+			activeAlerts.SetDelivered(a.Fingerprint, notificationHash(a), now)
+		},
+	}
+
 	// Start a background worker to cleanup alerts based on TTL mechanism.
-	go mgr.activeAlerts.startPruneWorker(1*time.Hour, opts.ThreadTTL)
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
 
 	return mgr, nil
 }
 
 // Push accepts the list of alerts and dispatches them to Webhook API endpoint.
+// Alerts which couldn't be sent after retries, or were skipped because the
+// circuit breaker is open, are returned as a *providers.PushError.
 func (m *GoogleChatManager) Push(alerts []alertmgrtmpl.Alert) error {
-	m.lo.Info("dispatching alerts to google chat", "count", len(alerts))
-
-	// For each alert, lookup the UUID and send the alert.
-	for _, a := range alerts {
-		now := time.Now()
-
-		m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_total{provider="%s", room="%s"}`, m.ID(), m.Room()))
-
-		// If it's a new alert whose fingerprint isn't in the active alerts map, add it first.
-		if m.activeAlerts.loookup(a.Fingerprint) == "" {
-			m.activeAlerts.add(a)
-		}
-
-		// Prepare a list of messages to send.
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
 		msgs, err := m.prepareMessage(a)
 		if err != nil {
-			m.lo.Error("error preparing message", "error", err)
-			m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="preparing"}`, m.ID(), m.Room()))
-			continue
+			return nil, err
 		}
 
-		// Dispatch an HTTP request for each message.
+		out := make([]providers.Message, 0, len(msgs))
 		for _, msg := range msgs {
-			var threadKey = m.activeAlerts.alerts[a.Fingerprint].UUID.String()
-
-			// Send message to API.
-			if m.dryRun {
-				m.lo.Info("dry_run is enabled for this room. skipping pushing notification", "room", m.Room())
-			} else {
-				if err := m.sendMessage(msg, threadKey); err != nil {
-					m.metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="sending"}`, m.ID(), m.Room()))
-					m.lo.Error("error sending message", "error", err)
-					continue
-				}
-			}
+			out = append(out, providers.Message{
+				Text: msg.Text,
+				Send: func() error { return m.sendMessage(msg, m.activeAlerts.Lookup(a.Fingerprint)) },
+			})
 		}
-		m.metrics.Duration(fmt.Sprintf(`alerts_dispatched_duration_seconds{provider="%s", room="%s"}`, m.ID(), m.Room()), now)
-	}
-
-	return nil
+		return out, nil
+	})
 }
 
 // Room returns the name of room for which this provider is configured.
@@ -232,6 +176,11 @@ func (m *GoogleChatManager) Room() string {
 	return m.room
 }
 
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *GoogleChatManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
 // ID returns the provider name.
 func (m *GoogleChatManager) ID() string {
 	return "google_chat"
@@ -1,8 +1,11 @@
 package google_chat
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -14,10 +17,16 @@ import (
 	"time"
 
 	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/breaker"
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/mentions"
 	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/oncall"
+	"github.com/mr-karan/calert/internal/providers"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	chatv1 "google.golang.org/api/chat/v1"
 )
 
 func TestRetryOn429(t *testing.T) {
@@ -187,68 +196,6 @@ func TestTemplateFunctionHelpers(t *testing.T) {
 	}
 }
 
-func TestActiveAlerts(t *testing.T) {
-	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-
-	t.Run("add and lookup alert", func(t *testing.T) {
-		aa := &ActiveAlerts{
-			alerts: make(map[string]AlertDetails),
-			lo:     lo,
-		}
-
-		alert := alertmgrtmpl.Alert{
-			Fingerprint: "abc123",
-			StartsAt:    time.Now(),
-		}
-
-		err := aa.add(alert)
-		require.NoError(t, err)
-
-		uuid := aa.loookup("abc123")
-		assert.NotEmpty(t, uuid)
-		assert.Len(t, uuid, 36)
-	})
-
-	t.Run("lookup non-existent alert returns empty", func(t *testing.T) {
-		aa := &ActiveAlerts{
-			alerts: make(map[string]AlertDetails),
-			lo:     lo,
-		}
-
-		uuid := aa.loookup("nonexistent")
-		assert.Empty(t, uuid)
-	})
-
-	t.Run("prune removes expired alerts", func(t *testing.T) {
-		m := metrics.New("calert")
-		aa := &ActiveAlerts{
-			alerts:  make(map[string]AlertDetails),
-			lo:      lo,
-			metrics: m,
-		}
-
-		oldAlert := alertmgrtmpl.Alert{
-			Fingerprint: "old",
-			StartsAt:    time.Now().Add(-2 * time.Hour),
-		}
-		newAlert := alertmgrtmpl.Alert{
-			Fingerprint: "new",
-			StartsAt:    time.Now(),
-		}
-
-		aa.add(oldAlert)
-		aa.add(newAlert)
-
-		assert.Len(t, aa.alerts, 2)
-
-		aa.Prune(1 * time.Hour)
-
-		assert.Len(t, aa.alerts, 1)
-		assert.Empty(t, aa.loookup("old"))
-		assert.NotEmpty(t, aa.loookup("new"))
-	})
-}
-
 func TestGoogleChatManager(t *testing.T) {
 	t.Run("ID returns google_chat", func(t *testing.T) {
 		opts := &GoogleChatOpts{
@@ -326,3 +273,440 @@ func TestPrepareMessage(t *testing.T) {
 		assert.Contains(t, msgs[0].Text, "WARNING")
 	})
 }
+
+func TestCircuitBreaker(t *testing.T) {
+	var requestCount int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	m := metrics.New("calert")
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  m,
+		Endpoint: server.URL,
+		Room:     "test",
+		Template: "../../../static/message.tmpl",
+		RetryMax: 0,
+		BreakerOpts: providers.BreakerOpts{
+			BreakerThreshold:    2,
+			BreakerOpenInterval: time.Hour,
+		},
+	})
+	require.NoError(t, err)
+
+	alerts := []alertmgrtmpl.Alert{
+		{Fingerprint: "a1", Status: "firing", StartsAt: time.Now()},
+		{Fingerprint: "a2", Status: "firing", StartsAt: time.Now()},
+		{Fingerprint: "a3", Status: "firing", StartsAt: time.Now()},
+	}
+
+	err = chat.Push(alerts)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+
+	// All alerts should be reported as undelivered, whether they failed
+	// after retries or were skipped by the breaker.
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	require.Len(t, pErr.Alerts, 3)
+	assert.Equal(t, "a1", pErr.Alerts[0].Fingerprint)
+	assert.Equal(t, "a3", pErr.Alerts[2].Fingerprint)
+
+	// Only the first two alerts should have hit the endpoint.
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requestCount))
+	assert.Equal(t, breaker.Open, chat.dispatcher.Breaker.State())
+
+	var buf bytes.Buffer
+	m.FlushMetrics(&buf)
+	assert.Contains(t, buf.String(), `calert_circuit_breaker_state{provider="google_chat", room="test"} 1`)
+}
+
+func TestPushDigest(t *testing.T) {
+	var bodies []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Endpoint: server.URL,
+		Room:     "info_alerts",
+		Template: "../../../static/message.tmpl",
+	})
+	require.NoError(t, err)
+
+	err = chat.PushDigest(providers.Digest{
+		Room:     "info_alerts",
+		Firing:   1,
+		Resolved: 1,
+		Alerts: []providers.DigestAlert{
+			{
+				Alert:    alertmgrtmpl.Alert{Status: "firing", Labels: alertmgrtmpl.KV{"severity": "info", "alertname": "DiskFilling"}},
+				Count:    3,
+				Duration: time.Hour,
+			},
+			{
+				Alert:    alertmgrtmpl.Alert{Status: "resolved", Labels: alertmgrtmpl.KV{"severity": "warning", "alertname": "HighLoad"}},
+				Count:    1,
+				Duration: 5 * time.Minute,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], "Digest for info_alerts: 1 firing, 1 resolved")
+	assert.Contains(t, bodies[0], "(INFO) Diskfilling - Firing (3 notifications, 1h0m0s)")
+	assert.Contains(t, bodies[0], "(WARNING) Highload - Resolved (1 notifications, 5m0s)")
+}
+
+func TestSilenceButtons(t *testing.T) {
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLoad", "instance": "db-1"},
+	}
+
+	out, err := silenceButtons(alert, "1h", "4h")
+	require.NoError(t, err)
+
+	var widget chatv1.GoogleAppsCardV1Widget
+	require.NoError(t, json.Unmarshal([]byte(out), &widget))
+	require.Len(t, widget.ButtonList.Buttons, 2)
+
+	btn := widget.ButtonList.Buttons[1]
+	assert.Equal(t, "Silence 4h", btn.Text)
+	assert.Equal(t, FunctionSilence, btn.OnClick.Action.Function)
+
+	// The silenced alert is identified by its fingerprint.
+	params := make(map[string]string)
+	for _, p := range btn.OnClick.Action.Parameters {
+		params[p.Key] = p.Value
+	}
+	assert.Equal(t, map[string]string{"duration": "4h", "fingerprint": alert.Fingerprint}, params)
+
+	_, err = silenceButtons(alert, "forever")
+	assert.Error(t, err)
+}
+
+func TestParseInteraction(t *testing.T) {
+	t.Run("legacy action parameters", func(t *testing.T) {
+		ev, err := ParseInteraction(strings.NewReader(`{
+			"type": "CARD_CLICKED",
+			"user": {"name": "users/123"},
+			"action": {"actionMethodName": "silence", "parameters": [{"key": "duration", "value": "1h"}]}
+		}`))
+		require.NoError(t, err)
+		assert.Equal(t, "CARD_CLICKED", ev.Type)
+		assert.Equal(t, "silence", ev.Function)
+		assert.Equal(t, "users/123", ev.User)
+		assert.Equal(t, "1h", ev.Parameters["duration"])
+	})
+
+	t.Run("slash command", func(t *testing.T) {
+		ev, err := ParseInteraction(strings.NewReader(`{
+			"type": "MESSAGE",
+			"user": {"name": "users/123", "displayName": "Jane Doe"},
+			"message": {
+				"argumentText": " abc ",
+				"thread": {"name": "spaces/abc/threads/xyz", "threadKey": "key-1"},
+				"annotations": [{"type": "SLASH_COMMAND", "slashCommand": {"commandName": "/ack"}}]
+			}
+		}`))
+		require.NoError(t, err)
+		assert.Equal(t, FunctionAck, ev.Function)
+		assert.Equal(t, "abc", ev.Argument)
+		assert.Equal(t, "key-1", ev.ThreadKey)
+		assert.Equal(t, "Jane Doe", ev.User)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		_, err := ParseInteraction(strings.NewReader("invalid"))
+		assert.Error(t, err)
+	})
+}
+
+func TestAckTemplate(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "ack.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(
+		`{{ with Ack .Fingerprint }}Acked by {{ .By }}{{ else }}{{ AckButton . }}{{ end }}`), 0o600))
+
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Endpoint: "http://test",
+		Room:     "test",
+		Template: tmpl,
+	})
+	require.NoError(t, err)
+
+	alert := alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: time.Now()}
+	require.NoError(t, chat.ActiveAlerts().Add(alert))
+
+	msgs, err := chat.prepareMessage(alert)
+	require.NoError(t, err)
+
+	var widget chatv1.GoogleAppsCardV1Widget
+	require.NoError(t, json.Unmarshal([]byte(msgs[0].Text), &widget))
+	action := widget.ButtonList.Buttons[0].OnClick.Action
+	assert.Equal(t, FunctionAck, action.Function)
+	assert.Equal(t, "room", action.Parameters[1].Key)
+	assert.Equal(t, "test", action.Parameters[1].Value)
+
+	require.NoError(t, chat.ActiveAlerts().Ack("abc", "Jane Doe", time.Now()))
+	msgs, err = chat.prepareMessage(alert)
+	require.NoError(t, err)
+	assert.Equal(t, "Acked by Jane Doe\n", msgs[0].Text)
+
+	// The default template shows who acknowledged the alert.
+	chat, err = NewGoogleChat(GoogleChatOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:  metrics.New("calert"),
+		Endpoint: "http://test",
+		Room:     "test",
+		Template: "../../../static/message.tmpl",
+	})
+	require.NoError(t, err)
+	alert.Labels = alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"}
+	require.NoError(t, chat.ActiveAlerts().Add(alert))
+	msgs, err = chat.prepareMessage(alert)
+	require.NoError(t, err)
+	assert.NotContains(t, msgs[0].Text, "Acked by")
+
+	require.NoError(t, chat.ActiveAlerts().Ack("abc", "Jane Doe", time.Now()))
+	msgs, err = chat.prepareMessage(alert)
+	require.NoError(t, err)
+	assert.Equal(t, "*(CRITICAL) Diskfull - Firing*\n_Acked by Jane Doe_\n\n", msgs[0].Text)
+}
+
+func TestMentionsTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "mentions.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ mentions .Labels.team .Labels.severity }}`), 0o600))
+	file := filepath.Join(dir, "mentions.toml")
+	require.NoError(t, os.WriteFile(file, []byte("[users]\ndb = [\"123\"]\n"), 0o600))
+
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	m, err := mentions.New(mentions.Opts{Log: lo, File: file})
+	require.NoError(t, err)
+
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      lo,
+		Endpoint: "http://test",
+		Room:     "test",
+		Template: tmpl,
+		Shared:   providers.Shared{Mentions: m},
+	})
+	require.NoError(t, err)
+
+	msgs, err := chat.prepareMessage(alertmgrtmpl.Alert{Labels: alertmgrtmpl.KV{"team": "db", "severity": "critical"}})
+	require.NoError(t, err)
+	assert.Equal(t, "<users/123>\n", msgs[0].Text)
+}
+
+func TestOnCallTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "oncall.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ oncall .Labels.team .StartsAt }} {{ mentions (oncall .Labels.team .StartsAt) }}`), 0o600))
+	file := filepath.Join(dir, "db.yml")
+	require.NoError(t, os.WriteFile(file, []byte("rotations: [{start: '2026-10-19 09:00', shift: 168h, users: [users/123]}]"), 0o600))
+
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	r, err := oncall.New(oncall.Opts{
+		Log:     lo,
+		Metrics: metrics.New("calert"),
+		Sources: map[string]oncall.SourceOpts{"db": {File: file}},
+	})
+	require.NoError(t, err)
+
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      lo,
+		Endpoint: "http://test",
+		Room:     "test",
+		Template: tmpl,
+		Shared:   providers.Shared{OnCall: r},
+	})
+	require.NoError(t, err)
+
+	msgs, err := chat.prepareMessage(alertmgrtmpl.Alert{
+		Labels:   alertmgrtmpl.KV{"team": "db"},
+		StartsAt: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "users/123 <users/123>\n", msgs[0].Text)
+}
+
+func TestPushHistory(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	hist, err := history.New(history.Opts{
+		Log:       lo,
+		Metrics:   metrics.New("calert"),
+		Dir:       t.TempDir(),
+		Retention: time.Hour,
+	})
+	require.NoError(t, err)
+
+	chat, err := NewGoogleChat(GoogleChatOpts{
+		Log:      lo,
+		Metrics:  metrics.New("calert"),
+		Endpoint: server.URL,
+		Room:     "test",
+		Template: "../../../static/message.tmpl",
+		Shared:   providers.Shared{History: hist},
+	})
+	require.NoError(t, err)
+
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		StartsAt:    time.Now(),
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLatency"},
+	}
+	require.NoError(t, chat.Push([]alertmgrtmpl.Alert{alert}))
+
+	fail = true
+	alert.Status = "resolved"
+	require.Error(t, chat.Push([]alertmgrtmpl.Alert{alert}))
+
+	out, err := hist.Query(history.Query{Room: "test"})
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+
+	assert.Equal(t, "resolved", out[0].Status)
+	assert.Equal(t, history.ResultFailed, out[0].Result)
+	assert.Contains(t, out[0].Error, "giving up after 1 attempt(s)")
+
+	assert.Equal(t, "firing", out[1].Status)
+	assert.Equal(t, history.ResultSent, out[1].Result)
+	assert.Equal(t, "google_chat", out[1].Provider)
+	assert.Equal(t, "HighLatency", out[1].Labels["alertname"])
+	assert.Contains(t, out[1].Text, "Highlatency - Firing")
+	assert.Equal(t, chat.activeAlerts.Lookup("abc"), out[1].ThreadKey)
+}
+
+func TestDedup(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	newChat := func(window, reminder time.Duration) (*GoogleChatManager, *metrics.Manager) {
+		m := metrics.New("calert")
+		chat, err := NewGoogleChat(GoogleChatOpts{
+			Log:           slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+			Metrics:       m,
+			Endpoint:      server.URL,
+			Room:          "test",
+			Template:      "../../../static/message.tmpl",
+			DedupWindow:   window,
+			DedupReminder: reminder,
+		})
+		require.NoError(t, err)
+		return chat, m
+	}
+	push := func(chat *GoogleChatManager, a alertmgrtmpl.Alert) int32 {
+		before := atomic.LoadInt32(&requestCount)
+		require.NoError(t, chat.Push([]alertmgrtmpl.Alert{a}))
+		return atomic.LoadInt32(&requestCount) - before
+	}
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		StartsAt:    time.Now(),
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLatency"},
+		Annotations: alertmgrtmpl.KV{"summary": "p99 is 2s"},
+	}
+
+	t.Run("skips unchanged notifications", func(t *testing.T) {
+		chat, m := newChat(time.Hour, 0)
+		assert.Equal(t, int32(1), push(chat, alert))
+		assert.Equal(t, int32(0), push(chat, alert))
+
+		// Changes to the status or annotations are sent.
+		changed := alert
+		changed.Annotations = alertmgrtmpl.KV{"summary": "p99 is 3s"}
+		assert.Equal(t, int32(1), push(chat, changed))
+		resolved := changed
+		resolved.Status = "resolved"
+		assert.Equal(t, int32(1), push(chat, resolved))
+		assert.Equal(t, int32(0), push(chat, resolved))
+
+		var buf bytes.Buffer
+		m.FlushMetrics(&buf)
+		assert.Contains(t, buf.String(), `calert_alerts_deduplicated_total{provider="google_chat", room="test"} 2`)
+	})
+
+	// clock replaces the time of notifications, advanced by the tests.
+	clock := func(chat *GoogleChatManager) *time.Time {
+		now := time.Now()
+		chat.dispatcher.Now = func() time.Time { return now }
+		return &now
+	}
+
+	t.Run("sends reminders", func(t *testing.T) {
+		chat, _ := newChat(time.Hour, 2*time.Hour)
+		now := clock(chat)
+		assert.Equal(t, int32(1), push(chat, alert))
+		for range 2 {
+			*now = now.Add(45 * time.Minute)
+			assert.Equal(t, int32(0), push(chat, alert))
+		}
+		// The reminder is measured from the delivery, while repeats keep the window open.
+		*now = now.Add(45 * time.Minute)
+		assert.Equal(t, int32(1), push(chat, alert))
+		*now = now.Add(45 * time.Minute)
+		assert.Equal(t, int32(0), push(chat, alert))
+	})
+
+	t.Run("sends after the window", func(t *testing.T) {
+		chat, _ := newChat(time.Hour, 0)
+		now := clock(chat)
+		assert.Equal(t, int32(1), push(chat, alert))
+		*now = now.Add(61 * time.Minute)
+		assert.Equal(t, int32(1), push(chat, alert))
+	})
+
+	t.Run("repeats within the window extend it", func(t *testing.T) {
+		chat, _ := newChat(time.Hour, 0)
+		now := clock(chat)
+		assert.Equal(t, int32(1), push(chat, alert))
+		for range 4 {
+			*now = now.Add(40 * time.Minute)
+			assert.Equal(t, int32(0), push(chat, alert))
+		}
+	})
+
+	t.Run("dry run isn't a delivery", func(t *testing.T) {
+		chat, _ := newChat(time.Hour, 0)
+		chat.dispatcher.DryRun = true
+		assert.Equal(t, int32(0), push(chat, alert))
+		chat.dispatcher.DryRun = false
+		assert.Equal(t, int32(1), push(chat, alert))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		chat, _ := newChat(0, 0)
+		assert.Equal(t, int32(1), push(chat, alert))
+		assert.Equal(t, int32(1), push(chat, alert))
+	})
+}
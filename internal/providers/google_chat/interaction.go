@@ -0,0 +1,175 @@
+package google_chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	chatv1 "google.golang.org/api/chat/v1"
+)
+
+const (
+	// FunctionSilence is the action function invoked by silence buttons.
+	FunctionSilence = "silence"
+	// FunctionAck is the action function invoked by ack buttons and the `/ack` command.
+	FunctionAck = "ack"
+)
+
+// Ack is the ack state of an alert exposed to templates.
+type Ack struct {
+	By string
+	At time.Time
+}
+
+// Interaction is a Google Chat interaction event, like a card button click.
+type Interaction struct {
+	// Type is the event type, eg `CARD_CLICKED`.
+	Type string
+	// Function is the action function invoked by the button.
+	Function string
+	// Parameters are the action parameters of the button.
+	Parameters map[string]string
+	// User is the display name of the user who interacted.
+	User string
+	// Thread is the resource name of the message's thread.
+	Thread string
+	// ThreadKey is the key of the message's thread, if available.
+	ThreadKey string
+	// Argument is the text after the slash command, eg `/ack <fingerprint>`.
+	Argument string
+}
+
+// ParseInteraction decodes a Google Chat interaction event.
+func ParseInteraction(r io.Reader) (Interaction, error) {
+	var (
+		ev  chatv1.DeprecatedEvent
+		out = Interaction{Parameters: make(map[string]string)}
+	)
+
+	if err := json.NewDecoder(r).Decode(&ev); err != nil {
+		return out, err
+	}
+
+	out.Type = ev.Type
+	if ev.User != nil {
+		out.User = ev.User.DisplayName
+		if out.User == "" {
+			out.User = ev.User.Name
+		}
+	}
+	if ev.Message != nil {
+		if ev.Message.Thread != nil {
+			out.Thread = ev.Message.Thread.Name
+			out.ThreadKey = ev.Message.Thread.ThreadKey
+		}
+
+		// Slash commands are invoked as functions of the same name.
+		for _, a := range ev.Message.Annotations {
+			if a.SlashCommand != nil {
+				out.Function = strings.TrimPrefix(a.SlashCommand.CommandName, "/")
+				out.Argument = strings.TrimSpace(ev.Message.ArgumentText)
+				break
+			}
+		}
+	}
+	if out.ThreadKey == "" {
+		out.ThreadKey = ev.ThreadKey
+	}
+
+	// Newer events carry the action in `common` while older ones use `action`.
+	if ev.Common != nil && ev.Common.InvokedFunction != "" {
+		out.Function = ev.Common.InvokedFunction
+		for k, v := range ev.Common.Parameters {
+			out.Parameters[k] = v
+		}
+	}
+	if ev.Action != nil {
+		if out.Function == "" {
+			out.Function = ev.Action.ActionMethodName
+		}
+		for _, p := range ev.Action.Parameters {
+			if _, ok := out.Parameters[p.Key]; !ok {
+				out.Parameters[p.Key] = p.Value
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// Reply returns the response to an interaction event which
+// posts the text as a new message in the same thread.
+func Reply(text string, thread string) ([]byte, error) {
+	msg := chatv1.Message{
+		Text:           text,
+		ActionResponse: &chatv1.ActionResponse{Type: "NEW_MESSAGE"},
+	}
+	if thread != "" {
+		msg.Thread = &chatv1.Thread{Name: thread}
+	}
+
+	return json.Marshal(msg)
+}
+
+// silenceButtons returns a cardsV2 `buttonList` widget with a button to
+// silence the alert for each of the durations. It's exposed to templates
+// as `SilenceButtons`.
+func silenceButtons(a alertmgrtmpl.Alert, durations ...string) (string, error) {
+	buttons := make([]*chatv1.GoogleAppsCardV1Button, 0, len(durations))
+	for _, d := range durations {
+		if _, err := time.ParseDuration(d); err != nil {
+			return "", fmt.Errorf("invalid silence duration %q: %w", d, err)
+		}
+
+		buttons = append(buttons, &chatv1.GoogleAppsCardV1Button{
+			Text: "Silence " + d,
+			OnClick: &chatv1.GoogleAppsCardV1OnClick{
+				Action: &chatv1.GoogleAppsCardV1Action{
+					Function: FunctionSilence,
+					Parameters: []*chatv1.GoogleAppsCardV1ActionParameter{
+						{Key: "duration", Value: d},
+						{Key: "fingerprint", Value: a.Fingerprint},
+					},
+				},
+			},
+		})
+	}
+
+	out, err := json.Marshal(chatv1.GoogleAppsCardV1Widget{
+		ButtonList: &chatv1.GoogleAppsCardV1ButtonList{Buttons: buttons},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// ackButton returns a cardsV2 `buttonList` widget with a button to acknowledge
+// the alert in the room. It's exposed to templates as `AckButton`.
+func ackButton(a alertmgrtmpl.Alert, room string) (string, error) {
+	out, err := json.Marshal(chatv1.GoogleAppsCardV1Widget{
+		ButtonList: &chatv1.GoogleAppsCardV1ButtonList{
+			Buttons: []*chatv1.GoogleAppsCardV1Button{{
+				Text: "Acknowledge",
+				OnClick: &chatv1.GoogleAppsCardV1OnClick{
+					Action: &chatv1.GoogleAppsCardV1Action{
+						Function: FunctionAck,
+						Parameters: []*chatv1.GoogleAppsCardV1ActionParameter{
+							{Key: "fingerprint", Value: a.Fingerprint},
+							{Key: "room", Value: room},
+						},
+					},
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
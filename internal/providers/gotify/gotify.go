@@ -0,0 +1,258 @@
+// Package gotify sends push notifications with the message API of Gotify servers.
+package gotify
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Range of the priorities of Gotify messages. The Android app doesn't notify
+// for 0, notifies silently from 1 to 3, and with a sound and a pop-up from 8.
+const (
+	PriorityMin = 0
+	PriorityMax = 10
+)
+
+// Names of the template blocks of messages.
+const (
+	blockTitle   = "title"
+	blockMessage = "message"
+	blockClick   = "click"
+)
+
+// defaultPriorities maps the values of the severity label to Gotify priorities.
+var defaultPriorities = map[string]int{
+	"critical": 10,
+	"error":    8,
+	"warning":  5,
+	"info":     2,
+}
+
+type GotifyManager struct {
+	lo               *slog.Logger
+	activeAlerts     *state.ActiveAlerts
+	dispatcher       *providers.Dispatcher
+	endpoint         string
+	appToken         string
+	clientToken      string
+	room             string
+	severityLabel    string
+	priorities       map[string]int
+	defaultPriority  int
+	resolvedPriority int
+	client           *retryablehttp.Client
+	msgTmpl          *template.Template
+}
+
+type GotifyOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	// Endpoint is the base URL of the server, eg https://gotify.example.com.
+	Endpoint string
+	Room     string
+	Template string
+	// ThreadTTL is how long resolved alerts are kept in the active alerts.
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	// AppToken is the token of the application which sends the messages.
+	AppToken string
+	// ClientToken is the token of a client, to delete the earlier message of an
+	// alert when it's notified again or resolved. Optional.
+	ClientToken string
+	// SeverityLabel is the label with the severity of alerts. Defaults to `severity`.
+	SeverityLabel string
+	// Priorities maps the values of the severity label to priorities from 0 to 10,
+	// over the defaults which map critical, error, warning and info to 10, 8, 5 and 2.
+	Priorities map[string]int
+	// DefaultPriority is the priority of alerts whose label isn't mapped.
+	DefaultPriority int
+	// ResolvedPriority is the priority of resolved notifications.
+	ResolvedPriority int
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewGotify initializes a Gotify provider object.
+func NewGotify(opts GotifyOpts) (*GotifyManager, error) {
+	if opts.Endpoint == "" || opts.AppToken == "" {
+		return nil, fmt.Errorf("endpoint and app_token are required")
+	}
+	if opts.SeverityLabel == "" {
+		opts.SeverityLabel = "severity"
+	}
+
+	priorities := make(map[string]int, len(defaultPriorities)+len(opts.Priorities))
+	for k, v := range defaultPriorities {
+		priorities[k] = v
+	}
+	for k, v := range opts.Priorities {
+		priorities[k] = v
+	}
+	for _, v := range append([]int{opts.DefaultPriority, opts.ResolvedPriority}, mapValues(priorities)...) {
+		if v < PriorityMin || v > PriorityMax {
+			return nil, fmt.Errorf("invalid priority %d, should be from %d to %d", v, PriorityMin, PriorityMax)
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template, which has the blocks of the title, message and click URL.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup(blockMessage) == nil {
+		return nil, fmt.Errorf("template should define the %q block", blockMessage)
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &GotifyManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "gotify",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "gotify", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+		},
+		endpoint:         strings.TrimSuffix(opts.Endpoint, "/"),
+		appToken:         opts.AppToken,
+		clientToken:      opts.ClientToken,
+		room:             opts.Room,
+		severityLabel:    opts.SeverityLabel,
+		priorities:       priorities,
+		defaultPriority:  opts.DefaultPriority,
+		resolvedPriority: opts.ResolvedPriority,
+		client:           client,
+		msgTmpl:          tmpl,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and sends a message for each of them. With a client
+// token, the earlier message of the alert is deleted once the new one is sent, so that
+// the notification of a resolved alert replaces the one of the firing alert. Alerts
+// which couldn't be sent after retries are returned as a *providers.PushError.
+func (m *GotifyManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msg, err := m.prepareMessage(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: msg.Message,
+			Send: func() error {
+				id, err := m.sendMessage(msg)
+				if err != nil {
+					return err
+				}
+				m.replaceMessage(a.Fingerprint, id)
+				return nil
+			},
+		}}, nil
+	})
+}
+
+// replaceMessage records the ID of the latest message of the alert, and deletes the
+// earlier one if there's a client token. The new message is already sent, so errors
+// deleting the earlier one are only logged.
+func (m *GotifyManager) replaceMessage(fingerprint, id string) {
+	if a, ok := m.activeAlerts.Get(fingerprint); ok && a.MessageID != "" && m.clientToken != "" {
+		if err := m.deleteMessage(a.MessageID); err != nil {
+			m.lo.Warn("error deleting earlier message", "room", m.Room(), "id", a.MessageID, "error", err)
+		}
+	}
+	m.activeAlerts.SetMessageID(fingerprint, id)
+}
+
+// priority returns the Gotify priority of the alert, from its severity label.
+func (m *GotifyManager) priority(a alertmgrtmpl.Alert) int {
+	if a.Status == "resolved" {
+		return m.resolvedPriority
+	}
+	if p, ok := m.priorities[a.Labels[m.severityLabel]]; ok {
+		return p
+	}
+	return m.defaultPriority
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *GotifyManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *GotifyManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *GotifyManager) ID() string {
+	return "gotify"
+}
+
+func mapValues(m map[string]int) []int {
+	out := make([]int, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
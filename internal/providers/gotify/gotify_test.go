@@ -0,0 +1,154 @@
+package gotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGotify(t *testing.T, endpoint, clientToken string) *GotifyManager {
+	m, err := NewGotify(GotifyOpts{
+		Log:              slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:          metrics.New("calert"),
+		Endpoint:         endpoint,
+		Room:             "phone",
+		Template:         "../../../static/gotify.tmpl",
+		Timeout:          5 * time.Second,
+		ThreadTTL:        time.Hour,
+		RetryWaitMin:     10 * time.Millisecond,
+		RetryWaitMax:     10 * time.Millisecond,
+		AppToken:         "app",
+		ClientToken:      clientToken,
+		Priorities:       map[string]int{"page": 9},
+		DefaultPriority:  5,
+		ResolvedPriority: 2,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+type received struct {
+	method string
+	uri    string
+	token  string
+	body   map[string]any
+}
+
+func newTestServer(t *testing.T, reqs *[]received) *httptest.Server {
+	var id int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := received{method: r.Method, uri: r.URL.RequestURI(), token: r.Header.Get("X-Gotify-Key")}
+		if r.Method == http.MethodDelete {
+			*reqs = append(*reqs, rec)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&rec.body))
+		*reqs = append(*reqs, rec)
+		id++
+		fmt.Fprintf(w, `{"id":%d,"appid":1,"message":"","date":"2026-10-19T10:00:00Z"}`, id)
+	}))
+}
+
+func TestPush(t *testing.T) {
+	var reqs []received
+	server := newTestServer(t, &reqs)
+	defer server.Close()
+
+	m := newTestGotify(t, server.URL+"/", "client")
+	alert := alertmgrtmpl.Alert{
+		Fingerprint:  "abc",
+		Status:       "firing",
+		Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page", "instance": "db-1"},
+		Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full", "calert_escalation_mention": "@oncall"},
+		GeneratorURL: "http://prometheus/graph",
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, {Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "other"}}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, reqs, 4)
+	assert.Equal(t, received{
+		method: http.MethodPost,
+		uri:    "/message",
+		token:  "app",
+		body: map[string]any{
+			"title":    "[FIRING] DiskFull on db-1",
+			"message":  "Summary: /var is > 90% full",
+			"priority": float64(9),
+			"extras": map[string]any{
+				"client::notification": map[string]any{"click": map[string]any{"url": "http://prometheus/graph"}},
+			},
+		},
+	}, reqs[0])
+	assert.Equal(t, float64(5), reqs[1].body["priority"])
+	assert.NotContains(t, reqs[1].body, "extras")
+
+	// The resolved message replaces the firing one.
+	assert.Equal(t, "[RESOLVED] DiskFull on db-1", reqs[2].body["title"])
+	assert.Equal(t, float64(2), reqs[2].body["priority"])
+	assert.Equal(t, received{method: http.MethodDelete, uri: "/message/1", token: "client"}, reqs[3])
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "resolved", a.Status)
+	assert.Equal(t, "3", a.MessageID)
+
+	// Without a client token, earlier messages aren't deleted.
+	reqs = nil
+	m = newTestGotify(t, server.URL, "")
+	alert.Status = "firing"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	require.Len(t, reqs, 2)
+	assert.Equal(t, http.MethodPost, reqs[1].method)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"Unauthorized","errorCode":401,"errorDescription":"you need to provide a valid access token or user credentials to access this api"}`))
+	}))
+	defer server.Close()
+
+	m := newTestGotify(t, server.URL, "")
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "401: you need to provide a valid access token")
+}
+
+func TestNewGotify(t *testing.T) {
+	opts := GotifyOpts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:    metrics.New("calert"),
+		Template:   "../../../static/gotify.tmpl",
+		Endpoint:   "http://gotify",
+		Priorities: map[string]int{"page": 11},
+	}
+	_, err := NewGotify(opts)
+	assert.ErrorContains(t, err, "endpoint and app_token are required")
+
+	opts.AppToken = "app"
+	_, err = NewGotify(opts)
+	assert.ErrorContains(t, err, "invalid priority 11")
+
+	// The message block is required.
+	opts.Priorities = nil
+	opts.Template = "../../../static/pagerduty.tmpl"
+	_, err = NewGotify(opts)
+	assert.ErrorContains(t, err, `should define the "message" block`)
+}
@@ -0,0 +1,137 @@
+package gotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// message is the payload of the request to send a message.
+// ref. https://gotify.net/api-docs#/message/createMessage
+type message struct {
+	Title    string         `json:"title,omitempty"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// response is the response of the API.
+type response struct {
+	ID               int64  `json:"id"`
+	ErrorDescription string `json:"errorDescription"`
+}
+
+// errNotFound is returned for requests to messages which don't exist.
+var errNotFound = errors.New("non ok response from gotify: 404")
+
+// prepareMessage returns the message of the alert, with the rendered blocks of the template.
+func (m *GotifyManager) prepareMessage(a alertmgrtmpl.Alert) (message, error) {
+	text, err := providers.ExecuteBlock(m.msgTmpl, blockMessage, a)
+	if err != nil {
+		return message{}, err
+	}
+	title, err := providers.ExecuteBlock(m.msgTmpl, blockTitle, a)
+	if err != nil {
+		return message{}, err
+	}
+	click, err := providers.ExecuteBlock(m.msgTmpl, blockClick, a)
+	if err != nil {
+		return message{}, err
+	}
+
+	msg := message{
+		Title:    strings.Join(strings.Fields(title), " "),
+		Message:  text,
+		Priority: m.priority(a),
+	}
+	// The clients open the URL when the notification is clicked.
+	// ref. https://gotify.net/docs/msgextras#clientnotification
+	if click != "" {
+		msg.Extras = map[string]any{
+			"client::notification": map[string]any{
+				"click": map[string]string{"url": click},
+			},
+		}
+	}
+
+	return msg, nil
+}
+
+// sendMessage sends the message with the app token and returns its ID.
+func (m *GotifyManager) sendMessage(msg message) (string, error) {
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := m.endpoint + "/message"
+	m.lo.Debug("sending message", "url", endpoint, "payload", string(out))
+	req, err := retryablehttp.NewRequest(http.MethodPost, endpoint, out)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", m.appToken)
+
+	r, err := m.do(req)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(r.ID, 10), nil
+}
+
+// deleteMessage deletes the message with the client token. Messages
+// which were already deleted, eg by the user, don't fail.
+func (m *GotifyManager) deleteMessage(id string) error {
+	endpoint := fmt.Sprintf("%s/message/%s", m.endpoint, id)
+	m.lo.Debug("deleting message", "url", endpoint)
+	req, err := retryablehttp.NewRequest(http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gotify-Key", m.clientToken)
+
+	_, err = m.do(req)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do sends the request and decodes the response.
+func (m *GotifyManager) do(req *retryablehttp.Request) (response, error) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	var r response
+	if resp.StatusCode != http.StatusOK {
+		m.lo.Debug("Non OK HTTP Response received from Gotify", "status", resp.StatusCode, "responseBody", string(body))
+		if resp.StatusCode == http.StatusNotFound {
+			return response{}, errNotFound
+		}
+		if err := json.Unmarshal(body, &r); err == nil && r.ErrorDescription != "" {
+			return response{}, fmt.Errorf("non ok response from gotify: %d: %s", resp.StatusCode, r.ErrorDescription)
+		}
+		return response{}, fmt.Errorf("non ok response from gotify: %d", resp.StatusCode)
+	}
+
+	// Deleting a message returns an empty body.
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &r); err != nil {
+			return response{}, fmt.Errorf("error decoding response from gotify: %w", err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,134 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+// retryAfterHeader carries the wait parsed by HTTPOpts.RetryAfter from
+// CheckRetry to Backoff, as the response body is drained in between.
+const retryAfterHeader = "X-Calert-Retry-After"
+
+// HTTPOpts represents the options for the HTTP client of a provider.
+type HTTPOpts struct {
+	Log          *slog.Logger
+	MaxIdleConn  int
+	Timeout      time.Duration
+	ProxyURL     string
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+
+	// Redact lists secrets which are part of request URLs, eg API tokens,
+	// to keep them out of the client's logs. Optional.
+	Redact []string
+
+	// RetryAfter optionally parses how long to wait before retrying
+	// a 429 response, eg from the body. Optional.
+	RetryAfter func(resp *http.Response) (time.Duration, bool)
+}
+
+// NewHTTPClient returns a retryable HTTP client for communicating with provider APIs.
+// It retries on 429 (Too Many Requests) as well, as provider APIs rate limit requests.
+func NewHTTPClient(opts HTTPOpts) (*retryablehttp.Client, error) {
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: opts.MaxIdleConn,
+	}
+
+	// Add a proxy to make upstream requests if specified in config.
+	if opts.ProxyURL != "" {
+		u, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy URL: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = opts.RetryMax
+	client.RetryWaitMin = opts.RetryWaitMin
+	client.RetryWaitMax = opts.RetryWaitMax
+	client.HTTPClient.Timeout = opts.Timeout
+	client.HTTPClient.Transport = transport
+	logger := &slogAdapter{logger: opts.Log}
+	if len(opts.Redact) > 0 {
+		pairs := make([]string, 0, len(opts.Redact)*2)
+		for _, secret := range opts.Redact {
+			if secret == "" {
+				continue
+			}
+			pairs = append(pairs, secret, "<redacted>")
+		}
+		logger.redact = strings.NewReplacer(pairs...)
+	}
+	client.Logger = logger
+
+	// Custom CheckRetry policy that also retries on 429 (Too Many Requests).
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// Retry on 429 Too Many Requests, after parsing how long to wait.
+		if err == nil && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			if opts.RetryAfter != nil {
+				if wait, ok := opts.RetryAfter(resp); ok {
+					resp.Header.Set(retryAfterHeader, wait.String())
+				}
+			}
+			return true, nil
+		}
+
+		// Otherwise, fall back to the default retry policy.
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+
+	// Wait for as long as the API asked to, if the response says so.
+	client.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		if resp != nil {
+			if wait, err := time.ParseDuration(resp.Header.Get(retryAfterHeader)); err == nil {
+				return wait
+			}
+		}
+		return retryablehttp.DefaultBackoff(min, max, attemptNum, resp)
+	}
+
+	return client, nil
+}
+
+// RetryAfterJSON returns a HTTPOpts.RetryAfter which parses the time to wait from
+// the number at the path of fields in the JSON body of the response, in units of unit,
+// eg RetryAfterJSON(time.Millisecond, "retry_after_ms"). The body is left readable.
+func RetryAfterJSON(unit time.Duration, path ...string) func(resp *http.Response) (time.Duration, bool) {
+	return func(resp *http.Response) (time.Duration, bool) {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, false
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return 0, false
+		}
+		for _, k := range path {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return 0, false
+			}
+			v = obj[k]
+		}
+		n, ok := v.(float64)
+		if !ok || n <= 0 {
+			return 0, false
+		}
+
+		return time.Duration(n * float64(unit)), true
+	}
+}
@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryAfter(t *testing.T) {
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPOpts{
+		Log:          slog.Default(),
+		Timeout:      5 * time.Second,
+		RetryMax:     1,
+		RetryWaitMin: 10 * time.Second,
+		RetryWaitMax: 10 * time.Second,
+		RetryAfter: func(resp *http.Response) (time.Duration, bool) {
+			return 10 * time.Millisecond, true
+		},
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requestCount))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	client, err := NewHTTPClient(HTTPOpts{
+		Log:    slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Redact: []string{"s3cret", ""},
+	})
+	require.NoError(t, err)
+	logger := client.Logger.(*slogAdapter)
+
+	logger.Debug("performing request", "url", "https://api/bots3cret/send", "attempt", 1)
+	logger.Error("request failed", "error", errors.New("GET https://api/bots3cret/send: timeout"))
+	logger.Printf("retrying %s", "https://api/bots3cret/send")
+
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.Contains(t, buf.String(), "https://api/bot<redacted>/send")
+	assert.Contains(t, buf.String(), "attempt=1")
+}
+
+func TestRetryAfterJSON(t *testing.T) {
+	resp := func(body string) *http.Response {
+		return &http.Response{Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	r := resp(`{"parameters": {"retry_after": 2}}`)
+	wait, ok := RetryAfterJSON(time.Second, "parameters", "retry_after")(r)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+	// The body can still be read.
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"parameters": {"retry_after": 2}}`, string(b))
+
+	wait, ok = RetryAfterJSON(time.Second, "retry_after")(resp(`{"retry_after": 0.05}`))
+	assert.True(t, ok)
+	assert.Equal(t, 50*time.Millisecond, wait)
+
+	for _, body := range []string{`{"retry_after": 0}`, `{"retry_after": "1"}`, `{}`, `[]`, `invalid`} {
+		_, ok = RetryAfterJSON(time.Second, "retry_after")(resp(body))
+		assert.False(t, ok, body)
+	}
+}
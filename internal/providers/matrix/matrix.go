@@ -0,0 +1,191 @@
+// Package matrix sends alerts to Matrix rooms with the client-server API.
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+type MatrixManager struct {
+	lo           *slog.Logger
+	activeAlerts *state.ActiveAlerts
+	dispatcher   *providers.Dispatcher
+	endpoint     string
+	roomID       string
+	accessToken  string
+	msgType      string
+	room         string
+	client       *retryablehttp.Client
+	msgTmpl      *template.Template
+}
+
+type MatrixOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	// Endpoint is the base URL of the homeserver, eg https://matrix.example.com.
+	Endpoint  string
+	Room      string
+	Template  string
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept for threads. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	// RoomID is the ID of the Matrix room, eg !abc:example.com.
+	RoomID      string
+	AccessToken string
+	// MsgType is the msgtype of the events, m.text or m.notice. Defaults to m.text.
+	MsgType string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewMatrix initializes a Matrix provider object.
+func NewMatrix(opts MatrixOpts) (*MatrixManager, error) {
+	if opts.RoomID == "" || opts.AccessToken == "" {
+		return nil, fmt.Errorf("room_id and access_token are required")
+	}
+	switch opts.MsgType {
+	case "":
+		opts.MsgType = msgTypeText
+	case msgTypeText, msgTypeNotice:
+	default:
+		return nil, fmt.Errorf("invalid msgtype %s, should be %s or %s", opts.MsgType, msgTypeText, msgTypeNotice)
+	}
+
+	// Homeservers rate limit requests and send the time to wait in the body of 429 responses.
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+		// ref. https://spec.matrix.org/v1.11/client-server-api/#rate-limiting
+		RetryAfter: providers.RetryAfterJSON(time.Millisecond, "retry_after_ms"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mention,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &MatrixManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "matrix",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "matrix", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.MessageID(activeAlerts),
+		},
+		endpoint:    strings.TrimSuffix(opts.Endpoint, "/"),
+		roomID:      opts.RoomID,
+		accessToken: opts.AccessToken,
+		msgType:     opts.MsgType,
+		room:        opts.Room,
+		client:      client,
+		msgTmpl:     tmpl,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and dispatches them to the room. Events for
+// an alert are sent in the thread of its first event. Alerts which couldn't be
+// sent after retries are returned as a *providers.PushError.
+func (m *MatrixManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msg, err := m.prepareMessage(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: msg.Body,
+			Send: func() error { return m.sendThreaded(a.Fingerprint, msg) },
+		}}, nil
+	})
+}
+
+// sendThreaded sends the message in the thread of the first event for the alert.
+// The ID of the first event is kept in the active alerts.
+func (m *MatrixManager) sendThreaded(fingerprint string, msg message) error {
+	a, _ := m.activeAlerts.Get(fingerprint)
+	if a.MessageID != "" {
+		msg.RelatesTo = newThreadRelation(a.MessageID)
+	}
+
+	id, err := m.sendMessage(msg)
+	if err != nil {
+		return err
+	}
+	if a.MessageID == "" {
+		m.activeAlerts.SetMessageID(fingerprint, id)
+	}
+
+	return nil
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *MatrixManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *MatrixManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *MatrixManager) ID() string {
+	return "matrix"
+}
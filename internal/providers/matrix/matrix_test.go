@@ -0,0 +1,148 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMatrix(t *testing.T, endpoint string) *MatrixManager {
+	m, err := NewMatrix(MatrixOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Endpoint:     endpoint,
+		Room:         "internal",
+		Template:     "../../../static/matrix.tmpl",
+		Timeout:      5 * time.Second,
+		ThreadTTL:    time.Hour,
+		RetryMax:     1,
+		RetryWaitMin: 10 * time.Second,
+		RetryWaitMax: 10 * time.Second,
+		RoomID:       "!alerts:example.com",
+		AccessToken:  "token",
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	var (
+		msgs   []message
+		txnIDs = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		txnID, ok := strings.CutPrefix(r.URL.EscapedPath(), "/_matrix/client/v3/rooms/%21alerts:example.com/send/m.room.message/")
+		require.True(t, ok, r.URL.EscapedPath())
+		txnIDs[txnID] = true
+
+		var msg message
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		msgs = append(msgs, msg)
+
+		json.NewEncoder(w).Encode(response{EventID: fmt.Sprintf("$event%d", len(msgs))})
+	}))
+	defer server.Close()
+
+	m := newTestMatrix(t, server.URL+"/")
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Labels:      alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"},
+		Annotations: alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "def", Status: "firing"}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, msgs, 3)
+	assert.Len(t, txnIDs, 3)
+	assert.Equal(t, message{
+		MsgType:       msgTypeText,
+		Body:          "(CRITICAL) Diskfull - Firing\nsummary: /var is > 90% full",
+		Format:        formatHTML,
+		FormattedBody: "<b>(CRITICAL) Diskfull - Firing</b><br>\n<i>summary</i>: /var is &gt; 90% full<br>",
+	}, msgs[0])
+	// Only later events for the same alert are in the thread of the first one.
+	assert.Nil(t, msgs[1].RelatesTo)
+	assert.Equal(t, &relation{
+		RelType:       "m.thread",
+		EventID:       "$event1",
+		IsFallingBack: true,
+		InReplyTo:     &inReplyTo{EventID: "$event1"},
+	}, msgs[2].RelatesTo)
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "$event1", a.MessageID)
+}
+
+func TestRetryAfter(t *testing.T) {
+	var (
+		requestCount int32
+		txnIDs       = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txnIDs[r.URL.Path] = true
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode": "M_LIMIT_EXCEEDED", "error": "Too many requests", "retry_after_ms": 50}`))
+			return
+		}
+		json.NewEncoder(w).Encode(response{EventID: "$event"})
+	}))
+	defer server.Close()
+
+	// The wait in the body is used instead of the 10s backoff.
+	m := newTestMatrix(t, server.URL)
+	start := time.Now()
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requestCount))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	// Retries have the same transaction ID, so the homeserver doesn't send duplicates.
+	assert.Len(t, txnIDs, 1)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errcode": "M_FORBIDDEN", "error": "User not in room"}`))
+	}))
+	defer server.Close()
+
+	m := newTestMatrix(t, server.URL)
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "M_FORBIDDEN: User not in room")
+}
+
+func TestPlainText(t *testing.T) {
+	assert.Equal(t, "Title\nline one\nline two", plainText("<h3>Title</h3><p>line <i>one</i></p>line two<br/>"))
+	assert.Equal(t, "a < b & c", plainText("a &lt; b &amp; c"))
+}
+
+func TestMention(t *testing.T) {
+	want := `<a href="https://matrix.to/#/@alice:example.org">@alice:example.org</a>`
+	assert.Equal(t, want, mention("@alice:example.org"))
+	assert.Equal(t, want, mention("alice:example.org"))
+	assert.Equal(t, "@alice:example.org", plainText(want))
+}
@@ -0,0 +1,152 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	msgTypeText   = "m.text"
+	msgTypeNotice = "m.notice"
+
+	// formatHTML is the format of the formatted_body of messages.
+	formatHTML = "org.matrix.custom.html"
+)
+
+var (
+	// reLineBreak matches the HTML tags which end a line in the plain text body,
+	// along with the newline after them in the template, if any.
+	reLineBreak = regexp.MustCompile(`(?i)(<br\s*/?>|</(p|div|li|h[1-6]|pre|blockquote)>)\n?`)
+	// reTag matches the other HTML tags, which are stripped from the plain text body.
+	reTag = regexp.MustCompile(`<[^>]*>`)
+)
+
+// mention formats the mention of a Matrix user ID (eg `@alice:example.org`) as a
+// link, which is the user ID itself in the plain text body.
+// ref. https://spec.matrix.org/v1.11/client-server-api/#user-and-room-mentions
+func mention(id string) string {
+	id = "@" + strings.TrimPrefix(id, "@")
+	return fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, url.PathEscape(id), html.EscapeString(id))
+}
+
+// message is the content of an m.room.message event.
+// ref. https://spec.matrix.org/v1.11/client-server-api/#mroommessage
+type message struct {
+	MsgType       string    `json:"msgtype"`
+	Body          string    `json:"body"`
+	Format        string    `json:"format"`
+	FormattedBody string    `json:"formatted_body"`
+	RelatesTo     *relation `json:"m.relates_to,omitempty"`
+}
+
+// relation relates an event to the root of its thread.
+// ref. https://spec.matrix.org/v1.11/client-server-api/#threading
+type relation struct {
+	RelType       string     `json:"rel_type"`
+	EventID       string     `json:"event_id"`
+	IsFallingBack bool       `json:"is_falling_back"`
+	InReplyTo     *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// response is the response of the homeserver.
+type response struct {
+	EventID string `json:"event_id"`
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+// newThreadRelation returns a relation to the thread of the root event. Clients
+// without threads show the event as a reply to the root event instead.
+func newThreadRelation(root string) *relation {
+	return &relation{
+		RelType:       "m.thread",
+		EventID:       root,
+		IsFallingBack: true,
+		InReplyTo:     &inReplyTo{EventID: root},
+	}
+}
+
+// prepareMessage renders the template for the alert as the HTML formatted_body
+// of a message, with its plain text as the body.
+func (m *MatrixManager) prepareMessage(a alertmgrtmpl.Alert) (message, error) {
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return message{}, err
+	}
+	formatted := strings.TrimSpace(buf.String())
+
+	return message{
+		MsgType:       m.msgType,
+		Body:          plainText(formatted),
+		Format:        formatHTML,
+		FormattedBody: formatted,
+	}, nil
+}
+
+// plainText returns the text of the HTML, for clients which don't render HTML.
+func plainText(s string) string {
+	s = reLineBreak.ReplaceAllString(s, "\n")
+	s = reTag.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// sendMessage sends the message as an event to the room, and returns the ID of the event.
+// ref. https://spec.matrix.org/v1.11/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
+func (m *MatrixManager) sendMessage(msg message) (string, error) {
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	// The transaction ID makes retries of the request idempotent.
+	txnID, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", m.endpoint, url.PathEscape(m.roomID), txnID)
+
+	m.lo.Debug("sending alert", "url", endpoint, "payload", string(out))
+	req, err := retryablehttp.NewRequest(http.MethodPut, endpoint, out)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.accessToken)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil || resp.StatusCode != http.StatusOK || r.EventID == "" {
+		m.lo.Debug("Non OK HTTP Response received from Matrix homeserver", "status", resp.StatusCode, "responseBody", string(body))
+		if r.ErrCode != "" {
+			return "", fmt.Errorf("non ok response from matrix: %d: %s: %s", resp.StatusCode, r.ErrCode, r.Error)
+		}
+		return "", fmt.Errorf("non ok response from matrix: %d", resp.StatusCode)
+	}
+
+	return r.EventID, nil
+}
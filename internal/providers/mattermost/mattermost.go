@@ -0,0 +1,208 @@
+// Package mattermost sends alerts to Mattermost or Rocket.Chat channels with
+// incoming webhooks, which take Slack compatible attachments.
+package mattermost
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+type MattermostManager struct {
+	lo           *slog.Logger
+	activeAlerts *state.ActiveAlerts
+	dispatcher   *providers.Dispatcher
+	endpoint     string
+	room         string
+	channel      string
+	username     string
+	iconURL      string
+	client       *retryablehttp.Client
+	msgTmpl      *template.Template
+
+	// Messages are posted with the REST API instead of the webhook if there's an
+	// API token, so that they can be threaded with the ID of the first post.
+	serverURL string
+	apiToken  string
+	channelID string
+}
+
+type MattermostOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	Endpoint    string
+	Room        string
+	Template    string
+	ThreadTTL   time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept for threads. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	// Channel, Username and IconURL override the webhook's defaults. Optional.
+	Channel  string
+	Username string
+	IconURL  string
+
+	// APIToken enables threading with `root_id`, by creating posts with the
+	// Mattermost REST API in ChannelID. ServerURL defaults to the origin of
+	// the Endpoint. Optional.
+	APIToken  string
+	ChannelID string
+	ServerURL string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewMattermost initializes a Mattermost provider object.
+func NewMattermost(opts MattermostOpts) (*MattermostManager, error) {
+	if opts.APIToken != "" {
+		if opts.ChannelID == "" {
+			return nil, fmt.Errorf("channel_id is required with an api_token")
+		}
+		if opts.ServerURL == "" {
+			u, err := url.Parse(opts.Endpoint)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("error parsing server URL from endpoint: %v", err)
+			}
+			opts.ServerURL = u.Scheme + "://" + u.Host
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &MattermostManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "mattermost",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "mattermost", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.MessageID(activeAlerts),
+		},
+		endpoint:  opts.Endpoint,
+		room:      opts.Room,
+		channel:   opts.Channel,
+		username:  opts.Username,
+		iconURL:   opts.IconURL,
+		client:    client,
+		msgTmpl:   tmpl,
+		serverURL: opts.ServerURL,
+		apiToken:  opts.APIToken,
+		channelID: opts.ChannelID,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and dispatches them to the webhook, or as replies
+// to the first post for the alert if threading is enabled. Alerts which couldn't
+// be sent after retries are returned as a *providers.PushError.
+func (m *MattermostManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msg, err := m.prepareMessage(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: msg.Attachments[0].Text,
+			Send: func() error {
+				if m.apiToken != "" {
+					return m.sendThreaded(a.Fingerprint, msg)
+				}
+				return m.sendMessage(msg)
+			},
+		}}, nil
+	})
+}
+
+// sendThreaded creates a post for the message, as a reply to the first post
+// for the alert. The ID of the first post is kept in the active alerts.
+func (m *MattermostManager) sendThreaded(fingerprint string, msg message) error {
+	a, _ := m.activeAlerts.Get(fingerprint)
+
+	id, err := m.createPost(msg, a.MessageID)
+	if err != nil {
+		return err
+	}
+	if a.MessageID == "" {
+		m.activeAlerts.SetMessageID(fingerprint, id)
+	}
+
+	return nil
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *MattermostManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *MattermostManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *MattermostManager) ID() string {
+	return "mattermost"
+}
@@ -0,0 +1,149 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMattermost(t *testing.T, opts MattermostOpts) *MattermostManager {
+	opts.Log = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	opts.Metrics = metrics.New("calert")
+	opts.Room = "selfhosted"
+	opts.Template = "../../../static/mattermost.tmpl"
+	opts.Timeout = 5 * time.Second
+	opts.ThreadTTL = time.Hour
+
+	m, err := NewMattermost(opts)
+	require.NoError(t, err)
+	return m
+}
+
+func TestPushWebhook(t *testing.T) {
+	var msgs []message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		msgs = append(msgs, msg)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	m := newTestMattermost(t, MattermostOpts{
+		Endpoint: server.URL + "/hooks/xxx",
+		Channel:  "oncall",
+		Username: "calert",
+		IconURL:  "https://example.com/calert.png",
+	})
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Labels:      alertmgrtmpl.KV{"alertname": "HighLatency", "severity": "warning"},
+		Annotations: alertmgrtmpl.KV{"summary": "p99 is 2s", "calert_fallback_from": "prod"},
+	}}))
+
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "oncall", msgs[0].Channel)
+	assert.Equal(t, "calert", msgs[0].Username)
+	assert.Equal(t, "https://example.com/calert.png", msgs[0].IconURL)
+	assert.Equal(t, []attachment{{
+		Fallback: "[FIRING] HighLatency",
+		Color:    colourWarning,
+		Title:    "[FIRING] HighLatency",
+		Text:     "**(WARNING) Highlatency - Firing**",
+		Fields:   []field{{Title: "summary", Value: "p99 is 2s"}},
+	}}, msgs[0].Attachments)
+}
+
+func TestPushThreaded(t *testing.T) {
+	var (
+		posts []post
+		ids   int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v4/posts", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var p post
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		posts = append(posts, p)
+
+		ids++
+		p.ID = fmt.Sprintf("post-%d", ids)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(p)
+	}))
+	defer server.Close()
+
+	// The server URL is taken from the webhook.
+	m := newTestMattermost(t, MattermostOpts{
+		Endpoint:  server.URL + "/hooks/xxx",
+		APIToken:  "token",
+		ChannelID: "channel",
+		Username:  "calert",
+	})
+
+	alert := alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "HighLatency"}}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "def", Status: "firing"}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, posts, 3)
+	assert.Equal(t, "channel", posts[0].ChannelID)
+	assert.Equal(t, "calert", posts[0].Props["override_username"])
+	assert.NotEmpty(t, posts[0].Props["attachments"])
+	// Only later posts for the same alert are replies to the first one.
+	assert.Empty(t, posts[0].RootID)
+	assert.Empty(t, posts[1].RootID)
+	assert.Equal(t, "post-1", posts[2].RootID)
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "post-1", a.MessageID)
+	assert.Equal(t, "resolved", a.Status)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	m := newTestMattermost(t, MattermostOpts{
+		Endpoint:  server.URL,
+		APIToken:  "token",
+		ChannelID: "channel",
+	})
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+
+	// The next post starts the thread.
+	a, ok := m.ActiveAlerts().Get("abc")
+	require.True(t, ok)
+	assert.Empty(t, a.MessageID)
+}
+
+func TestNewMattermost(t *testing.T) {
+	_, err := NewMattermost(MattermostOpts{
+		Log:      slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Endpoint: "http://mattermost/hooks/xxx",
+		Template: "../../../static/mattermost.tmpl",
+		APIToken: "token",
+	})
+	assert.Error(t, err, "channel_id is required with an api_token")
+}
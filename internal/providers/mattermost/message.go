@@ -0,0 +1,179 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Colours of attachments by the severity of alerts.
+const (
+	colourCritical = "#ED4245"
+	colourWarning  = "#FEE75C"
+	colourInfo     = "#5865F2"
+	colourResolved = "#57F287"
+	colourDefault  = "#95A5A6"
+)
+
+// message is the payload of an incoming webhook.
+// ref. https://developers.mattermost.com/integrate/webhooks/incoming/#parameters
+type message struct {
+	Channel     string       `json:"channel,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconURL     string       `json:"icon_url,omitempty"`
+	Attachments []attachment `json:"attachments"`
+}
+
+// attachment is a Slack compatible message attachment.
+// ref. https://developers.mattermost.com/integrate/reference/message-attachments/
+type attachment struct {
+	Fallback string  `json:"fallback"`
+	Color    string  `json:"color"`
+	Title    string  `json:"title,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Fields   []field `json:"fields,omitempty"`
+}
+
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// post is the payload to create a post with the REST API.
+// ref. https://api.mattermost.com/#tag/posts/operation/CreatePost
+type post struct {
+	ID        string         `json:"id,omitempty"`
+	ChannelID string         `json:"channel_id"`
+	RootID    string         `json:"root_id,omitempty"`
+	Props     map[string]any `json:"props"`
+}
+
+// prepareMessage renders the template for the alert as the text of an attachment,
+// coloured by its severity, with a field for each annotation.
+func (m *MattermostManager) prepareMessage(a alertmgrtmpl.Alert) (message, error) {
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return message{}, err
+	}
+
+	att := attachment{
+		Title: fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), a.Labels["alertname"]),
+		Text:  strings.TrimSpace(buf.String()),
+		Color: colour(a),
+	}
+	att.Fallback = att.Title
+	for _, kv := range a.Annotations.SortedPairs() {
+		// Annotations added by calert are hooks for templates.
+		if strings.HasPrefix(kv.Name, "calert_") || kv.Value == "" {
+			continue
+		}
+		att.Fields = append(att.Fields, field{Title: kv.Name, Value: kv.Value})
+	}
+
+	return message{
+		Channel:     m.channel,
+		Username:    m.username,
+		IconURL:     m.iconURL,
+		Attachments: []attachment{att},
+	}, nil
+}
+
+// colour returns the colour of the attachment for the alert.
+func colour(a alertmgrtmpl.Alert) string {
+	if a.Status == "resolved" {
+		return colourResolved
+	}
+	switch strings.ToLower(a.Labels["severity"]) {
+	case "critical", "error", "page":
+		return colourCritical
+	case "warning":
+		return colourWarning
+	case "info":
+		return colourInfo
+	}
+	return colourDefault
+}
+
+// sendMessage pushes out a message to the webhook.
+func (m *MattermostManager) sendMessage(msg message) error {
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	m.lo.Debug("sending alert", "url", m.endpoint, "payload", string(out))
+	resp, err := m.client.Post(m.endpoint, "application/json", bytes.NewBuffer(out))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from Mattermost Webhook endpoint", "status", resp.StatusCode, "responseBody", string(body))
+		return fmt.Errorf("non ok response from mattermost: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// createPost creates a post for the message with the REST API, as a reply to
+// `rootID` if it's set, and returns the ID of the post.
+func (m *MattermostManager) createPost(msg message, rootID string) (string, error) {
+	p := post{
+		ChannelID: m.channelID,
+		RootID:    rootID,
+		Props: map[string]any{
+			"attachments":  msg.Attachments,
+			"from_webhook": "true",
+		},
+	}
+	// Overrides are only honoured if the server allows integrations to override them.
+	if m.username != "" {
+		p.Props["override_username"] = m.username
+	}
+	if m.iconURL != "" {
+		p.Props["override_icon_url"] = m.iconURL
+	}
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := m.serverURL + "/api/v4/posts"
+	m.lo.Debug("creating post", "url", endpoint, "payload", string(out))
+
+	req, err := retryablehttp.NewRequest(http.MethodPost, endpoint, out)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.apiToken)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from Mattermost API", "status", resp.StatusCode, "responseBody", string(body))
+		return "", fmt.Errorf("non ok response from mattermost: %d", resp.StatusCode)
+	}
+
+	var created post
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("error decoding post: %w", err)
+	}
+
+	return created.ID, nil
+}
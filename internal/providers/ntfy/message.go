@@ -0,0 +1,139 @@
+package ntfy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// maxMessageSize is the size of messages after which ntfy sends them as attachments.
+// ref. https://docs.ntfy.sh/publish/#limitations
+const maxMessageSize = 4096
+
+// response is the error response of the server.
+type response struct {
+	Error string `json:"error"`
+}
+
+// request is a request to the server, to publish or clear a notification.
+type request struct {
+	method  string
+	path    string
+	headers map[string]string
+	message string
+}
+
+// text returns the text of the request for the history.
+func (r request) text() string {
+	if r.method == http.MethodPut {
+		return "clear"
+	}
+	return r.message
+}
+
+// prepareRequest returns a request to publish the notification of the alert, with the
+// rendered blocks of the template. The fingerprint is the sequence ID, so that the
+// notification of a resolved alert replaces the earlier one. With clear_resolved, the
+// earlier notification is cleared instead.
+func (m *NtfyManager) prepareRequest(a alertmgrtmpl.Alert) (request, error) {
+	seqPath := fmt.Sprintf("/%s/%s", url.PathEscape(m.topic), url.PathEscape(a.Fingerprint))
+	if a.Status == "resolved" && m.clearResolved {
+		return request{method: http.MethodPut, path: seqPath + "/clear"}, nil
+	}
+
+	message, err := providers.ExecuteBlock(m.msgTmpl, blockMessage, a)
+	if err != nil {
+		return request{}, err
+	}
+	title, err := providers.ExecuteBlock(m.msgTmpl, blockTitle, a)
+	if err != nil {
+		return request{}, err
+	}
+	tags, err := providers.ExecuteBlock(m.msgTmpl, blockTags, a)
+	if err != nil {
+		return request{}, err
+	}
+	click, err := providers.ExecuteBlock(m.msgTmpl, blockClick, a)
+	if err != nil {
+		return request{}, err
+	}
+
+	headers := map[string]string{
+		"X-Priority":    strconv.Itoa(m.priority(a)),
+		"X-Sequence-ID": a.Fingerprint,
+	}
+	// Headers are ASCII, so the title is encoded if it isn't, which ntfy decodes.
+	if title = strings.Join(strings.Fields(title), " "); title != "" {
+		headers["X-Title"] = mime.QEncoding.Encode("utf-8", title)
+	}
+	if tags = splitTags(tags); tags != "" {
+		headers["X-Tags"] = tags
+	}
+	if click != "" {
+		headers["X-Click"] = click
+	}
+
+	return request{
+		method:  http.MethodPost,
+		path:    "/" + url.PathEscape(m.topic),
+		headers: headers,
+		message: providers.Truncate(message, maxMessageSize),
+	}, nil
+}
+
+// splitTags returns the comma separated tags, with the spaces and empty tags removed.
+func splitTags(tags string) string {
+	out := make([]string, 0)
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// sendRequest sends the request to the server.
+func (m *NtfyManager) sendRequest(r request) error {
+	endpoint := m.endpoint + r.path
+	m.lo.Debug("sending notification", "url", endpoint, "headers", r.headers)
+	req, err := retryablehttp.NewRequest(r.method, endpoint, []byte(r.message))
+	if err != nil {
+		return err
+	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	} else if m.username != "" {
+		req.SetBasicAuth(m.username, m.password)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from ntfy", "status", resp.StatusCode, "responseBody", string(body))
+
+		var r response
+		if err := json.Unmarshal(body, &r); err == nil && r.Error != "" {
+			return fmt.Errorf("non ok response from ntfy: %d: %s", resp.StatusCode, r.Error)
+		}
+		return fmt.Errorf("non ok response from ntfy: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+// Package ntfy publishes push notifications to topics of ntfy servers.
+package ntfy
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Priorities of ntfy messages.
+// ref. https://docs.ntfy.sh/publish/#message-priority
+const (
+	PriorityMin     = 1
+	PriorityLow     = 2
+	PriorityDefault = 3
+	PriorityHigh    = 4
+	PriorityMax     = 5
+)
+
+// Names of the template blocks of messages.
+const (
+	blockTitle   = "title"
+	blockMessage = "message"
+	blockTags    = "tags"
+	blockClick   = "click"
+)
+
+// defaultPriorities maps the values of the severity label to ntfy priorities.
+var defaultPriorities = map[string]int{
+	"critical": PriorityMax,
+	"error":    PriorityHigh,
+	"warning":  PriorityDefault,
+	"info":     PriorityLow,
+}
+
+type NtfyManager struct {
+	lo               *slog.Logger
+	dispatcher       *providers.Dispatcher
+	endpoint         string
+	topic            string
+	token            string
+	username         string
+	password         string
+	room             string
+	severityLabel    string
+	priorities       map[string]int
+	defaultPriority  int
+	resolvedPriority int
+	clearResolved    bool
+	client           *retryablehttp.Client
+	msgTmpl          *template.Template
+}
+
+type NtfyOpts struct {
+	Log          *slog.Logger
+	Metrics      *metrics.Manager
+	DryRun       bool
+	MaxIdleConn  int
+	Timeout      time.Duration
+	ProxyURL     string
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	// Endpoint is the base URL of the server, eg https://ntfy.sh.
+	Endpoint string
+	Topic    string
+	Room     string
+	Template string
+
+	// Token is an access token. Username and Password are used if it's empty.
+	Token    string
+	Username string
+	Password string
+	// SeverityLabel is the label with the severity of alerts. Defaults to `severity`.
+	SeverityLabel string
+	// Priorities maps the values of the severity label to priorities from 1 to 5,
+	// over the defaults which map critical, error, warning and info to 5, 4, 3 and 2.
+	Priorities map[string]int
+	// DefaultPriority is the priority of alerts whose label isn't mapped. Defaults to 3.
+	DefaultPriority int
+	// ResolvedPriority is the priority of resolved notifications. Defaults to 2.
+	ResolvedPriority int
+	// ClearResolved clears the notification of alerts once they're resolved,
+	// instead of replacing it with the resolved notification.
+	ClearResolved bool
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewNtfy initializes a ntfy provider object.
+func NewNtfy(opts NtfyOpts) (*NtfyManager, error) {
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+	if opts.SeverityLabel == "" {
+		opts.SeverityLabel = "severity"
+	}
+	if opts.DefaultPriority == 0 {
+		opts.DefaultPriority = PriorityDefault
+	}
+	if opts.ResolvedPriority == 0 {
+		opts.ResolvedPriority = PriorityLow
+	}
+
+	priorities := make(map[string]int, len(defaultPriorities)+len(opts.Priorities))
+	for k, v := range defaultPriorities {
+		priorities[k] = v
+	}
+	for k, v := range opts.Priorities {
+		priorities[k] = v
+	}
+	for _, v := range append([]int{opts.DefaultPriority, opts.ResolvedPriority}, mapValues(priorities)...) {
+		if v < PriorityMin || v > PriorityMax {
+			return nil, fmt.Errorf("invalid priority %d, should be from %d to %d", v, PriorityMin, PriorityMax)
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template, which has the blocks of the title, message, tags and click URL.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup(blockMessage) == nil {
+		return nil, fmt.Errorf("template should define the %q block", blockMessage)
+	}
+
+	return &NtfyManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:       opts.Log,
+			Metrics:   opts.Metrics,
+			History:   opts.History,
+			Provider:  "ntfy",
+			Room:      opts.Room,
+			DryRun:    opts.DryRun,
+			Breaker:   providers.NewBreaker(opts.Log, opts.Metrics, "ntfy", opts.Room, opts.BreakerOpts),
+			ThreadKey: providers.Fingerprint,
+		},
+		endpoint:         strings.TrimSuffix(opts.Endpoint, "/"),
+		topic:            opts.Topic,
+		token:            opts.Token,
+		username:         opts.Username,
+		password:         opts.Password,
+		room:             opts.Room,
+		severityLabel:    opts.SeverityLabel,
+		priorities:       priorities,
+		defaultPriority:  opts.DefaultPriority,
+		resolvedPriority: opts.ResolvedPriority,
+		clearResolved:    opts.ClearResolved,
+		client:           client,
+		msgTmpl:          tmpl,
+	}, nil
+}
+
+// Push accepts the list of alerts and publishes a notification for each of them, with
+// the fingerprint as the sequence ID, so that the notification of a resolved alert
+// replaces (or clears) the one of the firing alert. Alerts which couldn't be sent
+// after retries are returned as a *providers.PushError.
+func (m *NtfyManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		req, err := m.prepareRequest(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: req.text(),
+			Send: func() error { return m.sendRequest(req) },
+		}}, nil
+	})
+}
+
+// priority returns the ntfy priority of the alert, from its severity label.
+func (m *NtfyManager) priority(a alertmgrtmpl.Alert) int {
+	if a.Status == "resolved" {
+		return m.resolvedPriority
+	}
+	if p, ok := m.priorities[a.Labels[m.severityLabel]]; ok {
+		return p
+	}
+	return m.defaultPriority
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *NtfyManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name.
+func (m *NtfyManager) ID() string {
+	return "ntfy"
+}
+
+func mapValues(m map[string]int) []int {
+	out := make([]int, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,151 @@
+package ntfy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNtfy(t *testing.T, endpoint string, clearResolved bool) *NtfyManager {
+	m, err := NewNtfy(NtfyOpts{
+		Log:           slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:       metrics.New("calert"),
+		Endpoint:      endpoint,
+		Topic:         "alerts",
+		Room:          "phone",
+		Template:      "../../../static/ntfy.tmpl",
+		Timeout:       5 * time.Second,
+		RetryWaitMin:  10 * time.Millisecond,
+		RetryWaitMax:  10 * time.Millisecond,
+		Token:         "tk_token",
+		Priorities:    map[string]int{"page": 5},
+		ClearResolved: clearResolved,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+type received struct {
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+func newTestServer(t *testing.T, reqs *[]received) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer tk_token", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		*reqs = append(*reqs, received{method: r.Method, uri: r.URL.RequestURI(), header: r.Header, body: string(body)})
+		w.Write([]byte(`{"id":"xE73Iyuabi","time":1673542291,"event":"message","topic":"alerts"}`))
+	}))
+}
+
+func TestPush(t *testing.T) {
+	var reqs []received
+	server := newTestServer(t, &reqs)
+	defer server.Close()
+
+	m := newTestNtfy(t, server.URL+"/", false)
+	alert := alertmgrtmpl.Alert{
+		Fingerprint:  "abc",
+		Status:       "firing",
+		Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page", "instance": "db-1"},
+		Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full ✗", "calert_escalation_mention": "@oncall"},
+		GeneratorURL: "http://prometheus/graph",
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, {Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "warning"}}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, reqs, 3)
+	assert.Equal(t, http.MethodPost, reqs[0].method)
+	assert.Equal(t, "/alerts", reqs[0].uri)
+	assert.Equal(t, "Summary: /var is > 90% full ✗", reqs[0].body)
+	assert.Equal(t, "[FIRING] DiskFull on db-1", reqs[0].header.Get("X-Title"))
+	assert.Equal(t, "5", reqs[0].header.Get("X-Priority"))
+	assert.Equal(t, "rotating_light,page", reqs[0].header.Get("X-Tags"))
+	assert.Equal(t, "http://prometheus/graph", reqs[0].header.Get("X-Click"))
+	assert.Equal(t, "abc", reqs[0].header.Get("X-Sequence-ID"))
+
+	assert.Equal(t, "3", reqs[1].header.Get("X-Priority"))
+	assert.Empty(t, reqs[1].header.Get("X-Click"))
+
+	// The resolved notification replaces the firing one, with the same sequence ID.
+	assert.Equal(t, "/alerts", reqs[2].uri)
+	assert.Equal(t, "abc", reqs[2].header.Get("X-Sequence-ID"))
+	assert.Equal(t, "2", reqs[2].header.Get("X-Priority"))
+	assert.Equal(t, "[RESOLVED] DiskFull on db-1", reqs[2].header.Get("X-Title"))
+	assert.Equal(t, "white_check_mark,page", reqs[2].header.Get("X-Tags"))
+}
+
+func TestPushClearResolved(t *testing.T) {
+	var reqs []received
+	server := newTestServer(t, &reqs)
+	defer server.Close()
+
+	m := newTestNtfy(t, server.URL, true)
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{
+		Fingerprint: "abc",
+		Status:      "resolved",
+		Labels:      alertmgrtmpl.KV{"alertname": "Überlast"},
+	}}))
+
+	require.Len(t, reqs, 1)
+	assert.Equal(t, http.MethodPut, reqs[0].method)
+	assert.Equal(t, "/alerts/abc/clear", reqs[0].uri)
+	assert.Empty(t, reqs[0].body)
+
+	// Titles which aren't ASCII are encoded.
+	m = newTestNtfy(t, server.URL, false)
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "Überlast"}}}))
+	assert.Equal(t, "=?utf-8?q?[FIRING]_=C3=9Cberlast?=", reqs[1].header.Get("X-Title"))
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"code":40301,"http":403,"error":"forbidden","link":"https://ntfy.sh/docs/publish/#authentication"}`))
+	}))
+	defer server.Close()
+
+	m := newTestNtfy(t, server.URL, false)
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "403: forbidden")
+}
+
+func TestNewNtfy(t *testing.T) {
+	opts := NtfyOpts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:    metrics.New("calert"),
+		Template:   "../../../static/ntfy.tmpl",
+		Priorities: map[string]int{"page": 6},
+	}
+	_, err := NewNtfy(opts)
+	assert.ErrorContains(t, err, "topic is required")
+
+	opts.Topic = "alerts"
+	_, err = NewNtfy(opts)
+	assert.ErrorContains(t, err, "invalid priority 6")
+
+	// The message block is required.
+	opts.Priorities = nil
+	opts.Template = "../../../static/pagerduty.tmpl"
+	_, err = NewNtfy(opts)
+	assert.ErrorContains(t, err, `should define the "message" block`)
+}
@@ -0,0 +1,149 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Limits of Opsgenie alerts.
+// ref. https://docs.opsgenie.com/docs/alert-api#create-alert
+const (
+	maxMessageSize     = 130
+	maxDescriptionSize = 15000
+	maxDetailSize      = 8000
+)
+
+// source is the source of the alerts and closes in Opsgenie.
+const source = "calert"
+
+// createAlert is the payload of the request to create an alert.
+type createAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"`
+}
+
+// closeAlert is the payload of the request to close an alert.
+type closeAlert struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+// response is the response of the API.
+type response struct {
+	Message string `json:"message"`
+}
+
+// request is a request to the API, to create or close an alert.
+type request struct {
+	path string
+	// message is the message of created alerts, for the history.
+	message string
+	body    any
+}
+
+// text returns the text of the request for the history.
+func (r request) text() string {
+	if r.message == "" {
+		return "close"
+	}
+	return r.message
+}
+
+// prepareRequest returns a request to create an alert for a firing alert, with
+// the rendered blocks of the template, and to close it for a resolved alert.
+func (m *OpsgenieManager) prepareRequest(a alertmgrtmpl.Alert) (request, error) {
+	if a.Status == "resolved" {
+		return request{
+			path: fmt.Sprintf("/v2/alerts/%s/close?identifierType=alias", url.PathEscape(a.Fingerprint)),
+			body: closeAlert{Source: source, Note: "Resolved in Alertmanager"},
+		}, nil
+	}
+
+	message, err := providers.ExecuteBlock(m.msgTmpl, blockMessage, a)
+	if err != nil {
+		return request{}, err
+	}
+	// The message is shown on one line.
+	message = strings.Join(strings.Fields(message), " ")
+	description, err := providers.ExecuteBlock(m.msgTmpl, blockDescription, a)
+	if err != nil {
+		return request{}, err
+	}
+
+	details := make(map[string]string, len(a.Labels)+len(a.Annotations))
+	for _, kv := range a.Labels.SortedPairs() {
+		details[kv.Name] = kv.Value
+	}
+	for _, kv := range a.Annotations.SortedPairs() {
+		// Annotations added by calert are hooks for templates.
+		if strings.HasPrefix(kv.Name, "calert_") {
+			continue
+		}
+		details[kv.Name] = providers.Truncate(kv.Value, maxDetailSize)
+	}
+
+	return request{
+		path:    "/v2/alerts",
+		message: message,
+		body: createAlert{
+			Message:     providers.Truncate(message, maxMessageSize),
+			Alias:       a.Fingerprint,
+			Description: providers.Truncate(description, maxDescriptionSize),
+			Details:     details,
+			Entity:      a.Labels["instance"],
+			Source:      source,
+			Priority:    m.priority(a),
+		},
+	}, nil
+}
+
+// sendRequest sends the request to the API. Requests are processed asynchronously,
+// so closing an alert which doesn't exist doesn't fail.
+func (m *OpsgenieManager) sendRequest(r request) error {
+	out, err := json.Marshal(r.body)
+	if err != nil {
+		return err
+	}
+
+	endpoint := m.endpoint + r.path
+	m.lo.Debug("sending alert", "url", endpoint, "payload", string(out))
+	req, err := retryablehttp.NewRequest(http.MethodPost, endpoint, out)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+m.apiKey)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Requests are accepted with 202 Accepted.
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from Opsgenie API", "status", resp.StatusCode, "responseBody", string(body))
+
+		var r response
+		if err := json.Unmarshal(body, &r); err == nil && r.Message != "" {
+			return fmt.Errorf("non ok response from opsgenie: %d: %s", resp.StatusCode, r.Message)
+		}
+		return fmt.Errorf("non ok response from opsgenie: %d", resp.StatusCode)
+	}
+
+	return nil
+}
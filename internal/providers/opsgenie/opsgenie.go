@@ -0,0 +1,247 @@
+// Package opsgenie creates and closes alerts with the Opsgenie Alert API.
+package opsgenie
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Priorities of Opsgenie alerts.
+const (
+	PriorityP1 = "P1"
+	PriorityP2 = "P2"
+	PriorityP3 = "P3"
+	PriorityP4 = "P4"
+	PriorityP5 = "P5"
+)
+
+// Names of the template blocks of alerts.
+const (
+	blockMessage     = "message"
+	blockDescription = "description"
+)
+
+// defaultPriorities maps the values of the severity label to Opsgenie priorities.
+var defaultPriorities = map[string]string{
+	"critical": PriorityP1,
+	"error":    PriorityP2,
+	"warning":  PriorityP3,
+	"info":     PriorityP5,
+}
+
+type OpsgenieManager struct {
+	lo              *slog.Logger
+	activeAlerts    *state.ActiveAlerts
+	dispatcher      *providers.Dispatcher
+	endpoint        string
+	apiKey          string
+	room            string
+	severityLabel   string
+	priorities      map[string]string
+	defaultPriority string
+	client          *retryablehttp.Client
+	msgTmpl         *template.Template
+}
+
+type OpsgenieOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	// Endpoint is the base URL of the API, eg https://api.opsgenie.com.
+	Endpoint string
+	Room     string
+	Template string
+	// ThreadTTL is how long closed alerts are kept in the active alerts.
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	// APIKey is the key of the API integration.
+	APIKey string
+	// SeverityLabel is the label with the severity of alerts. Defaults to `severity`.
+	SeverityLabel string
+	// Priorities maps the values of the severity label to Opsgenie priorities,
+	// over the defaults which map critical, error, warning and info to P1, P2, P3 and P5.
+	Priorities map[string]string
+	// DefaultPriority is the priority of alerts whose label isn't mapped. Defaults to P3.
+	DefaultPriority string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewOpsgenie initializes an Opsgenie provider object.
+func NewOpsgenie(opts OpsgenieOpts) (*OpsgenieManager, error) {
+	if opts.APIKey == "" {
+		return nil, fmt.Errorf("api_key is required")
+	}
+	if opts.SeverityLabel == "" {
+		opts.SeverityLabel = "severity"
+	}
+	if opts.DefaultPriority == "" {
+		opts.DefaultPriority = PriorityP3
+	}
+
+	priorities := make(map[string]string, len(defaultPriorities)+len(opts.Priorities))
+	for k, v := range defaultPriorities {
+		priorities[k] = v
+	}
+	for k, v := range opts.Priorities {
+		priorities[k] = strings.ToUpper(v)
+	}
+	opts.DefaultPriority = strings.ToUpper(opts.DefaultPriority)
+	for _, v := range append([]string{opts.DefaultPriority}, mapValues(priorities)...) {
+		if !validPriority(v) {
+			return nil, fmt.Errorf("invalid priority %s, should be one of P1 to P5", v)
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template, which has the blocks of the message and the description.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup(blockMessage) == nil {
+		return nil, fmt.Errorf("template should define the %q block", blockMessage)
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &OpsgenieManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "opsgenie",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "opsgenie", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.Fingerprint,
+		},
+		endpoint:        strings.TrimSuffix(opts.Endpoint, "/"),
+		apiKey:          opts.APIKey,
+		room:            opts.Room,
+		severityLabel:   opts.SeverityLabel,
+		priorities:      priorities,
+		defaultPriority: opts.DefaultPriority,
+		client:          client,
+		msgTmpl:         tmpl,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and creates an Opsgenie alert for the firing ones
+// and closes it for the resolved ones, with the fingerprint as the alias. Alerts
+// which couldn't be sent after retries are returned as a *providers.PushError.
+func (m *OpsgenieManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		req, err := m.prepareRequest(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: req.text(),
+			Send: func() error {
+				if err := m.sendRequest(req); err != nil {
+					return err
+				}
+				// The alias identifies the alert in Opsgenie.
+				m.activeAlerts.SetMessageID(a.Fingerprint, a.Fingerprint)
+				return nil
+			},
+		}}, nil
+	})
+}
+
+// priority returns the Opsgenie priority of the alert, from its severity label.
+func (m *OpsgenieManager) priority(a alertmgrtmpl.Alert) string {
+	if p, ok := m.priorities[a.Labels[m.severityLabel]]; ok {
+		return p
+	}
+	return m.defaultPriority
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *OpsgenieManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *OpsgenieManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *OpsgenieManager) ID() string {
+	return "opsgenie"
+}
+
+func validPriority(p string) bool {
+	switch p {
+	case PriorityP1, PriorityP2, PriorityP3, PriorityP4, PriorityP5:
+		return true
+	}
+	return false
+}
+
+func mapValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOpsgenie(t *testing.T, endpoint string) *OpsgenieManager {
+	m, err := NewOpsgenie(OpsgenieOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Endpoint:     endpoint,
+		Room:         "pager",
+		Template:     "../../../static/opsgenie.tmpl",
+		Timeout:      5 * time.Second,
+		ThreadTTL:    time.Hour,
+		RetryWaitMin: 10 * time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+		APIKey:       "key",
+		Priorities:   map[string]string{"page": "p1"},
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	type received struct {
+		uri  string
+		body map[string]any
+	}
+	var reqs []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "GenieKey key", r.Header.Get("Authorization"))
+
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		reqs = append(reqs, received{uri: r.URL.RequestURI(), body: body})
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"result": "Request will be processed", "took": 0.1, "requestId": "req"}`))
+	}))
+	defer server.Close()
+
+	m := newTestOpsgenie(t, server.URL+"/")
+	alert := alertmgrtmpl.Alert{
+		Fingerprint:  "abc",
+		Status:       "firing",
+		Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page", "instance": "db-1"},
+		Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full", "calert_escalation_mention": "@oncall"},
+		GeneratorURL: "http://prometheus/graph",
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, {Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "warning"}}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, reqs, 3)
+	assert.Equal(t, "/v2/alerts", reqs[0].uri)
+	assert.Equal(t, map[string]any{
+		"message":     "[PAGE] DiskFull on db-1",
+		"alias":       "abc",
+		"description": "Summary: /var is > 90% full\nSource: http://prometheus/graph",
+		"details": map[string]any{
+			"alertname": "DiskFull",
+			"severity":  "page",
+			"instance":  "db-1",
+			"summary":   "/var is > 90% full",
+		},
+		"entity":   "db-1",
+		"source":   "calert",
+		"priority": "P1",
+	}, reqs[0].body)
+	assert.Equal(t, "P3", reqs[1].body["priority"])
+	// Resolved alerts close the alert with the same alias.
+	assert.Equal(t, "/v2/alerts/abc/close?identifierType=alias", reqs[2].uri)
+	assert.Equal(t, "calert", reqs[2].body["source"])
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "resolved", a.Status)
+	assert.Equal(t, "abc", a.MessageID)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message": "Request body is not processable. Please check the errors.", "took": 0.1, "requestId": "req"}`))
+	}))
+	defer server.Close()
+
+	m := newTestOpsgenie(t, server.URL)
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "422: Request body is not processable")
+}
+
+func TestNewOpsgenie(t *testing.T) {
+	opts := OpsgenieOpts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:    metrics.New("calert"),
+		Template:   "../../../static/opsgenie.tmpl",
+		Priorities: map[string]string{"page": "P0"},
+	}
+	_, err := NewOpsgenie(opts)
+	assert.ErrorContains(t, err, "api_key is required")
+
+	opts.APIKey = "key"
+	_, err = NewOpsgenie(opts)
+	assert.ErrorContains(t, err, "invalid priority P0")
+
+	// The message block is required.
+	opts.Priorities = nil
+	opts.Template = "../../../static/pagerduty.tmpl"
+	_, err = NewOpsgenie(opts)
+	assert.ErrorContains(t, err, `should define the "message" block`)
+}
@@ -0,0 +1,150 @@
+package pagerduty
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Actions of events.
+const (
+	actionTrigger = "trigger"
+	actionResolve = "resolve"
+)
+
+// maxSummarySize is the limit of the summary of events.
+const maxSummarySize = 1024
+
+// event is an event of the Events API v2.
+// ref. https://developer.pagerduty.com/api-reference/368ae3d938c9e-send-an-event-to-pager-duty
+type event struct {
+	RoutingKey  string   `json:"routing_key"`
+	EventAction string   `json:"event_action"`
+	DedupKey    string   `json:"dedup_key"`
+	Client      string   `json:"client,omitempty"`
+	Payload     *payload `json:"payload,omitempty"`
+	Links       []link   `json:"links,omitempty"`
+}
+
+type payload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type link struct {
+	Href string `json:"href"`
+	Text string `json:"text,omitempty"`
+}
+
+// response is the response of the Events API.
+type response struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Errors  []string `json:"errors"`
+}
+
+// summary returns the summary of the event, if any, for the history.
+func (e event) summary() string {
+	if e.Payload == nil {
+		return ""
+	}
+	return e.Payload.Summary
+}
+
+// prepareEvent returns a trigger event for a firing alert, with the rendered
+// template as its summary, and a resolve event for a resolved alert.
+func (m *PagerDutyManager) prepareEvent(a alertmgrtmpl.Alert) (event, error) {
+	ev := event{
+		RoutingKey:  m.routingKey,
+		EventAction: actionTrigger,
+		DedupKey:    a.Fingerprint,
+	}
+	if a.Status == "resolved" {
+		ev.EventAction = actionResolve
+		return ev, nil
+	}
+
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return event{}, err
+	}
+	// The summary is shown on one line.
+	summary := strings.Join(strings.Fields(buf.String()), " ")
+
+	source := a.Labels["instance"]
+	if source == "" {
+		source = "calert"
+	}
+
+	details := make(map[string]string, len(a.Labels)+len(a.Annotations))
+	for _, kv := range a.Labels.SortedPairs() {
+		details[kv.Name] = kv.Value
+	}
+	for _, kv := range a.Annotations.SortedPairs() {
+		// Annotations added by calert are hooks for templates.
+		if strings.HasPrefix(kv.Name, "calert_") {
+			continue
+		}
+		details[kv.Name] = kv.Value
+	}
+
+	ev.Client = "calert"
+	ev.Payload = &payload{
+		Summary:       providers.Truncate(summary, maxSummarySize),
+		Source:        source,
+		Severity:      m.severity(a),
+		Class:         a.Labels["alertname"],
+		CustomDetails: details,
+	}
+	if !a.StartsAt.IsZero() {
+		ev.Payload.Timestamp = a.StartsAt.Format(time.RFC3339)
+	}
+	if a.GeneratorURL != "" {
+		ev.Links = []link{{Href: a.GeneratorURL, Text: "Source"}}
+	}
+
+	return ev, nil
+}
+
+// sendEvent sends the event to the Events API.
+func (m *PagerDutyManager) sendEvent(ev event) error {
+	out, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	m.lo.Debug("sending alert", "url", m.endpoint, "action", ev.EventAction, "dedup_key", ev.DedupKey)
+	resp, err := m.client.Post(m.endpoint, "application/json", bytes.NewBuffer(out))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Events are accepted with 202 Accepted.
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from PagerDuty Events API", "status", resp.StatusCode, "responseBody", string(body))
+
+		var r response
+		if err := json.Unmarshal(body, &r); err == nil && r.Message != "" {
+			if len(r.Errors) > 0 {
+				return fmt.Errorf("non ok response from pagerduty: %d: %s: %s", resp.StatusCode, r.Message, strings.Join(r.Errors, ", "))
+			}
+			return fmt.Errorf("non ok response from pagerduty: %d: %s", resp.StatusCode, r.Message)
+		}
+		return fmt.Errorf("non ok response from pagerduty: %d", resp.StatusCode)
+	}
+
+	return nil
+}
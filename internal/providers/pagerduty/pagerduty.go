@@ -0,0 +1,235 @@
+// Package pagerduty sends alerts as events to the PagerDuty Events API v2.
+package pagerduty
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Severities of PagerDuty events.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+)
+
+// defaultSeverities maps the values of the severity label to PagerDuty severities.
+var defaultSeverities = map[string]string{
+	"critical": SeverityCritical,
+	"error":    SeverityError,
+	"warning":  SeverityWarning,
+	"info":     SeverityInfo,
+}
+
+type PagerDutyManager struct {
+	lo              *slog.Logger
+	activeAlerts    *state.ActiveAlerts
+	dispatcher      *providers.Dispatcher
+	endpoint        string
+	routingKey      string
+	room            string
+	severityLabel   string
+	severities      map[string]string
+	defaultSeverity string
+	client          *retryablehttp.Client
+	msgTmpl         *template.Template
+}
+
+type PagerDutyOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	// Endpoint is the URL of the Events API v2, eg https://events.pagerduty.com/v2/enqueue.
+	Endpoint string
+	Room     string
+	Template string
+	// ThreadTTL is how long resolved incidents are kept in the active alerts.
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	// RoutingKey is the integration key of the PagerDuty service.
+	RoutingKey string
+	// SeverityLabel is the label with the severity of alerts. Defaults to `severity`.
+	SeverityLabel string
+	// Severities maps the values of the severity label to PagerDuty severities,
+	// over the defaults which map critical, error, warning and info to themselves.
+	Severities map[string]string
+	// DefaultSeverity is the severity of alerts whose label isn't mapped. Defaults to error.
+	DefaultSeverity string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewPagerDuty initializes a PagerDuty provider object.
+func NewPagerDuty(opts PagerDutyOpts) (*PagerDutyManager, error) {
+	if opts.RoutingKey == "" {
+		return nil, fmt.Errorf("routing_key is required")
+	}
+	if opts.SeverityLabel == "" {
+		opts.SeverityLabel = "severity"
+	}
+	if opts.DefaultSeverity == "" {
+		opts.DefaultSeverity = SeverityError
+	}
+
+	severities := make(map[string]string, len(defaultSeverities)+len(opts.Severities))
+	for k, v := range defaultSeverities {
+		severities[k] = v
+	}
+	for k, v := range opts.Severities {
+		severities[k] = v
+	}
+	for _, v := range append([]string{opts.DefaultSeverity}, mapValues(severities)...) {
+		if !validSeverity(v) {
+			return nil, fmt.Errorf("invalid severity %s, should be %s, %s, %s or %s", v, SeverityCritical, SeverityError, SeverityWarning, SeverityInfo)
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &PagerDutyManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "pagerduty",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "pagerduty", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.Fingerprint,
+		},
+		endpoint:        opts.Endpoint,
+		routingKey:      opts.RoutingKey,
+		room:            opts.Room,
+		severityLabel:   opts.SeverityLabel,
+		severities:      severities,
+		defaultSeverity: opts.DefaultSeverity,
+		client:          client,
+		msgTmpl:         tmpl,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and sends a trigger event for the firing ones
+// and a resolve event for the resolved ones, with the fingerprint as the dedup key.
+// Alerts which couldn't be sent after retries are returned as a *providers.PushError.
+func (m *PagerDutyManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		ev, err := m.prepareEvent(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: ev.summary(),
+			Send: func() error {
+				if err := m.sendEvent(ev); err != nil {
+					return err
+				}
+				// The dedup key identifies the incident in PagerDuty.
+				m.activeAlerts.SetMessageID(a.Fingerprint, ev.DedupKey)
+				return nil
+			},
+		}}, nil
+	})
+}
+
+// severity returns the PagerDuty severity of the alert, from its severity label.
+func (m *PagerDutyManager) severity(a alertmgrtmpl.Alert) string {
+	if s, ok := m.severities[a.Labels[m.severityLabel]]; ok {
+		return s
+	}
+	return m.defaultSeverity
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *PagerDutyManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *PagerDutyManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *PagerDutyManager) ID() string {
+	return "pagerduty"
+}
+
+func validSeverity(s string) bool {
+	switch s {
+	case SeverityCritical, SeverityError, SeverityWarning, SeverityInfo:
+		return true
+	}
+	return false
+}
+
+func mapValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
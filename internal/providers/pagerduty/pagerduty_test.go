@@ -0,0 +1,124 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPagerDuty(t *testing.T, endpoint string, severities map[string]string) *PagerDutyManager {
+	m, err := NewPagerDuty(PagerDutyOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Endpoint:     endpoint,
+		Room:         "pager",
+		Template:     "../../../static/pagerduty.tmpl",
+		Timeout:      5 * time.Second,
+		ThreadTTL:    time.Hour,
+		RetryWaitMin: 10 * time.Millisecond,
+		RetryWaitMax: 10 * time.Millisecond,
+		RoutingKey:   "routing-key",
+		Severities:   severities,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	var events []event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		events = append(events, ev)
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "success", "message": "Event processed", "dedup_key": "` + ev.DedupKey + `"}`))
+	}))
+	defer server.Close()
+
+	m := newTestPagerDuty(t, server.URL, map[string]string{"page": SeverityCritical})
+	alert := alertmgrtmpl.Alert{
+		Fingerprint:  "abc",
+		Status:       "firing",
+		Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page", "instance": "db-1"},
+		Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full", "calert_escalation_mention": "@oncall"},
+		StartsAt:     time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC),
+		GeneratorURL: "http://prometheus/graph",
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, {Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"severity": "unknown"}}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, event{
+		RoutingKey:  "routing-key",
+		EventAction: actionTrigger,
+		DedupKey:    "abc",
+		Client:      "calert",
+		Payload: &payload{
+			Summary:   "[PAGE] DiskFull on db-1: /var is > 90% full",
+			Source:    "db-1",
+			Severity:  SeverityCritical,
+			Timestamp: "2026-10-19T10:00:00Z",
+			Class:     "DiskFull",
+			CustomDetails: map[string]string{
+				"alertname": "DiskFull",
+				"severity":  "page",
+				"instance":  "db-1",
+				"summary":   "/var is > 90% full",
+			},
+		},
+		Links: []link{{Href: "http://prometheus/graph", Text: "Source"}},
+	}, events[0])
+	// Alerts whose severity isn't mapped have the default severity.
+	assert.Equal(t, SeverityError, events[1].Payload.Severity)
+	assert.Equal(t, "calert", events[1].Payload.Source)
+	// Resolve events only need the dedup key.
+	assert.Equal(t, event{RoutingKey: "routing-key", EventAction: actionResolve, DedupKey: "abc"}, events[2])
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "resolved", a.Status)
+	assert.Equal(t, "abc", a.MessageID)
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status": "invalid event", "message": "Event object is invalid", "errors": ["Length of 'routing_key' is incorrect (should be 32 characters)"]}`))
+	}))
+	defer server.Close()
+
+	m := newTestPagerDuty(t, server.URL, nil)
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "400: Event object is invalid: Length of 'routing_key' is incorrect")
+}
+
+func TestNewPagerDuty(t *testing.T) {
+	opts := PagerDutyOpts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:    metrics.New("calert"),
+		Template:   "../../../static/pagerduty.tmpl",
+		Severities: map[string]string{"page": "urgent"},
+	}
+	_, err := NewPagerDuty(opts)
+	assert.ErrorContains(t, err, "routing_key is required")
+
+	opts.RoutingKey = "routing-key"
+	_, err = NewPagerDuty(opts)
+	assert.ErrorContains(t, err, "invalid severity urgent")
+}
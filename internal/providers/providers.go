@@ -1,6 +1,10 @@
 package providers
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/mr-karan/calert/internal/state"
 	alertmgrtmpl "github.com/prometheus/alertmanager/template"
 )
 
@@ -12,3 +16,59 @@ type Provider interface {
 	// Push pushes the notification to upstream provider.
 	Push(alerts []alertmgrtmpl.Alert) error
 }
+
+// Stateful is implemented by providers which track the state of active alerts.
+type Stateful interface {
+	// ActiveAlerts returns the active alerts tracked for the room.
+	ActiveAlerts() *state.ActiveAlerts
+}
+
+// Closer is implemented by providers which hold connections to close at shutdown.
+type Closer interface {
+	// Close stops the retries in flight, flushes the pending messages
+	// and closes the connections.
+	Close() error
+}
+
+// DigestPusher is implemented by providers which can send periodic digests.
+type DigestPusher interface {
+	// PushDigest renders the digest with the `digest` template block
+	// and pushes it to the upstream provider.
+	PushDigest(d Digest) error
+}
+
+// Digest is a summary of the alerts sent to a room during a window.
+type Digest struct {
+	Room     string
+	From     time.Time
+	To       time.Time
+	Firing   int
+	Resolved int
+	Alerts   []DigestAlert
+}
+
+// DigestAlert is the latest state of an alert in a digest.
+type DigestAlert struct {
+	alertmgrtmpl.Alert
+	// Count is the number of notifications received for the alert during the window.
+	Count int
+	// Duration is how long the alert has been firing, or fired for if it's resolved.
+	Duration time.Duration
+}
+
+// PushError is returned by Push when some of the alerts
+// couldn't be delivered to the upstream provider.
+type PushError struct {
+	// Alerts is the list of undelivered alerts.
+	Alerts []alertmgrtmpl.Alert
+	// Err is the reason the alerts couldn't be delivered.
+	Err error
+}
+
+func (e *PushError) Error() string {
+	return fmt.Sprintf("%d alert(s) not delivered: %s", len(e.Alerts), e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
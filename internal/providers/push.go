@@ -0,0 +1,295 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mr-karan/calert/internal/breaker"
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/oncall"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Shared are the options of the services which are shared by all the providers.
+type Shared struct {
+	// Mentions resolves label values to @-mentions in templates. Optional.
+	Mentions *mentions.Mapper
+	// OnCall resolves the users on call for teams in templates. Optional.
+	OnCall *oncall.Resolver
+	// History records the dispatched notifications. Optional.
+	History *history.Store
+}
+
+// BreakerOpts are the options of the circuit breaker of a room, which stops
+// sending messages to an endpoint which is consistently failing.
+type BreakerOpts struct {
+	// BreakerThreshold is the number of consecutive failures after which the
+	// circuit opens. 0 disables the breaker.
+	BreakerThreshold      int
+	BreakerOpenInterval   time.Duration
+	BreakerHalfOpenProbes int
+}
+
+// NewBreaker returns the circuit breaker of the provider's room, whose state is
+// exported as a metric.
+func NewBreaker(lo *slog.Logger, m *metrics.Manager, provider, room string, opts BreakerOpts) *breaker.Breaker {
+	return breaker.New(breaker.Opts{
+		FailureThreshold: opts.BreakerThreshold,
+		OpenInterval:     opts.BreakerOpenInterval,
+		HalfOpenProbes:   opts.BreakerHalfOpenProbes,
+		OnStateChange: func(s breaker.State) {
+			if m != nil {
+				m.Set(fmt.Sprintf(`circuit_breaker_state{provider="%s", room="%s"}`, provider, room), float64(s))
+			}
+			if s != breaker.Closed {
+				lo.Warn("circuit breaker state changed", "room", room, "state", s.String())
+			}
+		},
+	})
+}
+
+// Message is a message prepared for an alert by a provider.
+type Message struct {
+	// Text is the text of the message, which is recorded in the history.
+	Text string
+	// Send sends the message.
+	Send func() error
+}
+
+// Dispatcher has the steps of Push which are common to all the providers: it
+// counts and times the notifications of alerts, skips duplicates and sending them
+// in dry run or while the circuit breaker is open, records them in the history and
+// returns the undelivered alerts as a *PushError.
+// Providers only prepare and send their messages.
+type Dispatcher struct {
+	Log      *slog.Logger
+	Metrics  *metrics.Manager
+	History  *history.Store
+	Provider string
+	Room     string
+	DryRun   bool
+	// ActiveAlerts are the active alerts of stateful providers, to which alerts
+	// are added before their messages are prepared. Optional.
+	ActiveAlerts *state.ActiveAlerts
+	// ThreadKey returns the key of the alert's thread in the history. Optional.
+	ThreadKey func(a alertmgrtmpl.Alert) string
+	// Breaker skips sending messages while its circuit is open. Optional.
+	Breaker *breaker.Breaker
+	// Duplicate returns whether the notification of the alert repeats a delivered
+	// one, in which case it's skipped. Optional.
+	Duplicate func(a alertmgrtmpl.Alert, now time.Time) bool
+	// Delivered is called once all the messages of the alert are sent. It isn't
+	// called in dry run. Optional.
+	Delivered func(a alertmgrtmpl.Alert, now time.Time)
+	// Now returns the time of notifications. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Push prepares the messages of each alert and sends them in order. The messages
+// of an alert stop at the first one which can't be sent, and the alert is returned
+// in a *PushError.
+func (d *Dispatcher) Push(alerts []alertmgrtmpl.Alert, prepare func(a alertmgrtmpl.Alert) ([]Message, error)) error {
+	d.Log.Info("dispatching alerts to "+d.Provider, "count", len(alerts))
+
+	var undelivered Undelivered
+	for _, a := range alerts {
+		start := time.Now()
+		now := start
+		if d.Now != nil {
+			now = d.Now()
+		}
+
+		// Skip repeats of the last notification, eg from Alertmanager's `repeat_interval` or HA peers.
+		if d.Duplicate != nil && d.Duplicate(a, now) {
+			if d.ActiveAlerts != nil {
+				d.ActiveAlerts.SetStatus(a.Fingerprint, a.Status, now)
+			}
+			d.Metrics.Increment(fmt.Sprintf(`alerts_deduplicated_total{provider="%s", room="%s"}`, d.Provider, d.Room))
+			d.Log.Debug("skipping duplicate notification", "room", d.Room, "fingerprint", a.Fingerprint, "status", a.Status)
+			d.Record(a, "", history.ResultDeduplicated, nil)
+			continue
+		}
+
+		d.Count()
+
+		// If it's a new alert whose fingerprint isn't in the active alerts map, add it first.
+		if d.ActiveAlerts != nil {
+			if d.ActiveAlerts.Lookup(a.Fingerprint) == "" {
+				d.ActiveAlerts.Add(a)
+			}
+			d.ActiveAlerts.SetStatus(a.Fingerprint, a.Status, now)
+		}
+
+		msgs, err := prepare(a)
+		if err != nil {
+			d.PrepareError(a, err)
+			continue
+		}
+
+		if d.DryRun {
+			for _, msg := range msgs {
+				d.DryRunSkip(a, msg.Text)
+			}
+			continue
+		}
+
+		delivered := true
+		for _, msg := range msgs {
+			if !d.Send(&undelivered, a, msg) {
+				delivered = false
+				break
+			}
+		}
+		if delivered && d.Delivered != nil {
+			d.Delivered(a, now)
+		}
+		d.Duration(start)
+	}
+
+	return undelivered.Err()
+}
+
+// Send sends the message of the alert through the circuit breaker, and records
+// whether it was sent. It returns false if it wasn't.
+func (d *Dispatcher) Send(undelivered *Undelivered, a alertmgrtmpl.Alert, msg Message) bool {
+	if err := d.Call(msg.Send); err != nil {
+		d.SendError(undelivered, a, msg.Text, err)
+		return false
+	}
+	d.Sent(a, msg.Text)
+	return true
+}
+
+// Call calls send unless the circuit breaker is open, in which case it returns
+// breaker.ErrOpen, and records the result in the breaker.
+func (d *Dispatcher) Call(send func() error) error {
+	if d.Breaker == nil {
+		return send()
+	}
+	if err := d.Breaker.Allow(); err != nil {
+		return err
+	}
+	if err := send(); err != nil {
+		d.Breaker.Failure()
+		return err
+	}
+	d.Breaker.Success()
+	return nil
+}
+
+// Count counts a notification which is dispatched.
+func (d *Dispatcher) Count() {
+	d.Metrics.Increment(fmt.Sprintf(`alerts_dispatched_total{provider="%s", room="%s"}`, d.Provider, d.Room))
+}
+
+// PrepareError records a notification whose messages couldn't be prepared.
+func (d *Dispatcher) PrepareError(a alertmgrtmpl.Alert, err error) {
+	d.Log.Error("error preparing message", "error", err)
+	d.Metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="preparing"}`, d.Provider, d.Room))
+	d.Record(a, "", history.ResultFailed, err)
+}
+
+// DryRunSkip records a message which isn't sent in dry run.
+func (d *Dispatcher) DryRunSkip(a alertmgrtmpl.Alert, text string) {
+	d.Log.Info("dry_run is enabled for this room. skipping pushing notification", "room", d.Room)
+	d.Record(a, text, history.ResultDryRun, nil)
+}
+
+// SendError records a message which couldn't be sent, or was skipped because the
+// circuit breaker is open, and adds its alert to undelivered.
+func (d *Dispatcher) SendError(undelivered *Undelivered, a alertmgrtmpl.Alert, text string, err error) {
+	if errors.Is(err, breaker.ErrOpen) {
+		d.Metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="circuit_open"}`, d.Provider, d.Room))
+		d.Log.Warn("circuit breaker is open, skipping message", "room", d.Room, "fingerprint", a.Fingerprint)
+	} else {
+		d.Metrics.Increment(fmt.Sprintf(`alerts_dispatched_errors_total{provider="%s", room="%s", reason="sending"}`, d.Provider, d.Room))
+		d.Log.Error("error sending message", "error", err, "fingerprint", a.Fingerprint)
+	}
+	undelivered.Add(a, err)
+	d.Record(a, text, history.ResultFailed, err)
+}
+
+// Sent records a message which is sent.
+func (d *Dispatcher) Sent(a alertmgrtmpl.Alert, text string) {
+	d.Record(a, text, history.ResultSent, nil)
+}
+
+// Duration records how long the notification took to dispatch since start.
+func (d *Dispatcher) Duration(start time.Time) {
+	d.Metrics.Duration(fmt.Sprintf(`alerts_dispatched_duration_seconds{provider="%s", room="%s"}`, d.Provider, d.Room), start)
+}
+
+// Record adds a dispatched message to the history.
+func (d *Dispatcher) Record(a alertmgrtmpl.Alert, text, result string, err error) {
+	e := history.Entry{
+		Room:        d.Room,
+		Provider:    d.Provider,
+		Fingerprint: a.Fingerprint,
+		Status:      a.Status,
+		Labels:      a.Labels,
+		Text:        text,
+		Result:      result,
+	}
+	if d.ThreadKey != nil {
+		e.ThreadKey = d.ThreadKey(a)
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	d.History.Add(e)
+}
+
+// MessageID returns the provider's ID of the first message for the alert, for
+// Dispatcher.ThreadKey.
+func MessageID(alerts *state.ActiveAlerts) func(a alertmgrtmpl.Alert) string {
+	return func(a alertmgrtmpl.Alert) string {
+		d, _ := alerts.Get(a.Fingerprint)
+		return d.MessageID
+	}
+}
+
+// Fingerprint returns the fingerprint of the alert, for Dispatcher.ThreadKey.
+func Fingerprint(a alertmgrtmpl.Alert) string {
+	return a.Fingerprint
+}
+
+// Undelivered collects the alerts which couldn't be delivered, along with the
+// distinct reasons. It's safe for concurrent use.
+type Undelivered struct {
+	mu     sync.Mutex
+	alerts []alertmgrtmpl.Alert
+	errs   []error
+	seen   map[string]bool
+}
+
+// Add adds an alert which couldn't be delivered because of err.
+func (u *Undelivered) Add(a alertmgrtmpl.Alert, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.alerts = append(u.alerts, a)
+	if u.seen == nil {
+		u.seen = make(map[string]bool)
+	}
+	if !u.seen[err.Error()] {
+		u.seen[err.Error()] = true
+		u.errs = append(u.errs, err)
+	}
+}
+
+// Err returns the undelivered alerts as a *PushError, or nil if there are none.
+func (u *Undelivered) Err() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if len(u.alerts) == 0 {
+		return nil
+	}
+	return &PushError{Alerts: u.alerts, Err: errors.Join(u.errs...)}
+}
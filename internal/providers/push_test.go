@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/breaker"
+	"github.com/mr-karan/calert/internal/history"
+	"github.com/mr-karan/calert/internal/metrics"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	hist, err := history.New(history.Opts{Log: lo, Metrics: metrics.New("calert"), Dir: filepath.Join(t.TempDir(), "history"), Retention: time.Hour})
+	require.NoError(t, err)
+
+	return &Dispatcher{
+		Log:       lo,
+		Metrics:   metrics.New("calert"),
+		History:   hist,
+		Provider:  "test",
+		Room:      "prod",
+		ThreadKey: Fingerprint,
+	}
+}
+
+func TestDispatcherPush(t *testing.T) {
+	var (
+		d      = newTestDispatcher(t)
+		sent   []string
+		alerts = []alertmgrtmpl.Alert{
+			{Fingerprint: "abc", Status: "firing"},
+			{Fingerprint: "def", Status: "firing"},
+			{Fingerprint: "ghi", Status: "firing"},
+		}
+		errSend = errors.New("not sent")
+	)
+
+	// The first alert is sent, the messages of the second stop at the first error,
+	// and the third can't be prepared, which isn't retried.
+	err := d.Push(alerts, func(a alertmgrtmpl.Alert) ([]Message, error) {
+		if a.Fingerprint == "ghi" {
+			return nil, errors.New("invalid template")
+		}
+		send := func(text string) func() error {
+			return func() error {
+				if text == "def-1" {
+					return errSend
+				}
+				sent = append(sent, text)
+				return nil
+			}
+		}
+		return []Message{
+			{Text: a.Fingerprint + "-1", Send: send(a.Fingerprint + "-1")},
+			{Text: a.Fingerprint + "-2", Send: send(a.Fingerprint + "-2")},
+		}, nil
+	})
+	var pErr *PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, alerts[1:2], pErr.Alerts)
+	assert.ErrorIs(t, err, errSend)
+	assert.Equal(t, []string{"abc-1", "abc-2"}, sent)
+
+	entries, err := d.History.Query(history.Query{Room: "prod"})
+	require.NoError(t, err)
+	results := make(map[string]string)
+	for _, e := range entries {
+		assert.Equal(t, "test", e.Provider)
+		assert.Equal(t, e.Fingerprint, e.ThreadKey)
+		results[e.Text] = e.Result
+	}
+	assert.Equal(t, map[string]string{
+		"abc-1": history.ResultSent,
+		"abc-2": history.ResultSent,
+		"def-1": history.ResultFailed,
+		"":      history.ResultFailed,
+	}, results)
+
+	// Nothing is sent in dry run.
+	d.DryRun = true
+	sent = nil
+	require.NoError(t, d.Push(alerts[:1], func(a alertmgrtmpl.Alert) ([]Message, error) {
+		return []Message{{Text: "dry", Send: func() error {
+			sent = append(sent, "dry")
+			return nil
+		}}}, nil
+	}))
+	assert.Empty(t, sent)
+}
+
+func TestUndelivered(t *testing.T) {
+	var u Undelivered
+	assert.NoError(t, u.Err())
+
+	// Reasons are only listed once.
+	u.Add(alertmgrtmpl.Alert{Fingerprint: "abc"}, errors.New("timeout"))
+	u.Add(alertmgrtmpl.Alert{Fingerprint: "def"}, errors.New("timeout"))
+	u.Add(alertmgrtmpl.Alert{Fingerprint: "ghi"}, errors.New("rate limited"))
+
+	var pErr *PushError
+	require.ErrorAs(t, u.Err(), &pErr)
+	assert.Len(t, pErr.Alerts, 3)
+	assert.EqualError(t, pErr.Err, "timeout\nrate limited")
+}
+
+func TestDispatcherBreaker(t *testing.T) {
+	d := newTestDispatcher(t)
+	d.Breaker = NewBreaker(d.Log, d.Metrics, d.Provider, d.Room, BreakerOpts{BreakerThreshold: 1, BreakerOpenInterval: time.Hour})
+
+	var (
+		calls  int
+		alerts = []alertmgrtmpl.Alert{
+			{Fingerprint: "abc", Status: "firing"},
+			{Fingerprint: "def", Status: "firing"},
+		}
+	)
+	// The first failure opens the circuit, so the second alert isn't sent.
+	err := d.Push(alerts, func(a alertmgrtmpl.Alert) ([]Message, error) {
+		return []Message{{Text: a.Fingerprint, Send: func() error {
+			calls++
+			return errors.New("unavailable")
+		}}}, nil
+	})
+	var pErr *PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Equal(t, alerts, pErr.Alerts)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, breaker.Open, d.Breaker.State())
+}
+
+func TestDispatcherDuplicate(t *testing.T) {
+	var (
+		d         = newTestDispatcher(t)
+		sent      int
+		delivered = make(map[string]bool)
+		alerts    = []alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}}
+		prepare   = func(a alertmgrtmpl.Alert) ([]Message, error) {
+			return []Message{{Text: a.Fingerprint, Send: func() error {
+				sent++
+				return nil
+			}}}, nil
+		}
+	)
+	d.Duplicate = func(a alertmgrtmpl.Alert, now time.Time) bool { return delivered[a.Fingerprint] }
+	d.Delivered = func(a alertmgrtmpl.Alert, now time.Time) { delivered[a.Fingerprint] = true }
+
+	// Dry runs aren't deliveries, so they don't make the next notification a duplicate.
+	d.DryRun = true
+	require.NoError(t, d.Push(alerts, prepare))
+	assert.Empty(t, delivered)
+
+	d.DryRun = false
+	require.NoError(t, d.Push(alerts, prepare))
+	require.NoError(t, d.Push(alerts, prepare))
+	assert.Equal(t, 1, sent)
+
+	entries, err := d.History.Query(history.Query{Room: "prod"})
+	require.NoError(t, err)
+	var results []string
+	for _, e := range entries {
+		results = append(results, e.Result)
+	}
+	assert.ElementsMatch(t, []string{history.ResultDryRun, history.ResultSent, history.ResultDeduplicated}, results)
+}
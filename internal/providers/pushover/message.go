@@ -0,0 +1,136 @@
+package pushover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Limits of Pushover messages.
+// ref. https://pushover.net/api#limits
+const (
+	maxMessageSize = 1024
+	maxTitleSize   = 250
+	maxURLSize     = 512
+)
+
+// response is the response of the API.
+type response struct {
+	Status int      `json:"status"`
+	Errors []string `json:"errors"`
+}
+
+// prepareMessage returns the form of the message of the alert, with the rendered blocks
+// of the template. Emergency messages are tagged with the fingerprint and the tags.
+func (m *PushoverManager) prepareMessage(a alertmgrtmpl.Alert) (url.Values, error) {
+	message, err := providers.ExecuteBlock(m.msgTmpl, blockMessage, a)
+	if err != nil {
+		return nil, err
+	}
+	title, err := providers.ExecuteBlock(m.msgTmpl, blockTitle, a)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := providers.ExecuteBlock(m.msgTmpl, blockTags, a)
+	if err != nil {
+		return nil, err
+	}
+	click, err := providers.ExecuteBlock(m.msgTmpl, blockClick, a)
+	if err != nil {
+		return nil, err
+	}
+
+	title = strings.Join(strings.Fields(title), " ")
+	// The message is required, so the title is sent as the message if it's empty.
+	if message == "" {
+		message = title
+	}
+	if message == "" {
+		return nil, fmt.Errorf("message is empty")
+	}
+
+	priority := m.priority(a)
+	form := url.Values{
+		"token":    {m.token},
+		"user":     {m.userKey},
+		"message":  {providers.Truncate(message, maxMessageSize)},
+		"priority": {strconv.Itoa(priority)},
+	}
+	if title != "" {
+		form.Set("title", providers.Truncate(title, maxTitleSize))
+	}
+	if click != "" {
+		if len(click) > maxURLSize {
+			return nil, fmt.Errorf("click url is longer than %d characters", maxURLSize)
+		}
+		form.Set("url", click)
+	}
+	if m.device != "" {
+		form.Set("device", m.device)
+	}
+	if m.sound != "" {
+		form.Set("sound", m.sound)
+	}
+	if priority == PriorityEmergency {
+		form.Set("retry", strconv.Itoa(int(m.retry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(m.expire.Seconds())))
+		t := []string{a.Fingerprint}
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				t = append(t, tag)
+			}
+		}
+		form.Set("tags", strings.Join(t, ","))
+	}
+
+	return form, nil
+}
+
+// sendMessage sends the message.
+func (m *PushoverManager) sendMessage(form url.Values) error {
+	return m.post("/1/messages.json", form)
+}
+
+// cancel cancels the retries of the emergency messages tagged with the fingerprint.
+// ref. https://pushover.net/api/receipts#cancel_by_tag
+func (m *PushoverManager) cancel(fingerprint string) error {
+	return m.post(fmt.Sprintf("/1/receipts/cancel_by_tag/%s.json", url.PathEscape(fingerprint)), url.Values{"token": {m.token}})
+}
+
+// post sends the form to the path of the API.
+func (m *PushoverManager) post(path string, form url.Values) error {
+	endpoint := m.endpoint + path
+	m.lo.Debug("sending request", "url", endpoint, "title", form.Get("title"), "priority", form.Get("priority"))
+	req, err := retryablehttp.NewRequest(http.MethodPost, endpoint, []byte(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		m.lo.Debug("Non OK HTTP Response received from Pushover API", "status", resp.StatusCode, "responseBody", string(body))
+
+		var r response
+		if err := json.Unmarshal(body, &r); err == nil && len(r.Errors) > 0 {
+			return fmt.Errorf("non ok response from pushover: %d: %s", resp.StatusCode, strings.Join(r.Errors, ", "))
+		}
+		return fmt.Errorf("non ok response from pushover: %d", resp.StatusCode)
+	}
+
+	return nil
+}
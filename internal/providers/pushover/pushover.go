@@ -0,0 +1,260 @@
+// Package pushover sends push notifications with the Pushover message API.
+package pushover
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Priorities of Pushover messages.
+// ref. https://pushover.net/api#priority
+const (
+	PriorityLowest    = -2
+	PriorityLow       = -1
+	PriorityNormal    = 0
+	PriorityHigh      = 1
+	PriorityEmergency = 2
+)
+
+// Bounds of how often emergency messages are retried until they're acknowledged, and for how long.
+const (
+	minRetry  = 30 * time.Second
+	maxExpire = 3 * time.Hour
+)
+
+// Names of the template blocks of messages.
+const (
+	blockTitle   = "title"
+	blockMessage = "message"
+	blockTags    = "tags"
+	blockClick   = "click"
+)
+
+// defaultPriorities maps the values of the severity label to Pushover priorities.
+var defaultPriorities = map[string]int{
+	"critical": PriorityHigh,
+	"error":    PriorityHigh,
+	"warning":  PriorityNormal,
+	"info":     PriorityLow,
+}
+
+type PushoverManager struct {
+	lo               *slog.Logger
+	dispatcher       *providers.Dispatcher
+	endpoint         string
+	token            string
+	userKey          string
+	device           string
+	sound            string
+	retry            time.Duration
+	expire           time.Duration
+	room             string
+	severityLabel    string
+	priorities       map[string]int
+	defaultPriority  int
+	resolvedPriority int
+	client           *retryablehttp.Client
+	msgTmpl          *template.Template
+}
+
+type PushoverOpts struct {
+	Log          *slog.Logger
+	Metrics      *metrics.Manager
+	DryRun       bool
+	MaxIdleConn  int
+	Timeout      time.Duration
+	ProxyURL     string
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	// Endpoint is the base URL of the API, eg https://api.pushover.net.
+	Endpoint string
+	Room     string
+	Template string
+
+	// Token is the token of the application.
+	Token string
+	// UserKey is the key of the user or group to notify.
+	UserKey string
+	// Device limits the notifications to the devices of the user, comma separated. Optional.
+	Device string
+	// Sound overrides the user's default sound. Optional.
+	Sound string
+	// Retry is how often emergency notifications are repeated until they're
+	// acknowledged, for up to Expire. Defaults to 1m and 1h.
+	Retry  time.Duration
+	Expire time.Duration
+	// SeverityLabel is the label with the severity of alerts. Defaults to `severity`.
+	SeverityLabel string
+	// Priorities maps the values of the severity label to priorities from -2 to 2,
+	// over the defaults which map critical, error, warning and info to 1, 1, 0 and -1.
+	Priorities map[string]int
+	// DefaultPriority is the priority of alerts whose label isn't mapped.
+	DefaultPriority int
+	// ResolvedPriority is the priority of resolved notifications.
+	ResolvedPriority int
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewPushover initializes a Pushover provider object.
+func NewPushover(opts PushoverOpts) (*PushoverManager, error) {
+	if opts.Token == "" || opts.UserKey == "" {
+		return nil, fmt.Errorf("token and user_key are required")
+	}
+	if opts.SeverityLabel == "" {
+		opts.SeverityLabel = "severity"
+	}
+	if opts.Retry == 0 {
+		opts.Retry = time.Minute
+	}
+	if opts.Expire == 0 {
+		opts.Expire = time.Hour
+	}
+	if opts.Retry < minRetry || opts.Expire > maxExpire {
+		return nil, fmt.Errorf("retry should be at least %s and expire at most %s", minRetry, maxExpire)
+	}
+	// The resolved notification of an emergency alert cancels its retries, and
+	// can't be an emergency itself, as nothing would cancel it.
+	if opts.ResolvedPriority == PriorityEmergency {
+		return nil, fmt.Errorf("resolved priority can't be %d", PriorityEmergency)
+	}
+
+	priorities := make(map[string]int, len(defaultPriorities)+len(opts.Priorities))
+	for k, v := range defaultPriorities {
+		priorities[k] = v
+	}
+	for k, v := range opts.Priorities {
+		priorities[k] = v
+	}
+	for _, v := range append([]int{opts.DefaultPriority, opts.ResolvedPriority}, mapValues(priorities)...) {
+		if v < PriorityLowest || v > PriorityEmergency {
+			return nil, fmt.Errorf("invalid priority %d, should be from %d to %d", v, PriorityLowest, PriorityEmergency)
+		}
+	}
+
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentions.Plain,
+		OnCall:        opts.OnCall,
+	})
+
+	// Load the template, which has the blocks of the title, message, tags and click URL.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup(blockMessage) == nil {
+		return nil, fmt.Errorf("template should define the %q block", blockMessage)
+	}
+
+	return &PushoverManager{
+		lo: opts.Log,
+		dispatcher: &providers.Dispatcher{
+			Log:      opts.Log,
+			Metrics:  opts.Metrics,
+			History:  opts.History,
+			Provider: "pushover",
+			Room:     opts.Room,
+			DryRun:   opts.DryRun,
+			Breaker:  providers.NewBreaker(opts.Log, opts.Metrics, "pushover", opts.Room, opts.BreakerOpts),
+		},
+		endpoint:         strings.TrimSuffix(opts.Endpoint, "/"),
+		token:            opts.Token,
+		userKey:          opts.UserKey,
+		device:           opts.Device,
+		sound:            opts.Sound,
+		retry:            opts.Retry,
+		expire:           opts.Expire,
+		room:             opts.Room,
+		severityLabel:    opts.SeverityLabel,
+		priorities:       priorities,
+		defaultPriority:  opts.DefaultPriority,
+		resolvedPriority: opts.ResolvedPriority,
+		client:           client,
+		msgTmpl:          tmpl,
+	}, nil
+}
+
+// Push accepts the list of alerts and sends a message for each of them. Emergency
+// messages are tagged with the fingerprint, so that the retries of the firing alert
+// are cancelled once it's resolved. Alerts which couldn't be sent after retries are
+// returned as a *providers.PushError.
+func (m *PushoverManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		msg, err := m.prepareMessage(a)
+		if err != nil {
+			return nil, err
+		}
+		return []providers.Message{{
+			Text: msg.Get("message"),
+			Send: func() error {
+				// The firing alert was an emergency message, whose retries are cancelled.
+				if a.Status == "resolved" && m.firingPriority(a) == PriorityEmergency {
+					if err := m.cancel(a.Fingerprint); err != nil {
+						return err
+					}
+				}
+				return m.sendMessage(msg)
+			},
+		}}, nil
+	})
+}
+
+// priority returns the Pushover priority of the alert's notification.
+func (m *PushoverManager) priority(a alertmgrtmpl.Alert) int {
+	if a.Status == "resolved" {
+		return m.resolvedPriority
+	}
+	return m.firingPriority(a)
+}
+
+// firingPriority returns the Pushover priority of the alert when it's firing, from its severity label.
+func (m *PushoverManager) firingPriority(a alertmgrtmpl.Alert) int {
+	if p, ok := m.priorities[a.Labels[m.severityLabel]]; ok {
+		return p
+	}
+	return m.defaultPriority
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *PushoverManager) Room() string {
+	return m.room
+}
+
+// ID returns the provider name.
+func (m *PushoverManager) ID() string {
+	return "pushover"
+}
+
+func mapValues(m map[string]int) []int {
+	out := make([]int, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	return out
+}
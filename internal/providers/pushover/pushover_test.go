@@ -0,0 +1,146 @@
+package pushover
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPushover(t *testing.T, endpoint string) *PushoverManager {
+	m, err := NewPushover(PushoverOpts{
+		Log:              slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:          metrics.New("calert"),
+		Endpoint:         endpoint,
+		Room:             "phone",
+		Template:         "../../../static/pushover.tmpl",
+		Timeout:          5 * time.Second,
+		RetryWaitMin:     10 * time.Millisecond,
+		RetryWaitMax:     10 * time.Millisecond,
+		Token:            "app",
+		UserKey:          "user",
+		Sound:            "siren",
+		Priorities:       map[string]int{"page": PriorityEmergency},
+		ResolvedPriority: PriorityLow,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	type received struct {
+		path string
+		form url.Values
+	}
+	var reqs []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, r.ParseForm())
+		reqs = append(reqs, received{path: r.URL.Path, form: r.PostForm})
+		w.Write([]byte(`{"status":1,"request":"647d2300-702c-4b38-8b2f-d56326ae460b"}`))
+	}))
+	defer server.Close()
+
+	m := newTestPushover(t, server.URL+"/")
+	alert := alertmgrtmpl.Alert{
+		Fingerprint:  "abc",
+		Status:       "firing",
+		Labels:       alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page", "instance": "db-1"},
+		Annotations:  alertmgrtmpl.KV{"summary": "/var is > 90% full", "calert_escalation_mention": "@oncall"},
+		GeneratorURL: "http://prometheus/graph",
+	}
+	warning := alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "HighLoad", "severity": "warning"}}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, warning}))
+	alert.Status = "resolved"
+	warning.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert, warning}))
+
+	require.Len(t, reqs, 5)
+	assert.Equal(t, "/1/messages.json", reqs[0].path)
+	assert.Equal(t, url.Values{
+		"token":    {"app"},
+		"user":     {"user"},
+		"title":    {"[FIRING] DiskFull on db-1"},
+		"message":  {"Summary: /var is > 90% full"},
+		"url":      {"http://prometheus/graph"},
+		"priority": {"2"},
+		"sound":    {"siren"},
+		"retry":    {"60"},
+		"expire":   {"3600"},
+		"tags":     {"abc,DiskFull"},
+	}, reqs[0].form)
+
+	// Messages without annotations have the title as the message.
+	assert.Equal(t, "0", reqs[1].form.Get("priority"))
+	assert.Equal(t, "[FIRING] HighLoad", reqs[1].form.Get("message"))
+	assert.NotContains(t, reqs[1].form, "tags")
+
+	// The retries of the emergency message are cancelled when it's resolved.
+	assert.Equal(t, "/1/receipts/cancel_by_tag/abc.json", reqs[2].path)
+	assert.Equal(t, url.Values{"token": {"app"}}, reqs[2].form)
+	assert.Equal(t, "[RESOLVED] DiskFull on db-1", reqs[3].form.Get("title"))
+	assert.Equal(t, "-1", reqs[3].form.Get("priority"))
+	assert.Equal(t, "/1/messages.json", reqs[4].path)
+	assert.Equal(t, "-1", reqs[4].form.Get("priority"))
+}
+
+func TestPushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"user":"invalid","errors":["user identifier is invalid"],"status":0,"request":"5042853c-402d-4a18-abcb-168734a801de"}`))
+	}))
+	defer server.Close()
+
+	m := newTestPushover(t, server.URL)
+	err := m.Push([]alertmgrtmpl.Alert{
+		{Fingerprint: "abc", Status: "firing", Labels: alertmgrtmpl.KV{"alertname": "DiskFull"}},
+		// Resolved emergency alerts aren't sent if their retries can't be cancelled.
+		{Fingerprint: "def", Status: "resolved", Labels: alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "page"}},
+	})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 2)
+	assert.ErrorContains(t, err, "400: user identifier is invalid")
+}
+
+func TestNewPushover(t *testing.T) {
+	opts := PushoverOpts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:    metrics.New("calert"),
+		Template:   "../../../static/pushover.tmpl",
+		Priorities: map[string]int{"page": 3},
+	}
+	_, err := NewPushover(opts)
+	assert.ErrorContains(t, err, "token and user_key are required")
+
+	opts.Token = "app"
+	opts.UserKey = "user"
+	_, err = NewPushover(opts)
+	assert.ErrorContains(t, err, "invalid priority 3")
+
+	opts.Priorities = nil
+	opts.ResolvedPriority = PriorityEmergency
+	_, err = NewPushover(opts)
+	assert.ErrorContains(t, err, "resolved priority can't be 2")
+
+	opts.ResolvedPriority = PriorityLow
+	opts.Expire = 24 * time.Hour
+	_, err = NewPushover(opts)
+	assert.ErrorContains(t, err, "expire at most 3h0m0s")
+
+	// The message block is required.
+	opts.Expire = 0
+	opts.Template = "../../../static/pagerduty.tmpl"
+	_, err = NewPushover(opts)
+	assert.ErrorContains(t, err, `should define the "message" block`)
+}
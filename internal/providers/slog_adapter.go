@@ -1,35 +1,63 @@
-package google_chat
+package providers
 
 import (
 	"fmt"
 	"log/slog"
+	"strings"
 )
 
 // slogAdapter implements the retryablehttp.LeveledLogger interface using slog.
 type slogAdapter struct {
 	logger *slog.Logger
+	// redact replaces secrets in the logs, eg tokens in URLs. Optional.
+	redact *strings.Replacer
+}
+
+// scrub redacts secrets from the message and the string or error values.
+func (adpt *slogAdapter) scrub(msg string, keysAndValues []interface{}) (string, []interface{}) {
+	if adpt.redact == nil {
+		return msg, keysAndValues
+	}
+
+	out := make([]interface{}, len(keysAndValues))
+	for i, v := range keysAndValues {
+		switch v := v.(type) {
+		case string:
+			out[i] = adpt.redact.Replace(v)
+		case error:
+			out[i] = adpt.redact.Replace(v.Error())
+		default:
+			out[i] = v
+		}
+	}
+	return adpt.redact.Replace(msg), out
 }
 
 // Implements for the retryablehttp.LeveledLogger
 // ref. https://pkg.go.dev/github.com/hashicorp/go-retryablehttp#LeveledLogger
 func (adpt *slogAdapter) Error(msg string, keysAndValues ...interface{}) {
+	msg, keysAndValues = adpt.scrub(msg, keysAndValues)
 	adpt.logger.Error(msg, keysAndValues...)
 }
 
 func (adpt *slogAdapter) Info(msg string, keysAndValues ...interface{}) {
+	msg, keysAndValues = adpt.scrub(msg, keysAndValues)
 	adpt.logger.Info(msg, keysAndValues...)
 }
 
 func (adpt *slogAdapter) Debug(msg string, keysAndValues ...interface{}) {
+	msg, keysAndValues = adpt.scrub(msg, keysAndValues)
 	adpt.logger.Debug(msg, keysAndValues...)
 }
 
 func (adpt *slogAdapter) Warn(msg string, keysAndValues ...interface{}) {
+	msg, keysAndValues = adpt.scrub(msg, keysAndValues)
 	adpt.logger.Warn(msg, keysAndValues...)
 }
 
 // Implements for the retryablehttp.Logger
 // ref. https://pkg.go.dev/github.com/hashicorp/go-retryablehttp#Logger
 func (adpt *slogAdapter) Printf(format string, args ...interface{}) {
-	adpt.logger.Info(fmt.Sprintf(format, args...))
+	msg, _ := adpt.scrub(fmt.Sprintf(format, args...), nil)
+	adpt.logger.Info(msg)
 }
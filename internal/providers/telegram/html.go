@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// reHTMLToken matches the tags and entities of HTML messages, which can't be split.
+var reHTMLToken = regexp.MustCompile(`<[^>]*>|&#?[0-9A-Za-z]+;`)
+
+// htmlMarkup is the syntax of HTML messages.
+var htmlMarkup = markup{
+	token:   reHTMLToken,
+	track:   trackHTML,
+	closing: closingTags,
+}
+
+// splitHTML splits the HTML text in chunks of at most size bytes, without
+// splitting tags or entities, and closes and reopens the elements which are
+// open at the end of a chunk. See split.
+func splitHTML(text string, size int) []string {
+	return split(text, size, htmlMarkup)
+}
+
+// trackHTML returns the elements which are open after the text, given the ones
+// which are open before it.
+func trackHTML(open []entity, text string) []entity {
+	open = slices.Clone(open)
+	for _, tok := range reHTMLToken.FindAllString(text, -1) {
+		if !strings.HasPrefix(tok, "<") || strings.HasSuffix(tok, "/>") {
+			continue
+		}
+
+		name := strings.TrimPrefix(tok[1:len(tok)-1], "/")
+		if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+			name = name[:i]
+		}
+		name = strings.ToLower(name)
+
+		if !strings.HasPrefix(tok, "</") {
+			open = append(open, entity{name: name, open: tok})
+			continue
+		}
+		// A closing tag closes the last element with its name, and the ones in it.
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i].name == name {
+				open = open[:i]
+				break
+			}
+		}
+	}
+
+	return open
+}
+
+// closingTags returns the tags which close the open elements, innermost first.
+func closingTags(open []entity) string {
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].name + ">")
+	}
+	return b.String()
+}
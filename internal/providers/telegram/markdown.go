@@ -0,0 +1,73 @@
+package telegram
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// reMarkdownV2Token matches the escapes, links and entity markers of MarkdownV2
+// messages, which can't be split. Pre blocks are opened with their language.
+// ref. https://core.telegram.org/bots/api#markdownv2-style
+var reMarkdownV2Token = regexp.MustCompile("\\\\.|\\[(?:\\\\.|[^\\]\\\\])*\\]\\((?:\\\\.|[^)\\\\])*\\)|```[^\\s`]*|`|\\|\\||__|[*_~]")
+
+// markdownV2Markup is the syntax of MarkdownV2 messages.
+var markdownV2Markup = markup{
+	token:   reMarkdownV2Token,
+	track:   trackMarkdownV2,
+	closing: closingMarkers,
+}
+
+// splitMarkdownV2 splits the MarkdownV2 text in chunks of at most size bytes,
+// without splitting escapes, links or markers, and closes and reopens the
+// entities which are open at the end of a chunk. See split.
+func splitMarkdownV2(text string, size int) []string {
+	return split(text, size, markdownV2Markup)
+}
+
+// trackMarkdownV2 returns the entities which are open after the text, given the
+// ones which are open before it.
+func trackMarkdownV2(open []entity, text string) []entity {
+	open = slices.Clone(open)
+	for _, tok := range reMarkdownV2Token.FindAllString(text, -1) {
+		if strings.HasPrefix(tok, `\`) || strings.HasPrefix(tok, "[") {
+			continue
+		}
+
+		name, reopen := tok, tok
+		if strings.HasPrefix(tok, "```") {
+			// The language of a pre block ends at the end of the line.
+			name, reopen = "```", tok+"\n"
+		}
+
+		// Markers are literal in code, until the code is closed.
+		if n := len(open); n > 0 && (open[n-1].name == "`" || open[n-1].name == "```") {
+			if name == open[n-1].name {
+				open = open[:n-1]
+			}
+			continue
+		}
+
+		// A marker closes the last entity it opened, and the ones in it.
+		closed := false
+		for i := len(open) - 1; i >= 0 && !closed; i-- {
+			if open[i].name == name {
+				open, closed = open[:i], true
+			}
+		}
+		if !closed {
+			open = append(open, entity{name: name, open: reopen})
+		}
+	}
+
+	return open
+}
+
+// closingMarkers returns the markers which close the open entities, innermost first.
+func closingMarkers(open []entity) string {
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString(open[i].name)
+	}
+	return b.String()
+}
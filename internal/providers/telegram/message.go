@@ -0,0 +1,138 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// maxMessageSize is the maximum length of the text of a message.
+// ref. https://core.telegram.org/bots/api#sendmessage
+const maxMessageSize = 4096
+
+// message is the payload of the sendMessage method.
+// ref. https://core.telegram.org/bots/api#sendmessage
+type message struct {
+	ChatID                   string `json:"chat_id"`
+	Text                     string `json:"text"`
+	ParseMode                string `json:"parse_mode,omitempty"`
+	ReplyToMessageID         int64  `json:"reply_to_message_id,omitempty"`
+	AllowSendingWithoutReply bool   `json:"allow_sending_without_reply,omitempty"`
+}
+
+// response is the response of the Bot API.
+// ref. https://core.telegram.org/bots/api#making-requests
+type response struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageID int64 `json:"message_id"`
+	} `json:"result"`
+}
+
+// markdownV2Replacer escapes the characters which are reserved in MarkdownV2.
+// ref. https://core.telegram.org/bots/api#markdownv2-style
+var markdownV2Replacer = func() *strings.Replacer {
+	var pairs []string
+	for _, c := range "\\_*[]()~`>#+-=|{}.!" {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// escapeMarkdownV2 escapes the value to be used as text in MarkdownV2 messages.
+func escapeMarkdownV2(v any) string {
+	return markdownV2Replacer.Replace(fmt.Sprintf("%v", v))
+}
+
+// mentionFormat returns the format of mentions for the parse mode. Numeric user
+// IDs are mentioned with a link, and usernames with an @.
+// ref. https://core.telegram.org/bots/api#formatting-options
+func mentionFormat(parseMode string) mentions.Format {
+	return func(id string) string {
+		_, err := strconv.ParseInt(id, 10, 64)
+		switch {
+		case err != nil && parseMode == ParseModeMarkdownV2:
+			return escapeMarkdownV2("@" + id)
+		case err != nil && parseMode == ParseModeHTML:
+			return html.EscapeString("@" + id)
+		case err != nil:
+			return "@" + id
+		case parseMode == ParseModeMarkdownV2:
+			return fmt.Sprintf("[%s](tg://user?id=%s)", id, id)
+		case parseMode == ParseModeHTML:
+			return fmt.Sprintf(`<a href="tg://user?id=%s">%s</a>`, id, id)
+		}
+		// Plain text can't link to users.
+		return id
+	}
+}
+
+// prepareMessages renders the template for the alert, split at the maximum length of messages.
+// HTML and MarkdownV2 messages are split between tags, escapes and markers, and each part
+// closes the entities it leaves open.
+func (m *TelegramManager) prepareMessages(a alertmgrtmpl.Alert) ([]string, error) {
+	var buf bytes.Buffer
+	if err := m.msgTmpl.Execute(&buf, a); err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(buf.String())
+	switch m.parseMode {
+	case ParseModeHTML:
+		return splitHTML(text, maxMessageSize), nil
+	case ParseModeMarkdownV2:
+		return splitMarkdownV2(text, maxMessageSize), nil
+	}
+	return providers.SplitText(text, maxMessageSize), nil
+}
+
+// sendMessage sends the text to the chat, as a reply to `replyTo` if it's set,
+// and returns the ID of the message.
+func (m *TelegramManager) sendMessage(text string, replyTo int64) (int64, error) {
+	out, err := json.Marshal(message{
+		ChatID:           m.chatID,
+		Text:             text,
+		ParseMode:        m.parseMode,
+		ReplyToMessageID: replyTo,
+		// Don't fail if the original message was deleted.
+		AllowSendingWithoutReply: replyTo != 0,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	m.lo.Debug("sending alert", "chat_id", m.chatID, "payload", string(out))
+	resp, err := m.client.Post(m.endpoint+"/bot"+m.botToken+"/sendMessage", "application/json", bytes.NewBuffer(out))
+	if err != nil {
+		// Errors of the client have the URL, which has the token.
+		return 0, errors.New(strings.ReplaceAll(err.Error(), m.botToken, "<redacted>"))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil || resp.StatusCode != http.StatusOK || !r.OK {
+		m.lo.Debug("Non OK HTTP Response received from Telegram Bot API", "status", resp.StatusCode, "responseBody", string(body))
+		if r.Description != "" {
+			return 0, fmt.Errorf("non ok response from telegram: %d: %s", resp.StatusCode, r.Description)
+		}
+		return 0, fmt.Errorf("non ok response from telegram: %d", resp.StatusCode)
+	}
+
+	return r.Result.MessageID, nil
+}
@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// entity is a formatting entity which is open in a message, like an HTML element.
+type entity struct {
+	name string
+	open string
+}
+
+// markup is the formatting syntax of a parse mode, to split messages without
+// breaking their formatting.
+type markup struct {
+	// token matches the parts of messages which can't be split, like tags or escapes.
+	token *regexp.Regexp
+	// track returns the entities which are open after the text, given the ones
+	// which are open before it.
+	track func(open []entity, text string) []entity
+	// closing returns the text which closes the open entities, innermost first.
+	closing func(open []entity) string
+}
+
+// splitter splits formatted messages in chunks. See split.
+type splitter struct {
+	markup
+	size int
+	out  []string
+	buf  strings.Builder
+	// open are the entities which are open at the end of buf, and reopened is
+	// the length of the text which reopened them at the start of buf.
+	open     []entity
+	reopened int
+}
+
+// split splits the text at line boundaries in chunks of at most size bytes, like
+// providers.SplitText, without splitting the tokens of the markup. Entities which
+// are open at the end of a chunk are closed, and reopened at the start of the next
+// one, so that each chunk is valid on its own.
+func split(text string, size int, m markup) []string {
+	s := &splitter{markup: m, size: size}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if !s.fits(line) {
+			s.flush()
+		}
+		if s.fits(line) {
+			s.write(line)
+			continue
+		}
+
+		// The line doesn't fit in a message, so it's split between tokens.
+		last := 0
+		for _, loc := range s.token.FindAllStringIndex(line, -1) {
+			s.writeText(line[last:loc[0]])
+			s.writeToken(line[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		s.writeText(line[last:])
+	}
+	s.flush()
+
+	return s.out
+}
+
+// writeToken writes a token, in the next chunk if it doesn't fit.
+// Tokens which are too long for a message anyway aren't split.
+func (s *splitter) writeToken(tok string) {
+	if !s.fits(tok) {
+		s.flush()
+	}
+	s.write(tok)
+}
+
+// writeText writes the text, splitting it across chunks without splitting
+// multi-byte characters.
+func (s *splitter) writeText(text string) {
+	for text != "" {
+		n := s.size - s.buf.Len() - len(s.closing(s.open))
+		if len(text) <= n {
+			s.write(text)
+			return
+		}
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		if n <= 0 {
+			if !s.empty() {
+				s.flush()
+				continue
+			}
+			// Nothing fits after the reopened entities, but the text has to go somewhere.
+			_, n = utf8.DecodeRuneInString(text)
+		}
+		s.write(text[:n])
+		text = text[n:]
+		s.flush()
+	}
+}
+
+// fits returns whether the text fits in the current chunk, along with the text
+// which closes the entities which are still open after it.
+func (s *splitter) fits(text string) bool {
+	return s.buf.Len()+len(text)+len(s.closing(s.track(s.open, text))) <= s.size
+}
+
+func (s *splitter) write(text string) {
+	s.buf.WriteString(text)
+	s.open = s.track(s.open, text)
+}
+
+// empty returns whether the current chunk only has the reopened entities.
+func (s *splitter) empty() bool {
+	return s.buf.Len() <= s.reopened
+}
+
+// flush closes the open entities to end the current chunk, and reopens them
+// at the start of the next one.
+func (s *splitter) flush() {
+	if s.empty() {
+		return
+	}
+	s.out = append(s.out, s.buf.String()+s.closing(s.open))
+
+	s.buf.Reset()
+	for _, e := range s.open {
+		s.buf.WriteString(e.open)
+	}
+	s.reopened = s.buf.Len()
+}
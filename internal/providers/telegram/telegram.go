@@ -0,0 +1,205 @@
+// Package telegram sends alerts to Telegram chats with the Bot API.
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	"github.com/mr-karan/calert/internal/state"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// Parse modes of messages.
+// ref. https://core.telegram.org/bots/api#formatting-options
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+type TelegramManager struct {
+	lo           *slog.Logger
+	activeAlerts *state.ActiveAlerts
+	dispatcher   *providers.Dispatcher
+	endpoint     string
+	botToken     string
+	chatID       string
+	parseMode    string
+	room         string
+	client       *retryablehttp.Client
+	msgTmpl      *template.Template
+}
+
+type TelegramOpts struct {
+	Log         *slog.Logger
+	Metrics     *metrics.Manager
+	DryRun      bool
+	MaxIdleConn int
+	Timeout     time.Duration
+	ProxyURL    string
+	// Endpoint is the base URL of the Bot API, eg https://api.telegram.org.
+	Endpoint  string
+	Room      string
+	Template  string
+	ThreadTTL time.Duration
+	// PruneInterval is how often alerts past the ThreadTTL are pruned. Defaults to 1h.
+	PruneInterval time.Duration
+	// MaxActiveAlerts bounds the number of active alerts kept for replies. 0 means unbounded.
+	MaxActiveAlerts int
+	RetryMax        int
+	RetryWaitMin    time.Duration
+	RetryWaitMax    time.Duration
+
+	BotToken string
+	ChatID   string
+	// ParseMode is the formatting of the rendered template, HTML or MarkdownV2.
+	// Messages are sent as plain text if it's empty.
+	ParseMode string
+
+	providers.Shared
+	providers.BreakerOpts
+}
+
+// NewTelegram initializes a Telegram provider object.
+func NewTelegram(opts TelegramOpts) (*TelegramManager, error) {
+	if opts.BotToken == "" || opts.ChatID == "" {
+		return nil, fmt.Errorf("bot_token and chat_id are required")
+	}
+	switch opts.ParseMode {
+	case "", ParseModeHTML, ParseModeMarkdownV2:
+	default:
+		return nil, fmt.Errorf("invalid parse_mode %s, should be %s or %s", opts.ParseMode, ParseModeHTML, ParseModeMarkdownV2)
+	}
+
+	// The Bot API rate limits requests and sends the time to wait in the body of 429 responses.
+	client, err := providers.NewHTTPClient(providers.HTTPOpts{
+		Log:          opts.Log,
+		MaxIdleConn:  opts.MaxIdleConn,
+		Timeout:      opts.Timeout,
+		ProxyURL:     opts.ProxyURL,
+		RetryMax:     opts.RetryMax,
+		RetryWaitMin: opts.RetryWaitMin,
+		RetryWaitMax: opts.RetryWaitMax,
+		Redact:       []string{opts.BotToken},
+		// ref. https://core.telegram.org/bots/api#responseparameters
+		RetryAfter: providers.RetryAfterJSON(time.Second, "parameters", "retry_after"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	templateFuncMap := providers.TemplateFuncs(providers.TemplateOpts{
+		Mentions:      opts.Mentions,
+		MentionFormat: mentionFormat(opts.ParseMode),
+		OnCall:        opts.OnCall,
+	})
+	templateFuncMap["EscapeMarkdownV2"] = escapeMarkdownV2
+
+	// Load the template.
+	tmpl, err := template.New(filepath.Base(opts.Template)).Funcs(templateFuncMap).ParseFiles(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlerts := state.New(state.Opts{
+		Log:        opts.Log,
+		Metrics:    opts.Metrics,
+		Room:       opts.Room,
+		TTL:        opts.ThreadTTL,
+		MaxEntries: opts.MaxActiveAlerts,
+	})
+
+	mgr := &TelegramManager{
+		lo:           opts.Log,
+		activeAlerts: activeAlerts,
+		dispatcher: &providers.Dispatcher{
+			Log:          opts.Log,
+			Metrics:      opts.Metrics,
+			History:      opts.History,
+			Provider:     "telegram",
+			Room:         opts.Room,
+			DryRun:       opts.DryRun,
+			Breaker:      providers.NewBreaker(opts.Log, opts.Metrics, "telegram", opts.Room, opts.BreakerOpts),
+			ActiveAlerts: activeAlerts,
+			ThreadKey:    providers.MessageID(activeAlerts),
+		},
+		endpoint:  strings.TrimSuffix(opts.Endpoint, "/"),
+		botToken:  opts.BotToken,
+		chatID:    opts.ChatID,
+		parseMode: opts.ParseMode,
+		room:      opts.Room,
+		client:    client,
+		msgTmpl:   tmpl,
+	}
+
+	// Start a background worker to cleanup alerts based on TTL mechanism.
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = time.Hour
+	}
+	go mgr.activeAlerts.StartPruneWorker(opts.PruneInterval)
+
+	return mgr, nil
+}
+
+// Push accepts the list of alerts and dispatches them to the chat. Messages for
+// an alert are sent as replies to its first message. Alerts which couldn't be
+// sent after retries are returned as a *providers.PushError.
+func (m *TelegramManager) Push(alerts []alertmgrtmpl.Alert) error {
+	return m.dispatcher.Push(alerts, func(a alertmgrtmpl.Alert) ([]providers.Message, error) {
+		texts, err := m.prepareMessages(a)
+		if err != nil {
+			return nil, err
+		}
+		msgs := make([]providers.Message, 0, len(texts))
+		for _, text := range texts {
+			msgs = append(msgs, providers.Message{
+				Text: text,
+				Send: func() error { return m.sendReply(a.Fingerprint, text) },
+			})
+		}
+		return msgs, nil
+	})
+}
+
+// sendReply sends the message as a reply to the first message for the alert.
+// The ID of the first message is kept in the active alerts.
+func (m *TelegramManager) sendReply(fingerprint, text string) error {
+	a, _ := m.activeAlerts.Get(fingerprint)
+
+	var replyTo int64
+	if a.MessageID != "" {
+		replyTo, _ = strconv.ParseInt(a.MessageID, 10, 64)
+	}
+
+	id, err := m.sendMessage(text, replyTo)
+	if err != nil {
+		return err
+	}
+	if a.MessageID == "" {
+		m.activeAlerts.SetMessageID(fingerprint, strconv.FormatInt(id, 10))
+	}
+
+	return nil
+}
+
+// Room returns the name of room for which this provider is configured.
+func (m *TelegramManager) Room() string {
+	return m.room
+}
+
+// ActiveAlerts returns the active alerts tracked for the room.
+func (m *TelegramManager) ActiveAlerts() *state.ActiveAlerts {
+	return m.activeAlerts
+}
+
+// ID returns the provider name.
+func (m *TelegramManager) ID() string {
+	return "telegram"
+}
@@ -0,0 +1,244 @@
+package telegram
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	"github.com/mr-karan/calert/internal/providers"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stub is a local Bot API which records the messages sent to it.
+type stub struct {
+	msgs []message
+	// fail makes the stub respond with an error for the first requests.
+	fail       int
+	failStatus int
+	failBody   string
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/bottoken/sendMessage" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if s.fail > 0 {
+		s.fail--
+		w.WriteHeader(s.failStatus)
+		w.Write([]byte(s.failBody))
+		return
+	}
+
+	var msg message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.msgs = append(s.msgs, msg)
+
+	var resp response
+	resp.OK = true
+	resp.Result.MessageID = int64(len(s.msgs)) * 10
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestTelegram(t *testing.T, endpoint, tmpl string) *TelegramManager {
+	if tmpl == "" {
+		tmpl = "../../../static/telegram.tmpl"
+	}
+	m, err := NewTelegram(TelegramOpts{
+		Log:          slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		Metrics:      metrics.New("calert"),
+		Endpoint:     endpoint,
+		Room:         "homelab",
+		Template:     tmpl,
+		Timeout:      5 * time.Second,
+		ThreadTTL:    time.Hour,
+		RetryMax:     1,
+		RetryWaitMin: 10 * time.Second,
+		RetryWaitMax: 10 * time.Second,
+		BotToken:     "token",
+		ChatID:       "-100123",
+		ParseMode:    ParseModeHTML,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPush(t *testing.T) {
+	s := &stub{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	m := newTestTelegram(t, server.URL+"/", "")
+	alert := alertmgrtmpl.Alert{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Labels:      alertmgrtmpl.KV{"alertname": "DiskFull", "severity": "critical"},
+		Annotations: alertmgrtmpl.KV{"summary": "/var is > 90% full"},
+	}
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "def", Status: "firing"}}))
+	alert.Status = "resolved"
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{alert}))
+
+	require.Len(t, s.msgs, 3)
+	assert.Equal(t, "-100123", s.msgs[0].ChatID)
+	assert.Equal(t, ParseModeHTML, s.msgs[0].ParseMode)
+	assert.Equal(t, "<b>(CRITICAL) Diskfull - Firing</b>\n\n<i>summary</i>: /var is &gt; 90% full", s.msgs[0].Text)
+	// Only later messages for the same alert are replies to the first one.
+	assert.Zero(t, s.msgs[0].ReplyToMessageID)
+	assert.Zero(t, s.msgs[1].ReplyToMessageID)
+	assert.Equal(t, int64(10), s.msgs[2].ReplyToMessageID)
+	assert.True(t, s.msgs[2].AllowSendingWithoutReply)
+
+	a, ok := m.ActiveAlerts().Alert("abc")
+	require.True(t, ok)
+	assert.Equal(t, "10", a.MessageID)
+}
+
+func TestPushSplit(t *testing.T) {
+	s := &stub{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	tmpl := filepath.Join(t.TempDir(), "long.tmpl")
+	require.NoError(t, os.WriteFile(tmpl, []byte(`{{ .Annotations.description }}`), 0o600))
+	m := newTestTelegram(t, server.URL, tmpl)
+
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{
+		Fingerprint: "abc",
+		Status:      "firing",
+		Annotations: alertmgrtmpl.KV{"description": strings.Repeat("ü", 5000)},
+	}}))
+
+	// Messages are split at the limit in bytes, which is within the limit in characters.
+	require.Len(t, s.msgs, 3)
+	var text string
+	for _, msg := range s.msgs {
+		assert.LessOrEqual(t, len(msg.Text), maxMessageSize)
+		text += msg.Text
+	}
+	assert.Equal(t, strings.Repeat("ü", 5000), text)
+	// The rest of the message is a reply to its first part.
+	assert.Equal(t, int64(10), s.msgs[1].ReplyToMessageID)
+	assert.Equal(t, int64(10), s.msgs[2].ReplyToMessageID)
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := &stub{
+		fail:       1,
+		failStatus: http.StatusTooManyRequests,
+		failBody:   `{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 1", "parameters": {"retry_after": 1}}`,
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	// The wait in the body is used instead of the 10s backoff.
+	m := newTestTelegram(t, server.URL, "")
+	start := time.Now()
+	require.NoError(t, m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}}))
+	assert.Len(t, s.msgs, 1)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestPushError(t *testing.T) {
+	s := &stub{
+		fail:       1,
+		failStatus: http.StatusBadRequest,
+		failBody:   `{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`,
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	m := newTestTelegram(t, server.URL, "")
+	err := m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+
+	var pErr *providers.PushError
+	require.ErrorAs(t, err, &pErr)
+	assert.Len(t, pErr.Alerts, 1)
+	assert.ErrorContains(t, err, "chat not found")
+
+	// Errors from the client don't leak the token in the URL.
+	m = newTestTelegram(t, "http://127.0.0.1:1", "")
+	m.client.RetryMax = 0
+	err = m.Push([]alertmgrtmpl.Alert{{Fingerprint: "abc", Status: "firing"}})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "bottoken")
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `cpu\_usage \> 90% \(node\-1\.example\)\!`, escapeMarkdownV2("cpu_usage > 90% (node-1.example)!"))
+	assert.Equal(t, `a\\b \*c\* \[d\]\{e\}`, escapeMarkdownV2(`a\b *c* [d]{e}`))
+}
+
+func TestMentionFormat(t *testing.T) {
+	tests := []struct {
+		parseMode, id, want string
+	}{
+		{ParseModeHTML, "123", `<a href="tg://user?id=123">123</a>`},
+		{ParseModeHTML, "jane_doe", "@jane_doe"},
+		{ParseModeMarkdownV2, "123", "[123](tg://user?id=123)"},
+		{ParseModeMarkdownV2, "jane_doe", `@jane\_doe`},
+		{"", "123", "123"},
+		{"", "jane_doe", "@jane_doe"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, mentionFormat(tt.parseMode)(tt.id), tt.parseMode+" "+tt.id)
+	}
+}
+
+func TestSplitHTML(t *testing.T) {
+	// Lines are kept whole, and open elements are closed and reopened.
+	text := "<b>Disk full</b>\n<pre><code class=\"language-text\">line one\nline two\n</code></pre>"
+	assert.Equal(t, []string{text}, splitHTML(text, 100))
+	assert.Equal(t, []string{
+		"<b>Disk full</b>\n",
+		"<pre><code class=\"language-text\">line one\n</code></pre>",
+		"<pre><code class=\"language-text\">line two\n</code></pre>",
+	}, splitHTML(text, 60))
+
+	// Long lines are split between tags and entities, and characters.
+	assert.Equal(t, []string{
+		"<i>aaaa</i>",
+		"<i>&gt;</i>",
+		"<i>üü</i>",
+		"<i>ü</i>",
+	}, splitHTML("<i>aaaa&gt;üüü</i>", 11))
+
+	// Unclosed tags in the text don't break the chunks which follow.
+	for _, chunk := range splitHTML("<b>"+strings.Repeat("x\n", 20), 16) {
+		assert.LessOrEqual(t, len(chunk), 16)
+		assert.True(t, strings.HasPrefix(chunk, "<b>") && strings.HasSuffix(chunk, "</b>"), chunk)
+	}
+}
+
+func TestSplitMarkdownV2(t *testing.T) {
+	// Lines are kept whole, and open entities are closed and reopened, pre blocks with their language.
+	text := "*Disk full*\n```text\nline one\nline two\n```"
+	assert.Equal(t, []string{text}, splitMarkdownV2(text, 100))
+	assert.Equal(t, []string{
+		"*Disk full*\n```text\n```",
+		"```text\nline one\n```",
+		"```text\nline two\n```",
+	}, splitMarkdownV2(text, 25))
+
+	// Entities which straddle the limit are closed and reopened.
+	assert.Equal(t, []string{"*bold t*", "*ext*"}, splitMarkdownV2("*bold text*", 8))
+
+	// Escapes and links aren't split, and markers are literal in code.
+	assert.Equal(t, []string{"aaaa", `\.bbb`, "b"}, splitMarkdownV2(`aaaa\.bbbb`, 5))
+	assert.Equal(t, []string{"see ", `[the runbook](https://x\.io)`, " now"}, splitMarkdownV2(`see [the runbook](https://x\.io) now`, 20))
+	assert.Equal(t, []string{"_a `*x`_", "_`*` b_"}, splitMarkdownV2("_a `*x*` b_", 8))
+}
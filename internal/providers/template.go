@@ -0,0 +1,123 @@
+package providers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mr-karan/calert/internal/mentions"
+	"github.com/mr-karan/calert/internal/oncall"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// TemplateOpts represents the options for the template functions.
+type TemplateOpts struct {
+	// Mentions resolves label values to @-mentions in templates. Optional.
+	Mentions *mentions.Mapper
+	// MentionFormat is the mention syntax of the provider. Defaults to Google Chat's.
+	MentionFormat mentions.Format
+	// OnCall resolves the users on call for teams in templates. Optional.
+	OnCall *oncall.Resolver
+}
+
+// TemplateFuncs returns the message template functions common to all providers.
+// Providers can add their own functions to the map.
+func TemplateFuncs(opts TemplateOpts) template.FuncMap {
+	return template.FuncMap{
+		"Title": func(v any) string {
+			s := fmt.Sprintf("%v", v)
+			titleCaser := cases.Title(language.English)
+			return titleCaser.String(s)
+		},
+		"toUpper": func(v any) string {
+			return strings.ToUpper(fmt.Sprintf("%v", v))
+		},
+		"toLower": func(v any) string {
+			return strings.ToLower(fmt.Sprintf("%v", v))
+		},
+		"Contains": func(s, substr any) bool {
+			return strings.Contains(fmt.Sprintf("%v", s), fmt.Sprintf("%v", substr))
+		},
+		"HasPrefix": func(s, prefix any) bool {
+			return strings.HasPrefix(fmt.Sprintf("%v", s), fmt.Sprintf("%v", prefix))
+		},
+		"HasSuffix": func(s, suffix any) bool {
+			return strings.HasSuffix(fmt.Sprintf("%v", s), fmt.Sprintf("%v", suffix))
+		},
+		"Replace": func(s, old, new any) string {
+			return strings.ReplaceAll(fmt.Sprintf("%v", s), fmt.Sprintf("%v", old), fmt.Sprintf("%v", new))
+		},
+		"TrimSpace": func(v any) string {
+			return strings.TrimSpace(fmt.Sprintf("%v", v))
+		},
+		// EscapeHTML escapes the value for templates which are formatted as HTML.
+		"EscapeHTML": func(v any) string {
+			return html.EscapeString(fmt.Sprintf("%v", v))
+		},
+		"Default": func(defaultVal, val any) any {
+			if val == nil || fmt.Sprintf("%v", val) == "" {
+				return defaultVal
+			}
+			return val
+		},
+		"reReplaceAll": func(pattern, repl, text string) string {
+			re := regexp.MustCompile(pattern)
+			return re.ReplaceAllString(text, repl)
+		},
+		"CurrentTime": func(location ...string) string {
+			if len(location) == 0 || location[0] == "" {
+				return time.Now().Format("2006-01-02 15:04:05 MST")
+			}
+			loc, err := time.LoadLocation(location[0])
+			if err != nil {
+				return fmt.Sprintf("Error loading timezone: %v", err)
+			}
+			return time.Now().In(loc).Format("2006-01-02 15:04:05 MST")
+		},
+		"ConvertTZ": func(t time.Time, location string) string {
+			loc, err := time.LoadLocation(location)
+			if err != nil {
+				return fmt.Sprintf("Error loading timezone: %v", err)
+			}
+			return t.In(loc).Format("2006-01-02 15:04:05 MST")
+		},
+		"DurationSince": func(t time.Time) string {
+			d := time.Since(t)
+			h := int(d.Hours())
+			m := int(d.Minutes()) % 60
+			s := int(d.Seconds()) % 60
+			return fmt.Sprintf("%dh %dm %ds", h, m, s)
+		},
+		// mentions returns the mentions of the users mapped to a label value, eg `{{ mentions .Labels.team .Labels.severity }}`.
+		"mentions": func(value string, severity ...string) string {
+			return opts.Mentions.MentionsAs(opts.MentionFormat, value, severity...)
+		},
+		// oncall returns the users on call for a team, separated by commas, eg `{{ oncall .Labels.team .StartsAt }}`.
+		"oncall": func(team string, at ...time.Time) string {
+			t := time.Now()
+			if len(at) > 0 && !at[0].IsZero() {
+				t = at[0]
+			}
+			return strings.Join(opts.OnCall.OnCall(team, t), ",")
+		},
+	}
+}
+
+// ExecuteBlock renders the block of the template, trimmed of spaces. It returns
+// an empty string if the template doesn't define the block.
+func ExecuteBlock(tmpl *template.Template, block string, data any) (string, error) {
+	if tmpl.Lookup(block) == nil {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, block, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
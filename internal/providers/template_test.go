@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteBlock(t *testing.T) {
+	tmpl, err := template.New("test").Parse(`{{ define "title" }}  Disk {{ . }}
+{{ end }}{{ define "fail" }}{{ .Missing }}{{ end }}`)
+	require.NoError(t, err)
+
+	out, err := ExecuteBlock(tmpl, "title", "full")
+	require.NoError(t, err)
+	assert.Equal(t, "Disk full", out)
+
+	// Blocks which aren't defined are empty.
+	out, err = ExecuteBlock(tmpl, "tags", "full")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	_, err = ExecuteBlock(tmpl, "fail", "full")
+	assert.Error(t, err)
+}
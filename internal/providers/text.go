@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// SplitText splits the text at line boundaries in chunks of at most size bytes,
+// to fit the message size limits of providers. Lines longer than size are split
+// as is, without splitting multi-byte characters.
+func SplitText(text string, size int) []string {
+	var (
+		out []string
+		buf strings.Builder
+	)
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > size {
+			if buf.Len() > 0 {
+				out = append(out, buf.String())
+				buf.Reset()
+			}
+			cut := size
+			for cut > 1 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			out = append(out, line[:cut])
+			line = line[cut:]
+		}
+		if buf.Len()+len(line) > size {
+			out = append(out, buf.String())
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		out = append(out, buf.String())
+	}
+
+	return out
+}
+
+// Truncate shortens the text to at most size characters, ending it with an
+// ellipsis if it's shortened.
+func Truncate(text string, size int) string {
+	if utf8.RuneCountInString(text) <= size {
+		return text
+	}
+	r := []rune(text)
+	return string(r[:size-1]) + "…"
+}
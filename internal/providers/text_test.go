@@ -0,0 +1,22 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitText(t *testing.T) {
+	assert.Equal(t, []string{"ab\n", "cd\n"}, SplitText("ab\ncd\n", 4))
+	assert.Equal(t, []string{"ab\ncd\n"}, SplitText("ab\ncd\n", 6))
+	assert.Equal(t, []string{"abc", "def", "g\nh"}, SplitText("abcdefg\nh", 3))
+	assert.Empty(t, SplitText("", 3))
+	// Multi-byte characters aren't split.
+	assert.Equal(t, []string{"a", "é", "b"}, SplitText("aéb", 2))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", Truncate("abc", 3))
+	assert.Equal(t, "ab…", Truncate("abcd", 3))
+	assert.Equal(t, "éé…", Truncate("éééé", 3))
+}
@@ -0,0 +1,531 @@
+// Package state tracks the state of active alerts per room. Providers use it
+// to send all the notifications for an alert in the same thread.
+package state
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/mr-karan/calert/internal/metrics"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+)
+
+// ErrNotFound is returned when the alert isn't in the active alerts map.
+var ErrNotFound = errors.New("alert not found in active alerts")
+
+// SnapshotVersion is the version of the snapshot format. It's bumped
+// whenever the format changes in a way older versions can't read.
+const SnapshotVersion = 1
+
+// Snapshot is the exported state of the active alerts of all rooms.
+type Snapshot struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Rooms      map[string][]Alert `json:"rooms"`
+}
+
+// ActiveAlerts represents a map of alerts unique fingerprint hash
+// with their details.
+type ActiveAlerts struct {
+	lo         *slog.Logger
+	metrics    *metrics.Manager
+	room       string
+	ttl        time.Duration
+	maxEntries int
+	sync.RWMutex
+	alerts map[string]AlertDetails
+	// lru has the fingerprints of the alerts, most recently seen first, and
+	// elems their elements, to evict the least recently seen alerts in O(1).
+	lru   list.List
+	elems map[string]*list.Element
+}
+
+// Opts represents the options for ActiveAlerts.
+type Opts struct {
+	Log     *slog.Logger
+	Metrics *metrics.Manager
+	// Room is the room whose alerts are tracked, used in metrics.
+	Room string
+	// TTL is how long alerts are kept in the map after their last notification.
+	TTL time.Duration
+	// MaxEntries bounds the size of the map. Once it's full, the least recently
+	// seen alerts are evicted. 0 means unbounded.
+	MaxEntries int
+}
+
+// AlertDetails represents some internal fields required
+// for dispatching alerts or cleaning up based on TTL.
+type AlertDetails struct {
+	StartsAt time.Time
+	UUID     uuid.UUID
+	// Status is the status of the last notification for the alert.
+	Status string
+	// LastSeen is the time of the last notification for the alert.
+	LastSeen time.Time
+	// Hash identifies the content of the last delivered notification, and
+	// DeliveredAt is when it was delivered. They're used to skip duplicates.
+	Hash        string
+	DeliveredAt time.Time
+	// AckedBy and AckedAt are set once someone acknowledges the alert.
+	AckedBy string
+	AckedAt time.Time
+	// MessageID is the ID the provider assigned to the first message for the alert,
+	// for providers which thread later messages as replies to it.
+	MessageID string
+	// Labels are the labels of the alert, eg to silence it.
+	Labels map[string]string
+}
+
+// Alert is an active alert along with its fingerprint.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	ThreadKey   string            `json:"thread_key"`
+	Status      string            `json:"status"`
+	StartsAt    time.Time         `json:"starts_at"`
+	LastSeen    time.Time         `json:"last_seen"`
+	AckedBy     string            `json:"acked_by,omitempty"`
+	AckedAt     time.Time         `json:"acked_at"`
+	MessageID   string            `json:"message_id,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// ExpiresAt is when the alert is pruned from the map. Zero if there's no TTL.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// New returns an empty ActiveAlerts map.
+func New(opts Opts) *ActiveAlerts {
+	return &ActiveAlerts{
+		lo:         opts.Log,
+		metrics:    opts.Metrics,
+		room:       opts.Room,
+		ttl:        opts.TTL,
+		maxEntries: opts.MaxEntries,
+		alerts:     make(map[string]AlertDetails, 0),
+	}
+}
+
+// Add adds an alert to the active alerts map.
+func (d *ActiveAlerts) Add(a alertmgrtmpl.Alert) error {
+	d.Lock()
+	defer d.Unlock()
+
+	// Create a UUID for the alert. This UUID is
+	// sent as a `threadKey` param in G-Chat API.
+	// Set UUID for the alert.
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	// Add the alert metadata to the map.
+	d.alerts[a.Fingerprint] = AlertDetails{
+		UUID:     uid,
+		StartsAt: a.StartsAt,
+		Status:   a.Status,
+		LastSeen: time.Now(),
+		Labels:   a.Labels,
+	}
+	d.touch(a.Fingerprint)
+	d.evict()
+	d.updateSize()
+
+	return nil
+}
+
+// Lookup retrievs the UUID for the alert based on the fingerprint.
+func (d *ActiveAlerts) Lookup(fingerprint string) string {
+	d.RLock()
+	defer d.RUnlock()
+
+	// Do a lookup for the provider by the room name and push the alerts.
+	if _, ok := d.alerts[fingerprint]; !ok {
+		return ""
+	}
+	return d.alerts[fingerprint].UUID.String()
+}
+
+// Get returns the details of the alert.
+func (d *ActiveAlerts) Get(fingerprint string) (AlertDetails, bool) {
+	d.RLock()
+	defer d.RUnlock()
+
+	a, ok := d.alerts[fingerprint]
+	return a, ok
+}
+
+// FindByThreadKey returns the fingerprint of the alert whose thread key is `key`.
+func (d *ActiveAlerts) FindByThreadKey(key string) (string, bool) {
+	d.RLock()
+	defer d.RUnlock()
+
+	for fp, a := range d.alerts {
+		if a.UUID.String() == key {
+			return fp, true
+		}
+	}
+
+	return "", false
+}
+
+// SetStatus records the status and time of the latest notification for the alert.
+func (d *ActiveAlerts) SetStatus(fingerprint, status string, at time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if a, ok := d.alerts[fingerprint]; ok {
+		a.Status = status
+		a.LastSeen = at
+		d.alerts[fingerprint] = a
+		d.touch(fingerprint)
+	}
+}
+
+// SetDelivered records the hash and time of the last delivered notification for the alert.
+func (d *ActiveAlerts) SetDelivered(fingerprint, hash string, at time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if a, ok := d.alerts[fingerprint]; ok {
+		a.Hash = hash
+		a.DeliveredAt = at
+		d.alerts[fingerprint] = a
+	}
+}
+
+// SetMessageID records the provider's ID of the first message for the alert.
+func (d *ActiveAlerts) SetMessageID(fingerprint, id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if a, ok := d.alerts[fingerprint]; ok {
+		a.MessageID = id
+		d.alerts[fingerprint] = a
+	}
+}
+
+// Ack records who acknowledged the alert and when.
+func (d *ActiveAlerts) Ack(fingerprint, by string, at time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a, ok := d.alerts[fingerprint]
+	if !ok {
+		return ErrNotFound
+	}
+	a.AckedBy = by
+	a.AckedAt = at
+	d.alerts[fingerprint] = a
+
+	return nil
+}
+
+// Unacked returns the firing alerts which haven't been acknowledged, oldest first.
+func (d *ActiveAlerts) Unacked() []Alert {
+	d.RLock()
+	defer d.RUnlock()
+
+	out := make([]Alert, 0)
+	for fp, a := range d.alerts {
+		if a.Status != "firing" || a.AckedBy != "" {
+			continue
+		}
+		out = append(out, d.toAlert(fp, a))
+	}
+	sortAlerts(out)
+
+	return out
+}
+
+// All returns all the active alerts, oldest first.
+func (d *ActiveAlerts) All() []Alert {
+	d.RLock()
+	defer d.RUnlock()
+
+	out := make([]Alert, 0, len(d.alerts))
+	for fp, a := range d.alerts {
+		out = append(out, d.toAlert(fp, a))
+	}
+	sortAlerts(out)
+
+	return out
+}
+
+// Alert returns the active alert with the fingerprint.
+func (d *ActiveAlerts) Alert(fingerprint string) (Alert, bool) {
+	d.RLock()
+	defer d.RUnlock()
+
+	a, ok := d.alerts[fingerprint]
+	if !ok {
+		return Alert{}, false
+	}
+	return d.toAlert(fingerprint, a), true
+}
+
+// Delete removes the alert from the map, so that its next
+// notification starts a new thread.
+func (d *ActiveAlerts) Delete(fingerprint string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.alerts[fingerprint]; !ok {
+		return ErrNotFound
+	}
+	d.remove(fingerprint)
+	d.updateSize()
+
+	return nil
+}
+
+// Flush removes all the alerts from the map and returns how many were removed.
+func (d *ActiveAlerts) Flush() int {
+	d.Lock()
+	defer d.Unlock()
+
+	n := len(d.alerts)
+	d.alerts = make(map[string]AlertDetails)
+	d.lru.Init()
+	d.elems = make(map[string]*list.Element)
+	d.updateSize()
+
+	return n
+}
+
+// Validate checks whether the snapshot can be imported.
+func (s Snapshot) Validate() error {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	for room, alerts := range s.Rooms {
+		for _, a := range alerts {
+			if a.Fingerprint == "" {
+				return fmt.Errorf("empty fingerprint in room %s", room)
+			}
+			if _, err := uuid.FromString(a.ThreadKey); err != nil {
+				return fmt.Errorf("invalid thread key for %s in room %s: %w", a.Fingerprint, room, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Import adds the alerts, eg from a Snapshot, to the map. Alerts which are already in
+// the map are kept, unless `replace` is set, in which case the map is replaced with the
+// alerts. Alerts which are past their TTL are dropped. It returns the number of
+// imported and dropped alerts.
+func (d *ActiveAlerts) Import(alerts []Alert, replace bool) (int, int, error) {
+	var (
+		now       = time.Now()
+		imported  = make(map[string]AlertDetails, len(alerts))
+		nDropped  int
+		nImported int
+	)
+
+	// Validate all the alerts before modifying the map.
+	for _, a := range alerts {
+		if a.Fingerprint == "" {
+			return 0, 0, fmt.Errorf("empty fingerprint")
+		}
+		uid, err := uuid.FromString(a.ThreadKey)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid thread key for %s: %w", a.Fingerprint, err)
+		}
+		details := AlertDetails{
+			StartsAt:  a.StartsAt,
+			UUID:      uid,
+			Status:    a.Status,
+			LastSeen:  a.LastSeen,
+			AckedBy:   a.AckedBy,
+			AckedAt:   a.AckedAt,
+			MessageID: a.MessageID,
+			Labels:    a.Labels,
+		}
+		if d.ttl > 0 && details.lastSeen().Add(d.ttl).Before(now) {
+			nDropped++
+			continue
+		}
+		imported[a.Fingerprint] = details
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if replace {
+		d.alerts = make(map[string]AlertDetails, len(imported))
+	}
+	for fp, a := range imported {
+		if _, ok := d.alerts[fp]; ok {
+			nDropped++
+			continue
+		}
+		d.alerts[fp] = a
+		nImported++
+	}
+	d.rebuildLRU()
+	d.evict()
+	d.updateSize()
+
+	return nImported, nDropped, nil
+}
+
+// toAlert converts the map entry to an Alert.
+func (d *ActiveAlerts) toAlert(fingerprint string, a AlertDetails) Alert {
+	out := Alert{
+		Fingerprint: fingerprint,
+		ThreadKey:   a.UUID.String(),
+		Status:      a.Status,
+		StartsAt:    a.StartsAt,
+		LastSeen:    a.lastSeen(),
+		AckedBy:     a.AckedBy,
+		AckedAt:     a.AckedAt,
+		MessageID:   a.MessageID,
+		Labels:      a.Labels,
+	}
+	if d.ttl > 0 {
+		out.ExpiresAt = a.lastSeen().Add(d.ttl)
+	}
+	return out
+}
+
+// lastSeen returns the time of the last notification for the alert. Alerts
+// imported from older snapshots don't have it, so their start time is used.
+func (a AlertDetails) lastSeen() time.Time {
+	if a.LastSeen.IsZero() {
+		return a.StartsAt
+	}
+	return a.LastSeen
+}
+
+// evict removes the least recently seen alerts while the map is over its
+// max entries. The caller must hold the lock.
+func (d *ActiveAlerts) evict() {
+	if d.maxEntries <= 0 {
+		return
+	}
+
+	for len(d.alerts) > d.maxEntries {
+		oldest := d.lru.Back().Value.(string)
+		d.lo.Debug("evicting least recently seen alert from active alerts", "fingerprint", oldest, "last_seen", d.alerts[oldest].lastSeen())
+		d.remove(oldest)
+		if d.metrics != nil {
+			d.metrics.Increment(fmt.Sprintf(`alerts_evicted_total{room="%s"}`, d.room))
+		}
+	}
+}
+
+// touch marks the alert as the most recently seen. The caller must hold the lock.
+func (d *ActiveAlerts) touch(fingerprint string) {
+	if e, ok := d.elems[fingerprint]; ok {
+		d.lru.MoveToFront(e)
+		return
+	}
+	if d.elems == nil {
+		d.elems = make(map[string]*list.Element)
+	}
+	d.elems[fingerprint] = d.lru.PushFront(fingerprint)
+}
+
+// remove deletes the alert from the map. The caller must hold the lock.
+func (d *ActiveAlerts) remove(fingerprint string) {
+	delete(d.alerts, fingerprint)
+	if e, ok := d.elems[fingerprint]; ok {
+		d.lru.Remove(e)
+		delete(d.elems, fingerprint)
+	}
+}
+
+// rebuildLRU orders the alerts by when they were last seen, eg after they're
+// imported with their own last seen times. The caller must hold the lock.
+func (d *ActiveAlerts) rebuildLRU() {
+	fps := make([]string, 0, len(d.alerts))
+	for fp := range d.alerts {
+		fps = append(fps, fp)
+	}
+	sort.Slice(fps, func(i, j int) bool {
+		return d.alerts[fps[i]].lastSeen().Before(d.alerts[fps[j]].lastSeen())
+	})
+
+	d.lru.Init()
+	d.elems = make(map[string]*list.Element, len(fps))
+	for _, fp := range fps {
+		d.elems[fp] = d.lru.PushFront(fp)
+	}
+}
+
+// updateSize exports the size of the map. The caller must hold the lock.
+func (d *ActiveAlerts) updateSize() {
+	if d.metrics == nil {
+		return
+	}
+	d.metrics.Set(fmt.Sprintf(`active_alerts{room="%s"}`, d.room), float64(len(d.alerts)))
+}
+
+// sortAlerts sorts the alerts by their start time, oldest first.
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].StartsAt.Equal(alerts[j].StartsAt) {
+			return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+}
+
+// Prune iterates on a list of active alerts inside the map and deletes
+// the ones which haven't had a notification within the specified TTL.
+func (d *ActiveAlerts) Prune(ttl time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	var (
+		now     = time.Now()
+		expired = now.Add(-ttl)
+	)
+
+	// Iterate on map of active alerts.
+	for k, a := range d.alerts {
+		// If the last notification is past our specified TTL, remove it from the map.
+		// Alerts which keep firing stay in the map, so that they're sent to the same thread.
+		if a.lastSeen().Before(expired) {
+			d.lo.Debug("removing alert from active alerts", "fingerprint", k, "last_seen", a.lastSeen(), "expired", expired)
+			d.remove(k)
+		}
+	}
+	d.updateSize()
+
+	if d.metrics != nil {
+		d.metrics.Duration(`alerts_prune_duration_seconds`, now)
+	}
+
+}
+
+// StartPruneWorker is used to remove active alerts in the
+// map once their TTL is reached. The cleanup activity happens at periodic intervals.
+// This is a blocking function so the caller must invoke as a goroutine.
+// The reason for this background worker is
+// 1) Alertmanager doesn't have any unique ID for a generated alert. The use case is to send
+// all the future alerts for same labels in a same thread. Labels are computed via `.fingerprint` field which is a
+// unique hash based on those labels. The problem here is that all future alerts for same labels will also have same
+// fingerprint. This means that even after the status is Resolved, we will continue posting to same thread if we use this
+// fingerprint. This is undesirable, we ideally want each thread to have the last message as "Resolved".
+// Now since there's no unique field, we maintain a map of active alerts. All the alerts will be stored here for a specified
+// TTL after their last notification.
+// 2) Since we are storing the alerts in a map, this map will continue to grow unbounded.
+// We need to have a TTL based expiry for these map keys. This is the most simple implementation to prune alerts by running this
+// function as a GoRoutine and check if the alert's last notification has crossed our specified TTL. If it has, it'll delete the alert
+// entry from the map. The map is also bounded by `MaxEntries` in between prunes.
+// This check happens at a periodic interval specified by `pruneInterval` by the caller.
+func (d *ActiveAlerts) StartPruneWorker(pruneInterval time.Duration) {
+	var (
+		evalTicker = time.NewTicker(pruneInterval).C
+	)
+
+	for range evalTicker {
+		d.lo.Debug("pruning active alerts based on ttl")
+		d.Prune(d.ttl)
+	}
+}
@@ -0,0 +1,300 @@
+package state
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mr-karan/calert/internal/metrics"
+	alertmgrtmpl "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveAlerts(t *testing.T) {
+	lo := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	t.Run("add and lookup alert", func(t *testing.T) {
+		aa := &ActiveAlerts{
+			alerts: make(map[string]AlertDetails),
+			lo:     lo,
+		}
+
+		alert := alertmgrtmpl.Alert{
+			Fingerprint: "abc123",
+			StartsAt:    time.Now(),
+		}
+
+		err := aa.Add(alert)
+		require.NoError(t, err)
+
+		uuid := aa.Lookup("abc123")
+		assert.NotEmpty(t, uuid)
+		assert.Len(t, uuid, 36)
+	})
+
+	t.Run("lookup non-existent alert returns empty", func(t *testing.T) {
+		aa := &ActiveAlerts{
+			alerts: make(map[string]AlertDetails),
+			lo:     lo,
+		}
+
+		uuid := aa.Lookup("nonexistent")
+		assert.Empty(t, uuid)
+	})
+
+	t.Run("prune removes expired alerts", func(t *testing.T) {
+		m := metrics.New("calert")
+		aa := &ActiveAlerts{
+			alerts:  make(map[string]AlertDetails),
+			lo:      lo,
+			metrics: m,
+		}
+
+		oldAlert := alertmgrtmpl.Alert{
+			Fingerprint: "old",
+			StartsAt:    time.Now().Add(-2 * time.Hour),
+		}
+		newAlert := alertmgrtmpl.Alert{
+			Fingerprint: "new",
+			StartsAt:    time.Now(),
+		}
+		longRunningAlert := alertmgrtmpl.Alert{
+			Fingerprint: "long_running",
+			StartsAt:    time.Now().Add(-2 * time.Hour),
+		}
+
+		aa.Add(oldAlert)
+		aa.Add(newAlert)
+		aa.Add(longRunningAlert)
+		setLastSeen(aa, "old", time.Now().Add(-2*time.Hour))
+		setLastSeen(aa, "long_running", time.Now().Add(-2*time.Hour))
+		// Alerts which are still being notified about aren't pruned.
+		aa.SetStatus("long_running", "firing", time.Now())
+
+		assert.Len(t, aa.alerts, 3)
+
+		aa.Prune(1 * time.Hour)
+
+		assert.Len(t, aa.alerts, 2)
+		assert.Empty(t, aa.Lookup("old"))
+		assert.NotEmpty(t, aa.Lookup("new"))
+		assert.NotEmpty(t, aa.Lookup("long_running"))
+	})
+}
+
+// setLastSeen sets the time of the last notification for an alert.
+func setLastSeen(aa *ActiveAlerts, fingerprint string, at time.Time) {
+	aa.Lock()
+	defer aa.Unlock()
+
+	a := aa.alerts[fingerprint]
+	a.LastSeen = at
+	aa.alerts[fingerprint] = a
+}
+
+func TestAck(t *testing.T) {
+	var (
+		aa  = New(Opts{Log: slog.New(slog.NewJSONHandler(os.Stdout, nil)), Metrics: metrics.New("calert")})
+		now = time.Now()
+	)
+
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "new", Status: "firing", StartsAt: now}))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "old", Status: "firing", StartsAt: now.Add(-time.Hour)}))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "resolved", Status: "firing", StartsAt: now}))
+	aa.SetStatus("resolved", "resolved", now)
+
+	unacked := aa.Unacked()
+	require.Len(t, unacked, 2)
+	assert.Equal(t, "old", unacked[0].Fingerprint)
+	assert.Equal(t, aa.Lookup("old"), unacked[0].ThreadKey)
+	assert.Equal(t, "new", unacked[1].Fingerprint)
+
+	require.NoError(t, aa.Ack("old", "Jane Doe", now))
+	unacked = aa.Unacked()
+	require.Len(t, unacked, 1)
+	assert.Equal(t, "new", unacked[0].Fingerprint)
+
+	a, ok := aa.Get("old")
+	require.True(t, ok)
+	assert.Equal(t, "Jane Doe", a.AckedBy)
+
+	assert.ErrorIs(t, aa.Ack("missing", "Jane Doe", now), ErrNotFound)
+
+	fp, ok := aa.FindByThreadKey(aa.Lookup("new"))
+	assert.True(t, ok)
+	assert.Equal(t, "new", fp)
+	_, ok = aa.FindByThreadKey("missing")
+	assert.False(t, ok)
+}
+
+func TestManage(t *testing.T) {
+	var (
+		aa = New(Opts{
+			Log:     slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+			Metrics: metrics.New("calert"),
+			TTL:     time.Hour,
+		})
+		now = time.Now()
+	)
+
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "new", Status: "firing", StartsAt: now}))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "old", Status: "resolved", StartsAt: now.Add(-time.Minute)}))
+
+	all := aa.All()
+	require.Len(t, all, 2)
+	assert.Equal(t, "old", all[0].Fingerprint)
+	assert.Equal(t, "new", all[1].Fingerprint)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), all[1].ExpiresAt, time.Second)
+	assert.Equal(t, all[1].LastSeen.Add(time.Hour), all[1].ExpiresAt)
+
+	a, ok := aa.Alert("old")
+	require.True(t, ok)
+	assert.Equal(t, aa.Lookup("old"), a.ThreadKey)
+	assert.Equal(t, "resolved", a.Status)
+
+	// Deleted alerts start a new thread.
+	threadKey := aa.Lookup("new")
+	require.NoError(t, aa.Delete("new"))
+	assert.ErrorIs(t, aa.Delete("new"), ErrNotFound)
+	_, ok = aa.Alert("new")
+	assert.False(t, ok)
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "new", Status: "firing", StartsAt: now}))
+	assert.NotEqual(t, threadKey, aa.Lookup("new"))
+
+	assert.Equal(t, 2, aa.Flush())
+	assert.Empty(t, aa.All())
+}
+
+func TestImport(t *testing.T) {
+	newAlerts := func() *ActiveAlerts {
+		return New(Opts{
+			Log:     slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+			Metrics: metrics.New("calert"),
+			TTL:     time.Hour,
+		})
+	}
+	var (
+		src = newAlerts()
+		now = time.Now()
+	)
+	require.NoError(t, src.Add(alertmgrtmpl.Alert{Fingerprint: "abc", Status: "firing", StartsAt: now}))
+	require.NoError(t, src.Add(alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", StartsAt: now}))
+	require.NoError(t, src.Add(alertmgrtmpl.Alert{Fingerprint: "expired", Status: "firing", StartsAt: now.Add(-2 * time.Hour)}))
+	require.NoError(t, src.Ack("abc", "Jane Doe", now))
+	src.SetMessageID("abc", "post-1")
+	setLastSeen(src, "expired", now.Add(-2*time.Hour))
+
+	t.Run("merge", func(t *testing.T) {
+		dst := newAlerts()
+		require.NoError(t, dst.Add(alertmgrtmpl.Alert{Fingerprint: "def", Status: "firing", StartsAt: now}))
+		threadKey := dst.Lookup("def")
+
+		imported, dropped, err := dst.Import(src.All(), false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, imported)
+		assert.Equal(t, 2, dropped)
+
+		// Imported alerts keep their threads and acks.
+		a, ok := dst.Alert("abc")
+		require.True(t, ok)
+		assert.Equal(t, src.Lookup("abc"), a.ThreadKey)
+		assert.Equal(t, "Jane Doe", a.AckedBy)
+		assert.Equal(t, "post-1", a.MessageID)
+		// Existing alerts are kept.
+		assert.Equal(t, threadKey, dst.Lookup("def"))
+		assert.Empty(t, dst.Lookup("expired"))
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		dst := newAlerts()
+		require.NoError(t, dst.Add(alertmgrtmpl.Alert{Fingerprint: "ghi", Status: "firing", StartsAt: now}))
+
+		imported, dropped, err := dst.Import(src.All(), true)
+		require.NoError(t, err)
+		assert.Equal(t, 2, imported)
+		assert.Equal(t, 1, dropped)
+		assert.Empty(t, dst.Lookup("ghi"))
+		assert.Equal(t, src.Lookup("def"), dst.Lookup("def"))
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		snap := Snapshot{Version: SnapshotVersion, Rooms: map[string][]Alert{"prod": src.All()}}
+		assert.NoError(t, snap.Validate())
+
+		snap.Version = SnapshotVersion + 1
+		assert.Error(t, snap.Validate())
+
+		snap = Snapshot{Version: SnapshotVersion, Rooms: map[string][]Alert{"prod": {{Fingerprint: "abc", ThreadKey: "invalid"}}}}
+		assert.Error(t, snap.Validate())
+		_, _, err := newAlerts().Import(snap.Rooms["prod"], false)
+		assert.Error(t, err)
+	})
+}
+
+func TestMaxEntries(t *testing.T) {
+	var (
+		m  = metrics.New("calert")
+		aa = New(Opts{
+			Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+			Metrics:    m,
+			Room:       "prod",
+			TTL:        time.Hour,
+			MaxEntries: 2,
+		})
+		now = time.Now()
+	)
+
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "a", Status: "firing", StartsAt: now}))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "b", Status: "firing", StartsAt: now}))
+	setLastSeen(aa, "a", now.Add(-2*time.Minute))
+	setLastSeen(aa, "b", now.Add(-time.Minute))
+	// A new notification for `a` makes `b` the least recently seen alert.
+	aa.SetStatus("a", "firing", now)
+
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "c", Status: "firing", StartsAt: now}))
+	assert.Len(t, aa.All(), 2)
+	assert.NotEmpty(t, aa.Lookup("a"))
+	assert.Empty(t, aa.Lookup("b"))
+	assert.NotEmpty(t, aa.Lookup("c"))
+
+	var buf bytes.Buffer
+	m.FlushMetrics(&buf)
+	assert.Contains(t, buf.String(), `calert_active_alerts{room="prod"} 2`)
+	assert.Contains(t, buf.String(), `calert_alerts_evicted_total{room="prod"} 1`)
+}
+
+func TestMaxEntriesImport(t *testing.T) {
+	aa := New(Opts{
+		Log:        slog.New(slog.NewJSONHandler(os.Stdout, nil)),
+		TTL:        time.Hour,
+		MaxEntries: 2,
+	})
+	now := time.Now()
+
+	// Imported alerts are evicted by their own last seen times, not the order of the snapshot.
+	n, dropped, err := aa.Import([]Alert{
+		{Fingerprint: "a", ThreadKey: "0b7e7d28-8a4b-4bd6-9b5a-2f0b5c1f4a01", Status: "firing", LastSeen: now.Add(-time.Minute)},
+		{Fingerprint: "b", ThreadKey: "0b7e7d28-8a4b-4bd6-9b5a-2f0b5c1f4a02", Status: "firing", LastSeen: now.Add(-3 * time.Minute)},
+		{Fingerprint: "c", ThreadKey: "0b7e7d28-8a4b-4bd6-9b5a-2f0b5c1f4a03", Status: "firing", LastSeen: now.Add(-2 * time.Minute)},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, dropped)
+	assert.Empty(t, aa.Lookup("b"))
+
+	// The least recently seen alert is evicted next, and deleted alerts are forgotten.
+	require.NoError(t, aa.Delete("a"))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "d", Status: "firing", StartsAt: now}))
+	require.NoError(t, aa.Add(alertmgrtmpl.Alert{Fingerprint: "e", Status: "firing", StartsAt: now}))
+	assert.Empty(t, aa.Lookup("c"))
+	assert.NotEmpty(t, aa.Lookup("d"))
+	assert.NotEmpty(t, aa.Lookup("e"))
+
+	// Pruning works without metrics.
+	setLastSeen(aa, "d", now.Add(-2*time.Hour))
+	aa.Prune(time.Hour)
+	assert.Len(t, aa.All(), 1)
+}